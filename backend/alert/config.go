@@ -0,0 +1,197 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	DefaultEvaluateInterval = 10 * time.Second
+	MinEvaluateInterval     = 1 * time.Second
+)
+
+var (
+	ErrRuleNameEmpty       = errors.New("alert rule name is empty")
+	ErrRuleNameDuplicated  = errors.New("alert rule name is duplicated")
+	ErrRuleMetricUnknown   = errors.New("alert rule metric is unknown")
+	ErrRuleOperatorInvalid = errors.New("alert rule operator must be one of > >= < <= == !=")
+	ErrRuleLabelMissing    = errors.New("alert rule label is required by metric")
+	ErrWebhookUrlEmpty     = errors.New("alert webhook url is empty")
+)
+
+// Duration 在 json 里写成 "2m" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string like \"2m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Rule struct {
+	Name      string                `json:"name"`
+	Metric    model.AlertMetricName `json:"metric"`
+	Labels    map[string]string     `json:"labels,omitempty"` // 只匹配标签都相同的指标
+	Operator  string                `json:"operator"`
+	Threshold float64               `json:"threshold"`
+	// 持续满足条件多久才触发
+	For Duration `json:"for,omitempty"`
+	// 回差 : 触发后要越过这个值才算恢复 , 不填等于 threshold
+	RecoverThreshold *float64 `json:"recover_threshold,omitempty"`
+	// 持续恢复多久才算解除 , 防止在阈值附近反复触发
+	RecoverFor Duration `json:"recover_for,omitempty"`
+	Severity   string   `json:"severity,omitempty"`
+	Summary    string   `json:"summary,omitempty"`
+}
+
+type Webhook struct {
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Go text/template 模板 , 数据是 model.AlertNotification , 不填时直接发送它的 json
+	Template string `json:"template,omitempty"`
+	template *template.Template
+}
+
+type Config struct {
+	Interval Duration  `json:"interval,omitempty"`
+	Rules    []Rule    `json:"rules"`
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+}
+
+var requiredLabels = map[model.AlertMetricName]string{
+	model.AlertMetricFilesystemUsedPercent: "mount",
+	model.AlertMetricLinkUp:                "interface",
+}
+
+var knownMetrics = map[model.AlertMetricName]bool{
+	model.AlertMetricCpuTemperature:        true,
+	model.AlertMetricCpuUsage:              true,
+	model.AlertMetricMemoryUsedPercent:     true,
+	model.AlertMetricFilesystemUsedPercent: true,
+	model.AlertMetricConntrackUsedPercent:  true,
+	model.AlertMetricLinkUp:                true,
+	model.AlertMetricHostRate:              true,
+}
+
+func LoadConfig(fs afero.Fs, path string) (*Config, error) {
+	data, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Decode alert config failed: %w", err)
+	}
+	if config.Interval == 0 {
+		config.Interval = Duration(DefaultEvaluateInterval)
+	}
+	config.Interval = max(config.Interval, Duration(MinEvaluateInterval))
+
+	names := make(map[string]bool, len(config.Rules))
+	for index := range config.Rules {
+		rule := &config.Rules[index]
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d %q: %w", index, rule.Name, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, ErrRuleNameDuplicated)
+		}
+		names[rule.Name] = true
+	}
+
+	for index := range config.Webhooks {
+		webhook := &config.Webhooks[index]
+		if strings.TrimSpace(webhook.Url) == "" {
+			return nil, fmt.Errorf("webhook %d: %w", index, ErrWebhookUrlEmpty)
+		}
+		if webhook.Template == "" {
+			continue
+		}
+		parsed, err := template.New(webhook.Url).Funcs(templateFuncs).Parse(webhook.Template)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %d template: %w", index, err)
+		}
+		webhook.template = parsed
+	}
+	return config, nil
+}
+
+func (r *Rule) validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return ErrRuleNameEmpty
+	}
+	if !knownMetrics[r.Metric] {
+		return ErrRuleMetricUnknown
+	}
+	if !isValidOperator(r.Operator) {
+		return ErrRuleOperatorInvalid
+	}
+	if label, ok := requiredLabels[r.Metric]; ok && r.Labels[label] == "" {
+		return fmt.Errorf("%w: %s", ErrRuleLabelMissing, label)
+	}
+	return nil
+}
+
+func (r *Rule) recoverThreshold() float64 {
+	if r.RecoverThreshold != nil {
+		return *r.RecoverThreshold
+	}
+	return r.Threshold
+}
+
+func isValidOperator(operator string) bool {
+	switch operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+var templateFuncs = template.FuncMap{
+	// 把任意值编码成 json , 模板里拼字符串时用它来转义
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
@@ -0,0 +1,296 @@
+// Package alert 按用户定义的规则持续评估指标 , 并把告警的触发和恢复推送到 webhook
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+const (
+	AlertResolvedRetention = 1 * time.Hour // 已恢复的告警在 /alerts 里保留多久
+	AlertWebhookTimeout    = 5 * time.Second
+	AlertWebhookRetry      = 3
+	AlertWebhookRetryDelay = 2 * time.Second
+)
+
+// SampleCollectorInterface 按规则的需要采集指标
+type SampleCollectorInterface interface {
+	CollectAlertSamples(requests []model.AlertSampleRequest) []model.AlertSample
+}
+
+type alertInstance struct {
+	alert        model.Alert
+	recoverSince time.Time
+}
+
+type Engine struct {
+	config     *Config
+	collector  SampleCollectorInterface
+	requests   []model.AlertSampleRequest
+	client     *http.Client
+	routerName string
+	mutex      sync.RWMutex
+	instances  map[string]*alertInstance
+	// 在 Evaluate 里同步调用 , 不能阻塞 , 方便测试替换
+	notify func(notification model.AlertNotification)
+}
+
+func NewEngine(config *Config, collector SampleCollectorInterface) *Engine {
+	routerName, _ := os.Hostname()
+	e := &Engine{
+		config:     config,
+		collector:  collector,
+		client:     &http.Client{Timeout: AlertWebhookTimeout},
+		routerName: routerName,
+		instances:  make(map[string]*alertInstance),
+	}
+	e.notify = func(notification model.AlertNotification) {
+		go e.sendWebhooks(notification)
+	}
+	for _, rule := range config.Rules {
+		e.requests = append(e.requests, model.AlertSampleRequest{
+			Metric: rule.Metric,
+			Labels: rule.Labels,
+		})
+	}
+	return e
+}
+
+// Run 按固定间隔评估规则 , 不管有没有人打开面板
+func (e *Engine) Run(ctx context.Context) {
+	interval := time.Duration(e.config.Interval)
+	log.Printf("Enable alert engine with %d rules , evaluate every %v", len(e.config.Rules), interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate(e.collector.CollectAlertSamples(e.requests), time.Now())
+		}
+	}
+}
+
+func (e *Engine) Evaluate(samples []model.AlertSample, now time.Time) {
+	e.mutex.Lock()
+	var notifications []model.AlertNotification
+	for index := range e.config.Rules {
+		rule := &e.config.Rules[index]
+		seen := make(map[string]bool)
+		for _, sample := range samples {
+			if sample.Metric != rule.Metric || !labelsMatch(rule.Labels, sample.Labels) {
+				continue
+			}
+			key := instanceKey(rule.Name, sample.Labels)
+			seen[key] = true
+			if notification, ok := e.step(rule, key, sample.Labels, sample.Value, true, now); ok {
+				notifications = append(notifications, notification)
+			}
+		}
+		// 没有采集到的序列 (比如 ip 已经被回收) 按已恢复处理
+		for key, instance := range e.instances {
+			if instance.alert.Rule != rule.Name || seen[key] {
+				continue
+			}
+			if notification, ok := e.step(rule, key, instance.alert.Labels, instance.alert.Value, false, now); ok {
+				notifications = append(notifications, notification)
+			}
+		}
+	}
+	e.mutex.Unlock()
+
+	for _, notification := range notifications {
+		e.notify(notification)
+	}
+}
+
+// step 推进一个告警序列的状态机 , 需要通知时返回 true
+//
+//	inactive -> pending -> firing -> resolved
+//	              |                    |
+//	              +-> inactive         +-> pending (再次触发)
+func (e *Engine) step(rule *Rule, key string, labels map[string]string, value float64, hasValue bool, now time.Time) (model.AlertNotification, bool) {
+	triggered := hasValue && compare(value, rule.Operator, rule.Threshold)
+	recovered := !hasValue || !compare(value, rule.Operator, rule.recoverThreshold())
+
+	instance, exist := e.instances[key]
+	if exist && instance.alert.State == model.AlertStateResolved {
+		if now.Sub(instance.alert.ResolvedAt) > AlertResolvedRetention {
+			delete(e.instances, key)
+			exist = false
+		} else if triggered {
+			exist = false
+		}
+	}
+
+	if !exist {
+		if !triggered {
+			return model.AlertNotification{}, false
+		}
+		instance = &alertInstance{alert: model.Alert{
+			Rule:      rule.Name,
+			Metric:    rule.Metric,
+			Labels:    maps.Clone(labels),
+			Severity:  rule.Severity,
+			Summary:   rule.Summary,
+			State:     model.AlertStatePending,
+			Operator:  rule.Operator,
+			Threshold: rule.Threshold,
+			ActiveAt:  now,
+		}}
+		e.instances[key] = instance
+	}
+	if hasValue {
+		instance.alert.Value = value
+	}
+
+	switch instance.alert.State {
+	case model.AlertStatePending:
+		if !triggered {
+			delete(e.instances, key)
+			return model.AlertNotification{}, false
+		}
+		if now.Sub(instance.alert.ActiveAt) >= time.Duration(rule.For) {
+			instance.alert.State = model.AlertStateFiring
+			instance.alert.FiredAt = now
+			return e.notification(instance.alert, now), true
+		}
+	case model.AlertStateFiring:
+		if !recovered {
+			instance.recoverSince = time.Time{}
+			return model.AlertNotification{}, false
+		}
+		if instance.recoverSince.IsZero() {
+			instance.recoverSince = now
+		}
+		if now.Sub(instance.recoverSince) >= time.Duration(rule.RecoverFor) {
+			instance.alert.State = model.AlertStateResolved
+			instance.alert.ResolvedAt = now
+			return e.notification(instance.alert, now), true
+		}
+	}
+	return model.AlertNotification{}, false
+}
+
+func (e *Engine) notification(alert model.Alert, now time.Time) model.AlertNotification {
+	alert.Labels = maps.Clone(alert.Labels)
+	return model.AlertNotification{
+		Router: e.routerName,
+		SentAt: now,
+		Alert:  alert,
+	}
+}
+
+// Alerts 返回当前的告警 , 触发中的排在最前面
+func (e *Engine) Alerts() []model.Alert {
+	e.mutex.RLock()
+	result := make([]model.Alert, 0, len(e.instances))
+	for _, instance := range e.instances {
+		alert := instance.alert
+		alert.Labels = maps.Clone(alert.Labels)
+		result = append(result, alert)
+	}
+	e.mutex.RUnlock()
+
+	stateOrder := map[model.AlertState]int{
+		model.AlertStateFiring:   0,
+		model.AlertStatePending:  1,
+		model.AlertStateResolved: 2,
+	}
+	slices.SortFunc(result, func(a, b model.Alert) int {
+		if a.State != b.State {
+			return stateOrder[a.State] - stateOrder[b.State]
+		}
+		if c := b.ActiveAt.Compare(a.ActiveAt); c != 0 {
+			return c
+		}
+		return strings.Compare(instanceKey(a.Rule, a.Labels), instanceKey(b.Rule, b.Labels))
+	})
+	return result
+}
+
+func (e *Engine) sendWebhooks(notification model.AlertNotification) {
+	for _, webhook := range e.config.Webhooks {
+		body, err := renderWebhookBody(&webhook, notification)
+		if err != nil {
+			log.Printf("Render alert webhook body for %q failed: %s", webhook.Url, err)
+			continue
+		}
+		for attempt := range AlertWebhookRetry {
+			if attempt > 0 {
+				time.Sleep(AlertWebhookRetryDelay * time.Duration(attempt))
+			}
+			if err = e.post(&webhook, body); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Send alert %q to webhook %q failed: %s", notification.Alert.Rule, webhook.Url, err)
+		}
+	}
+}
+
+func renderWebhookBody(webhook *Webhook, notification model.AlertNotification) ([]byte, error) {
+	if webhook.template == nil {
+		return json.Marshal(notification)
+	}
+	var buffer bytes.Buffer
+	if err := webhook.template.Execute(&buffer, notification); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buffer.Bytes()) {
+		return nil, fmt.Errorf("template output is not valid json: %s", buffer.String())
+	}
+	return buffer.Bytes(), nil
+}
+
+func (e *Engine) post(webhook *Webhook, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range webhook.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func labelsMatch(want map[string]string, labels map[string]string) bool {
+	for key, value := range want {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func instanceKey(rule string, labels map[string]string) string {
+	var builder strings.Builder
+	builder.WriteString(rule)
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		builder.WriteString("," + key + "=" + labels[key])
+	}
+	return builder.String()
+}
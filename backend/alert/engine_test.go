@@ -0,0 +1,170 @@
+package alert
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEngine(t *testing.T, rawConfig string) (*Engine, *[]model.AlertNotification) {
+	config, err := ParseConfig([]byte(rawConfig))
+	assert.NoError(t, err)
+	e := NewEngine(config, nil)
+	notifications := &[]model.AlertNotification{}
+	e.notify = func(notification model.AlertNotification) {
+		*notifications = append(*notifications, notification)
+	}
+	return e, notifications
+}
+
+func temperature(value float64) []model.AlertSample {
+	return []model.AlertSample{{Metric: model.AlertMetricCpuTemperature, Value: value}}
+}
+
+func TestEngineStateMachine(t *testing.T) {
+	e, notifications := newTestEngine(t, `{"rules": [{
+		"name": "cpu_hot", "metric": "cpu_temperature", "operator": ">", "threshold": 80,
+		"for": "2m", "recover_threshold": 75, "recover_for": "1m"
+	}]}`)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	e.Evaluate(temperature(85), start)
+	assert.Equal(t, model.AlertStatePending, e.Alerts()[0].State)
+
+	// pending 期间恢复不通知 , 直接清除
+	e.Evaluate(temperature(70), start.Add(time.Minute))
+	assert.Empty(t, e.Alerts())
+
+	e.Evaluate(temperature(85), start.Add(2*time.Minute))
+	e.Evaluate(temperature(86), start.Add(4*time.Minute))
+	alerts := e.Alerts()
+	assert.Equal(t, model.AlertStateFiring, alerts[0].State)
+	assert.Equal(t, 86.0, alerts[0].Value)
+	assert.Len(t, *notifications, 1)
+
+	// 回差 : 低于 80 但没低于 75 仍然在触发
+	e.Evaluate(temperature(78), start.Add(5*time.Minute))
+	assert.Equal(t, model.AlertStateFiring, e.Alerts()[0].State)
+
+	// 恢复时间不够又回升 , 重新计时
+	e.Evaluate(temperature(70), start.Add(6*time.Minute))
+	e.Evaluate(temperature(79), start.Add(6*time.Minute+30*time.Second))
+	e.Evaluate(temperature(70), start.Add(7*time.Minute))
+	e.Evaluate(temperature(70), start.Add(7*time.Minute+30*time.Second))
+	assert.Equal(t, model.AlertStateFiring, e.Alerts()[0].State)
+
+	e.Evaluate(temperature(70), start.Add(8*time.Minute))
+	alerts = e.Alerts()
+	assert.Equal(t, model.AlertStateResolved, alerts[0].State)
+	assert.Equal(t, start.Add(8*time.Minute), alerts[0].ResolvedAt)
+	assert.Len(t, *notifications, 2)
+	assert.Equal(t, model.AlertStateFiring, (*notifications)[0].Alert.State)
+	assert.Equal(t, model.AlertStateResolved, (*notifications)[1].Alert.State)
+
+	// 已恢复的告警保留一段时间后清除
+	e.Evaluate(temperature(70), start.Add(8*time.Minute+AlertResolvedRetention+time.Second))
+	assert.Empty(t, e.Alerts())
+}
+
+func TestEngineLabels(t *testing.T) {
+	e, notifications := newTestEngine(t, `{"rules": [{
+		"name": "lan_heavy", "metric": "host_rate", "labels": {"ip_type": "lan"}, "operator": ">", "threshold": 100
+	}]}`)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	hostRate := func(ip string, ipType string, value float64) model.AlertSample {
+		return model.AlertSample{
+			Metric: model.AlertMetricHostRate,
+			Labels: map[string]string{"ip": ip, "ip_type": ipType},
+			Value:  value,
+		}
+	}
+
+	e.Evaluate([]model.AlertSample{
+		hostRate("192.168.1.10", "lan", 200),
+		hostRate("192.168.1.11", "lan", 50),
+		hostRate("1.1.1.1", "wan", 200),
+	}, now)
+	alerts := e.Alerts()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "192.168.1.10", alerts[0].Labels["ip"])
+	assert.Equal(t, model.AlertStateFiring, alerts[0].State)
+
+	// ip 消失后按已恢复处理
+	e.Evaluate(nil, now.Add(time.Minute))
+	assert.Equal(t, model.AlertStateResolved, e.Alerts()[0].State)
+	assert.Len(t, *notifications, 2)
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payload := map[string]any{"authorization": r.Header.Get("Authorization")}
+		_ = json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig([]byte(`{
+		"rules": [{"name": "wan_down", "metric": "link_up", "labels": {"interface": "wan"}, "operator": "==", "threshold": 0}],
+		"webhooks": [{
+			"url": "` + server.URL + `",
+			"headers": {"Authorization": "Bearer token"},
+			"template": "{\"text\": {{ printf \"%s is %s\" .Alert.Rule .Alert.State | json }}}"
+		}]
+	}`))
+	assert.NoError(t, err)
+	e := NewEngine(config, nil)
+	e.Evaluate([]model.AlertSample{{
+		Metric: model.AlertMetricLinkUp,
+		Labels: map[string]string{"interface": "wan"},
+		Value:  0,
+	}}, time.Now())
+
+	select {
+	case payload := <-received:
+		assert.Equal(t, "wan_down is firing", payload["text"])
+		assert.Equal(t, "Bearer token", payload["authorization"])
+	case <-time.After(time.Second):
+		t.Fatal("webhook not received")
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, item := range []struct {
+		raw string
+		err error
+	}{
+		{`{"rules": [{"metric": "cpu_usage", "operator": ">"}]}`, ErrRuleNameEmpty},
+		{`{"rules": [{"name": "a", "metric": "disk", "operator": ">"}]}`, ErrRuleMetricUnknown},
+		{`{"rules": [{"name": "a", "metric": "cpu_usage", "operator": "=>"}]}`, ErrRuleOperatorInvalid},
+		{`{"rules": [{"name": "a", "metric": "link_up", "operator": "=="}]}`, ErrRuleLabelMissing},
+		{`{"rules": [{"name": "a", "metric": "cpu_usage", "operator": ">"}, {"name": "a", "metric": "cpu_usage", "operator": "<"}]}`, ErrRuleNameDuplicated},
+		{`{"rules": [], "webhooks": [{"url": " "}]}`, ErrWebhookUrlEmpty},
+	} {
+		_, err := ParseConfig([]byte(item.raw))
+		assert.ErrorIs(t, err, item.err, item.raw)
+	}
+
+	_, err := ParseConfig([]byte(`{"rules": [{"name": "a", "metric": "cpu_usage", "operator": ">", "for": 10}]}`))
+	assert.Error(t, err)
+
+	config, err := ParseConfig([]byte(`{"rules": []}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(DefaultEvaluateInterval), config.Interval)
+
+	// 示例配置要一直能用
+	example, err := os.ReadFile("../../scripts/etc/diskio-api/alerts.example.json")
+	assert.NoError(t, err)
+	config, err = ParseConfig(example)
+	assert.NoError(t, err)
+	assert.Len(t, config.Rules, 5)
+}
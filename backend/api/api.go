@@ -0,0 +1,155 @@
+// Package api 提供 /api/v1 统一的返回格式 , 同一个处理函数也可以包装成旧接口的格式
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+const V1Prefix = "/api/v1"
+
+// Error 同时决定 http 状态码和返回里的错误码
+type Error struct {
+	Status  int
+	Code    model.ApiErrorCode
+	Message string
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + " : " + e.Message
+}
+
+func NewError(status int, code model.ApiErrorCode, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return NewError(http.StatusBadRequest, model.ApiErrorBadRequest, message)
+}
+
+func Disabled(message string) *Error {
+	return NewError(http.StatusNotFound, model.ApiErrorDisabled, message)
+}
+
+// Result 是处理函数的返回值
+type Result struct {
+	Data any
+	// 已经编码好的 json , 不为空时忽略 Data
+	Raw []byte
+	// Raw 预先 gzip 压缩好的版本 , 只有旧接口直接返回 Raw 时能用上
+	RawGzip []byte
+	// 为零时使用当前时间 , 不为零时设置 ETag 和 Last-Modified , 支持条件请求
+	GeneratedAt time.Time
+	// 为零时表示数据没有过期的概念 , 否则用来计算 Cache-Control
+	ExpireAt time.Time
+	// 有数据但是最近一次采集失败 , 旧接口忽略它
+	Warning *Error
+}
+
+type HandlerFunc func(r *http.Request) (Result, *Error)
+
+// V1 包装成 /api/v1 的格式 , 错误也返回 json
+func V1(fn HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteEnvelope(w, r, Result{}, NewError(http.StatusMethodNotAllowed, model.ApiErrorMethodNotAllowed, "only GET"))
+			return
+		}
+		result, apiErr := fn(r)
+		if apiErr == nil && !result.GeneratedAt.IsZero() {
+			// 信封里的 stale 和 error 不随数据更新而变化 , 也要算进 ETag
+			var variants []string
+			if !result.ExpireAt.IsZero() && time.Now().After(result.ExpireAt) {
+				variants = append(variants, "stale")
+			}
+			if result.Warning != nil {
+				variants = append(variants, string(result.Warning.Code))
+			}
+			etag := ETag(result.GeneratedAt, strings.Join(variants, "-"))
+			if WriteCacheHeaders(w, r, etag, result.GeneratedAt, result.ExpireAt) {
+				WriteNotModified(w)
+				return
+			}
+		}
+		WriteEnvelope(w, r, result, apiErr)
+	}
+}
+
+// Legacy 包装成旧接口的格式 : 成功时直接返回数据 , 失败时返回纯文本
+func Legacy(fn HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET", http.StatusMethodNotAllowed)
+			return
+		}
+		result, apiErr := fn(r)
+		if apiErr != nil {
+			http.Error(w, apiErr.Message, apiErr.Status)
+			return
+		}
+		if !result.GeneratedAt.IsZero() && WriteCacheHeaders(w, r, ETag(result.GeneratedAt, ""), result.GeneratedAt, result.ExpireAt) {
+			WriteNotModified(w)
+			return
+		}
+		if len(result.Raw) > 0 {
+			WriteJson(w, r, http.StatusOK, result.Raw, result.RawGzip)
+			return
+		}
+		data, err := json.Marshal(result.Data)
+		if err != nil {
+			http.Error(w, "json marshal error : "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		WriteJson(w, r, http.StatusOK, append(data, '\n'), nil)
+	}
+}
+
+func WriteEnvelope(w http.ResponseWriter, r *http.Request, result Result, apiErr *Error) {
+	now := time.Now().UTC()
+	envelope := model.ApiEnvelope{GeneratedAt: now}
+	status := http.StatusOK
+
+	if apiErr != nil {
+		status = apiErr.Status
+		envelope.Error = apiErr.Code
+		envelope.Message = apiErr.Message
+	} else {
+		if !result.GeneratedAt.IsZero() {
+			envelope.GeneratedAt = result.GeneratedAt.UTC()
+			envelope.StalenessSeconds = max(now.Sub(result.GeneratedAt).Seconds(), 0)
+		}
+		envelope.Stale = !result.ExpireAt.IsZero() && now.After(result.ExpireAt)
+		if result.Warning != nil {
+			envelope.Error = result.Warning.Code
+			envelope.Message = result.Warning.Message
+		}
+
+		envelope.Data = result.Raw
+		if len(envelope.Data) == 0 {
+			data, err := json.Marshal(result.Data)
+			if err != nil {
+				log.Printf("Encode api response failed: %s", err)
+				status = http.StatusInternalServerError
+				envelope = model.ApiEnvelope{
+					Error:       model.ApiErrorInternal,
+					Message:     "json marshal error : " + err.Error(),
+					GeneratedAt: now,
+				}
+			} else {
+				envelope.Data = data
+			}
+		}
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		http.Error(w, "json marshal error : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	WriteJson(w, r, status, append(body, '\n'), nil)
+}
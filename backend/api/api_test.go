@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(handler http.HandlerFunc, method string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(method, "/api/v1/test", nil))
+	return recorder
+}
+
+func decodeEnvelope(t *testing.T, recorder *httptest.ResponseRecorder) model.ApiEnvelope {
+	var envelope model.ApiEnvelope
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &envelope))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	return envelope
+}
+
+func TestV1Envelope(t *testing.T) {
+	updateAt := time.Now().Add(-3 * time.Second)
+	recorder := serve(V1(func(r *http.Request) (Result, *Error) {
+		return Result{
+			Raw:         []byte(`{"value":0}`),
+			GeneratedAt: updateAt,
+			ExpireAt:    updateAt.Add(time.Second),
+		}, nil
+	}), http.MethodGet)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	envelope := decodeEnvelope(t, recorder)
+	assert.JSONEq(t, `{"value":0}`, string(envelope.Data))
+	assert.Empty(t, envelope.Error)
+	assert.True(t, envelope.Stale)
+	assert.InDelta(t, 3, envelope.StalenessSeconds, 1)
+	assert.WithinDuration(t, updateAt, envelope.GeneratedAt, time.Millisecond)
+}
+
+func TestV1EnvelopeError(t *testing.T) {
+	recorder := serve(V1(func(r *http.Request) (Result, *Error) {
+		return Result{}, NewError(http.StatusServiceUnavailable, model.ApiErrorNoData, "no data yet")
+	}), http.MethodGet)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	envelope := decodeEnvelope(t, recorder)
+	assert.Equal(t, model.ApiErrorNoData, envelope.Error)
+	assert.Equal(t, "no data yet", envelope.Message)
+	assert.JSONEq(t, `null`, string(envelope.Data))
+
+	// 有数据但是采集失败 , 仍然返回数据
+	recorder = serve(V1(func(r *http.Request) (Result, *Error) {
+		return Result{
+			Data:    []int{},
+			Warning: NewError(http.StatusServiceUnavailable, model.ApiErrorCollectorFailed, "network_connection : permission denied"),
+		}, nil
+	}), http.MethodGet)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	envelope = decodeEnvelope(t, recorder)
+	assert.Equal(t, model.ApiErrorCollectorFailed, envelope.Error)
+	assert.JSONEq(t, `[]`, string(envelope.Data))
+
+	recorder = serve(V1(func(r *http.Request) (Result, *Error) {
+		return Result{Data: func() {}}, nil
+	}), http.MethodGet)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, model.ApiErrorInternal, decodeEnvelope(t, recorder).Error)
+
+	recorder = serve(V1(func(r *http.Request) (Result, *Error) {
+		t.Fatal("handler should not be called")
+		return Result{}, nil
+	}), http.MethodPost)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, model.ApiErrorMethodNotAllowed, decodeEnvelope(t, recorder).Error)
+}
+
+func TestLegacy(t *testing.T) {
+	recorder := serve(Legacy(func(r *http.Request) (Result, *Error) {
+		return Result{Data: map[string]int{"value": 1}}, nil
+	}), http.MethodGet)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"value":1}`, recorder.Body.String())
+
+	recorder = serve(Legacy(func(r *http.Request) (Result, *Error) {
+		return Result{}, Disabled("passive dns is disabled")
+	}), http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "passive dns is disabled\n", recorder.Body.String())
+}
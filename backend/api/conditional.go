@@ -0,0 +1,66 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ETag 只由缓存更新的时间决定 , 同一份数据的 gzip 和未压缩版本内容不同 , 所以用弱校验
+func ETag(updateAt time.Time, variant string) string {
+	tag := strconv.FormatInt(updateAt.UnixNano(), 16)
+	if variant != "" {
+		tag += "-" + variant
+	}
+	return `W/"` + tag + `"`
+}
+
+// CacheMaxAge 缓存还有多久过期 , 已经过期时为 0
+func CacheMaxAge(expireAt time.Time, now time.Time) int {
+	return int(max(math.Ceil(expireAt.Sub(now).Seconds()), 0))
+}
+
+// WriteCacheHeaders 设置 ETag , Last-Modified 和 Cache-Control , 请求的条件满足时返回 true ,
+// 调用方应该直接返回 304 . expireAt 为零时不设置 Cache-Control
+func WriteCacheHeaders(w http.ResponseWriter, r *http.Request, etag string, updateAt time.Time, expireAt time.Time) bool {
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", updateAt.UTC().Format(http.TimeFormat))
+	if !expireAt.IsZero() {
+		if maxAge := CacheMaxAge(expireAt, time.Now()); maxAge > 0 {
+			header.Set("Cache-Control", "max-age="+strconv.Itoa(maxAge))
+		} else {
+			header.Set("Cache-Control", "no-cache")
+		}
+	}
+
+	// 两个条件同时存在时只看 If-None-Match
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatch(ifNoneMatch, etag)
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		// Last-Modified 只精确到秒
+		return err == nil && !updateAt.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+func etagMatch(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, item := range strings.Split(ifNoneMatch, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteNotModified 304 不能带响应体
+func WriteNotModified(w http.ResponseWriter) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
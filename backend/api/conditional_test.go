@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 60, CacheMaxAge(now.Add(60*time.Second), now))
+	assert.Equal(t, 1, CacheMaxAge(now.Add(100*time.Millisecond), now))
+	assert.Equal(t, 0, CacheMaxAge(now.Add(-time.Second), now))
+}
+
+func TestConditionalGet(t *testing.T) {
+	updateAt := time.Now().Add(-10 * time.Second)
+	expireAt := updateAt.Add(time.Minute)
+	handler := func(r *http.Request) (Result, *Error) {
+		return Result{Raw: []byte(`{"a":1}`), GeneratedAt: updateAt, ExpireAt: expireAt}, nil
+	}
+
+	serveWith := func(wrapped http.HandlerFunc, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/metric/static", nil)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		recorder := httptest.NewRecorder()
+		wrapped(recorder, request)
+		return recorder
+	}
+
+	for _, wrapped := range []http.HandlerFunc{V1(handler), Legacy(handler)} {
+		first := serveWith(wrapped, nil)
+		assert.Equal(t, http.StatusOK, first.Code)
+		etag := first.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+		assert.Equal(t, updateAt.UTC().Format(http.TimeFormat), first.Header().Get("Last-Modified"))
+		maxAge := first.Header().Get("Cache-Control")
+		assert.Contains(t, []string{"max-age=50", "max-age=51"}, maxAge)
+
+		recorder := serveWith(wrapped, map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.Empty(t, recorder.Body.Bytes())
+		assert.Equal(t, etag, recorder.Header().Get("ETag"))
+
+		recorder = serveWith(wrapped, map[string]string{"If-None-Match": `W/"other", ` + etag})
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+		recorder = serveWith(wrapped, map[string]string{"If-None-Match": `W/"other"`})
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = serveWith(wrapped, map[string]string{"If-Modified-Since": first.Header().Get("Last-Modified")})
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+		earlier := updateAt.Add(-time.Minute).UTC().Format(http.TimeFormat)
+		recorder = serveWith(wrapped, map[string]string{"If-Modified-Since": earlier})
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		// If-None-Match 不匹配时忽略 If-Modified-Since
+		recorder = serveWith(wrapped, map[string]string{
+			"If-None-Match":     `W/"other"`,
+			"If-Modified-Since": first.Header().Get("Last-Modified"),
+		})
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+}
+
+func TestConditionalGetStaleEnvelope(t *testing.T) {
+	updateAt := time.Now().Add(-2 * time.Minute)
+	fresh := V1(func(r *http.Request) (Result, *Error) {
+		return Result{Raw: []byte(`{}`), GeneratedAt: updateAt, ExpireAt: time.Now().Add(time.Minute)}, nil
+	})
+	stale := V1(func(r *http.Request) (Result, *Error) {
+		return Result{Raw: []byte(`{}`), GeneratedAt: updateAt, ExpireAt: updateAt.Add(time.Minute)}, nil
+	})
+
+	recorder := serve(fresh, http.MethodGet)
+	etag := recorder.Header().Get("ETag")
+
+	// 数据没变但是已经过期 , 信封里的 stale 变了 , 不能返回 304
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/test", nil)
+	request.Header.Set("If-None-Match", etag)
+	recorder = httptest.NewRecorder()
+	stale(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+	assert.NotEqual(t, etag, recorder.Header().Get("ETag"))
+}
+
+func TestNoCacheHeadersWithoutGeneratedAt(t *testing.T) {
+	recorder := serve(V1(func(r *http.Request) (Result, *Error) {
+		return Result{Data: []int{1}}, nil
+	}), http.MethodGet)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("ETag"))
+	assert.Empty(t, recorder.Header().Get("Cache-Control"))
+}
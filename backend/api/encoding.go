@@ -0,0 +1,64 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/utils"
+)
+
+// AcceptsGzip 按 Accept-Encoding 判断客户端是否接受 gzip , q=0 表示明确拒绝 ,
+// 没有这个头的客户端 (curl 不加 --compressed , 简单的脚本) 只能收到未压缩的数据
+func AcceptsGzip(r *http.Request) bool {
+	gzipQuality, wildcardQuality := -1.0, -1.0
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			quality := 1.0
+			for _, param := range strings.Split(params, ";") {
+				if rawQuality, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+					parsed, err := strconv.ParseFloat(rawQuality, 64)
+					if err != nil {
+						parsed = 0
+					}
+					quality = parsed
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "gzip", "x-gzip":
+				gzipQuality = quality
+			case "*":
+				wildcardQuality = quality
+			}
+		}
+	}
+	if gzipQuality >= 0 {
+		return gzipQuality > 0
+	}
+	return wildcardQuality > 0
+}
+
+// WriteJson 按请求协商压缩 , gzipBody 是 body 预先压缩好的版本 ,
+// 为空时超过 GzipThreshold 的数据现场压缩
+func WriteJson(w http.ResponseWriter, r *http.Request, status int, body []byte, gzipBody []byte) {
+	header := w.Header()
+	header.Set("Content-Type", "application/json")
+	header.Add("Vary", "Accept-Encoding")
+	if AcceptsGzip(r) {
+		if len(gzipBody) == 0 && len(body) > model.GzipThreshold {
+			var err error
+			if gzipBody, err = utils.GzipBytes(body); err != nil {
+				log.Printf("Gzip api response failed: %s", err)
+			}
+		}
+		if len(gzipBody) > 0 {
+			header.Set("Content-Encoding", "gzip")
+			body = gzipBody
+		}
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, gzip;q=0.5":   true,
+		"gzip;q=0":              false,
+		"GZIP; q=1.0":           true,
+		"*":                     true,
+		"*;q=0":                 false,
+		"gzip;q=0, *":           false,
+		"identity":              false,
+		"br, x-gzip":            true,
+		"gzip;level=1;q=0.001 ": true,
+	}
+	for header, want := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			request.Header.Set("Accept-Encoding", header)
+		}
+		assert.Equal(t, want, AcceptsGzip(request), header)
+	}
+}
+
+func TestWriteJsonNegotiate(t *testing.T) {
+	body := []byte(`{"data":"` + strings.Repeat("a", model.GzipThreshold) + `"}`)
+	gzipBody, err := utils.GzipBytes(body)
+	assert.NoError(t, err)
+
+	write := func(acceptEncoding string, body []byte, gzipBody []byte) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		recorder := httptest.NewRecorder()
+		WriteJson(recorder, request, http.StatusOK, body, gzipBody)
+		assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		return recorder
+	}
+
+	// 不接受 gzip 时即使有压缩版本也返回原文
+	recorder := write("", body, gzipBody)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, recorder.Body.Bytes())
+
+	recorder = write("gzip", body, gzipBody)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, gzipBody, recorder.Body.Bytes())
+
+	// 没有预先压缩的版本时现场压缩
+	recorder = write("gzip", body, nil)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	decoded, err := utils.GunzipBytes(recorder.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, body, decoded)
+
+	// 小数据不值得压缩
+	recorder = write("gzip", []byte(`{}`), nil)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{}`, recorder.Body.String())
+}
+
+func TestStaticHandlerPrecompressed(t *testing.T) {
+	index := []byte("<html>" + strings.Repeat("x", 2048) + "</html>")
+	indexGzip, err := utils.GzipBytes(index)
+	assert.NoError(t, err)
+	handler := StaticHandler(fstest.MapFS{
+		"index.html.gz":    {Data: indexGzip}, // 构建时不保留原文件
+		"assets/app.js":    {Data: []byte("console.log(1)")},
+		"assets/small.css": {Data: []byte("body{}")},
+	})
+
+	serve := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			request.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve("/", "gzip")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.True(t, bytes.Equal(indexGzip, recorder.Body.Bytes()))
+
+	// 不接受 gzip 的客户端解压后返回
+	recorder = serve("/", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, index, recorder.Body.Bytes())
+
+	recorder = serve("/index.html", "br")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, index, recorder.Body.Bytes())
+
+	// 没有 .gz 的文件按原样返回
+	recorder = serve("/assets/app.js", "gzip")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "console.log(1)", recorder.Body.String())
+	assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+}
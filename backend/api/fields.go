@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// SelectFields 从 json 里挑出 paths 指定的字段 , 保留原来的层级 , paths 为空时原样返回 .
+// 路径用 . 分隔 , 每一层优先匹配最长的键 , 这样 eth0.2 这种带点的网卡名也能选 ;
+// 遇到数组时对每个元素继续选择 . 找不到的路径放在 missing 里
+func SelectFields(raw []byte, paths []string) (selected json.RawMessage, missing []string, err error) {
+	if len(paths) == 0 {
+		return raw, nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, nil, err
+	}
+
+	var result any
+	for _, path := range paths {
+		picked, ok := selectPath(value, strings.Split(path, "."))
+		if !ok {
+			missing = append(missing, path)
+			continue
+		}
+		result = mergeSelected(result, picked)
+	}
+	if result == nil {
+		return nil, missing, nil
+	}
+	selected, err = json.Marshal(result)
+	return selected, missing, err
+}
+
+func selectPath(node any, segments []string) (any, bool) {
+	if len(segments) == 0 {
+		return node, true
+	}
+	switch node := node.(type) {
+	case map[string]any:
+		for count := len(segments); count > 0; count-- {
+			key := strings.Join(segments[:count], ".")
+			child, ok := node[key]
+			if !ok {
+				continue
+			}
+			if picked, ok := selectPath(child, segments[count:]); ok {
+				return map[string]any{key: picked}, true
+			}
+		}
+	case []any:
+		result := make([]any, len(node))
+		found := len(node) == 0
+		for index, item := range node {
+			// 带 omitempty 的字段在部分元素里没有 , 这些元素留空对象保持下标对齐
+			result[index] = map[string]any{}
+			if picked, ok := selectPath(item, segments); ok {
+				result[index] = picked
+				found = true
+			}
+		}
+		return result, found
+	}
+	return nil, false
+}
+
+func mergeSelected(current any, picked any) any {
+	switch picked := picked.(type) {
+	case map[string]any:
+		currentMap, ok := current.(map[string]any)
+		if !ok {
+			return picked
+		}
+		for key, value := range picked {
+			currentMap[key] = mergeSelected(currentMap[key], value)
+		}
+		return currentMap
+	case []any:
+		currentSlice, ok := current.([]any)
+		if !ok || len(currentSlice) != len(picked) {
+			return picked
+		}
+		for index := range picked {
+			currentSlice[index] = mergeSelected(currentSlice[index], picked[index])
+		}
+		return currentSlice
+	}
+	return picked
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const dynamicJson = `{
+	"cpu": {"total": {"usage": {"value": 12.5, "unit": "%"}}, "cpu0": {"usage": {"value": 3, "unit": "%"}}},
+	"network": {"pppoe-wan": {"incoming": {"value": 1, "unit": "KB/S"}}, "eth0.2": {"incoming": {"value": 2, "unit": "B/S"}}, "eth0": {"incoming": {"value": 3, "unit": "B/S"}}},
+	"memory": {"used_percent": {"value": 40, "unit": "%"}}
+}`
+
+func TestSelectFields(t *testing.T) {
+	selected, missing, err := SelectFields([]byte(dynamicJson), []string{"cpu.total", "network.pppoe-wan"})
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+	assert.JSONEq(t, `{
+		"cpu": {"total": {"usage": {"value": 12.5, "unit": "%"}}},
+		"network": {"pppoe-wan": {"incoming": {"value": 1, "unit": "KB/S"}}}
+	}`, string(selected))
+
+	// 带点的网卡名按最长的键匹配 , 同时选择上层和下层时保留上层
+	selected, missing, err = SelectFields([]byte(dynamicJson), []string{"network.eth0.2.incoming.value", "memory.used_percent.value", "memory", "cpu.cpu9"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu.cpu9"}, missing)
+	assert.JSONEq(t, `{
+		"network": {"eth0.2": {"incoming": {"value": 2}}},
+		"memory": {"used_percent": {"value": 40, "unit": "%"}}
+	}`, string(selected))
+
+	selected, missing, err = SelectFields([]byte(dynamicJson), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+	assert.Equal(t, dynamicJson, string(selected))
+
+	selected, missing, err = SelectFields([]byte(dynamicJson), []string{"disk"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"disk"}, missing)
+	assert.Nil(t, selected)
+}
+
+func TestSelectFieldsArray(t *testing.T) {
+	raw := `{"details": [{"ip": "192.168.1.2", "domain": "a.com", "tcp": 1}, {"ip": "1.1.1.1", "tcp": 2}]}`
+	selected, missing, err := SelectFields([]byte(raw), []string{"details.ip", "details.domain"})
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+	assert.JSONEq(t, `{"details": [{"ip": "192.168.1.2", "domain": "a.com"}, {"ip": "1.1.1.1"}]}`, string(selected))
+
+	// 大整数不能因为转成 float64 丢精度
+	selected, _, err = SelectFields([]byte(`{"a": {"b": 18446744073709551615}}`), []string{"a.b"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":{"b":18446744073709551615}}`, string(selected))
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// StaticHandler 提供前端静态文件 . 构建时文本类的文件只保留压缩后的 xxx.gz , 不保留原文件 , 节省闪存空间 ,
+// 客户端接受 gzip 时直接返回压缩好的文件 , 不接受时解压后返回 ; 没有 xxx.gz 的文件退回 http.FileServer
+func StaticHandler(fsys fs.FS) http.Handler {
+	fileServer := http.FileServer(http.FS(fsys))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+		if strings.HasSuffix(name, ".gz") {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		file, err := fsys.Open(name + ".gz")
+		if err != nil {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		acceptsGzip := AcceptsGzip(r)
+		content, err := gzipFileContent(file, !acceptsGzip)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		if acceptsGzip {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		// embed 的文件修改时间是零值 , ServeContent 不会设置 Last-Modified
+		http.ServeContent(w, r, name, stat.ModTime(), content)
+	})
+}
+
+// gzipFileContent decompress 为 true 时把整个文件解压到内存里 , 只有不支持 gzip 的客户端才会用到 , 前端的文件都不大
+func gzipFileContent(file fs.File, decompress bool) (io.ReadSeeker, error) {
+	if !decompress {
+		content, ok := file.(io.ReadSeeker)
+		if !ok {
+			return nil, errors.New("static file is not seekable")
+		}
+		return content, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(raw), nil
+}
@@ -2,6 +2,7 @@ package dns
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
 	"openwrt-diskio-api/backend/model"
@@ -14,27 +15,17 @@ const DnsCacheExpireTime = 5 * time.Minute
 
 type DnsQueryService struct {
 	dnsCache        sync.Map
-	resolver        *net.Resolver
+	upstreams       []*DnsUpstream
 	queryTimeout    time.Duration
 	neighborService *NeighborService
 }
 
-func NewDnsQueryService(dnsIp string, queryTimeout time.Duration) *DnsQueryService {
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: queryTimeout,
-			}
-			return d.DialContext(ctx, "udp", net.JoinHostPort(dnsIp, "53"))
-		},
-	}
-
+func NewDnsQueryService(upstreams []*DnsUpstream, queryTimeout time.Duration, neighborService *NeighborService) *DnsQueryService {
 	return &DnsQueryService{
 		dnsCache:        sync.Map{},
-		resolver:        resolver,
+		upstreams:       upstreams,
 		queryTimeout:    queryTimeout,
-		neighborService: NewNeighborService(),
+		neighborService: neighborService,
 	}
 }
 
@@ -54,9 +45,7 @@ func (dqs *DnsQueryService) LookupAddr(ips []string) (model.DnsResult, error) {
 				continue
 			}
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), dqs.queryTimeout)
-		names, err := dqs.resolver.LookupAddr(ctx, ip)
-		cancel()
+		names, err := dqs.lookupAddrWithFailover(ip)
 		if err != nil || len(names) == 0 {
 			// 查询失败如果打印出来会导致有几吨的日志
 			// log.Printf("Dns query for %q failed: %s\n", ip, err)
@@ -91,8 +80,6 @@ func (dqs *DnsQueryService) LookupIpv6ByNeighborService(ip string) []string {
 	if !strings.Contains(ip, ":") {
 		return result
 	}
-	dqs.neighborService.ActiveSignal()
-
 	// IPv6 -> MAC
 	mac := dqs.neighborService.GetMac(ip)
 	if mac == "" {
@@ -110,3 +97,54 @@ func (dqs *DnsQueryService) LookupIpv6ByNeighborService(ip string) []string {
 	}
 	return result
 }
+
+// 按配置顺序逐个尝试上游 , 跳过暂时不健康的上游 ,
+// 如果所有上游都不健康 , 那就全部都试一遍
+func (dqs *DnsQueryService) lookupAddrWithFailover(ip string) ([]string, error) {
+	now := time.Now()
+	candidates := make([]*DnsUpstream, 0, len(dqs.upstreams))
+	for _, upstream := range dqs.upstreams {
+		if !upstream.isSuspended(now) {
+			candidates = append(candidates, upstream)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = dqs.upstreams
+	}
+
+	var lastErr error
+	for _, upstream := range candidates {
+		ctx, cancel := context.WithTimeout(context.Background(), dqs.queryTimeout)
+		names, err := upstream.LookupAddr(ctx, ip)
+		cancel()
+		var dnsErr *net.DNSError
+		if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return names, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("No dns upstream configured")
+	}
+	return nil, lastErr
+}
+
+func (dqs *DnsQueryService) UpstreamStatus() []model.DnsUpstreamStatus {
+	result := make([]model.DnsUpstreamStatus, 0, len(dqs.upstreams))
+	for _, upstream := range dqs.upstreams {
+		result = append(result, upstream.Status())
+	}
+	return result
+}
+
+func (dqs *DnsQueryService) Status() model.DnsResolverStatus {
+	upstreams := dqs.UpstreamStatus()
+	healthy := false
+	for _, upstream := range upstreams {
+		healthy = healthy || upstream.Healthy
+	}
+	return model.DnsResolverStatus{
+		Healthy:   healthy,
+		Upstreams: upstreams,
+	}
+}
@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+const (
+	dnsHeaderLength      = 12
+	dnsMaxPointerJumps   = 16
+	dnsRecordTypeA       = 1
+	dnsRecordTypeAAAA    = 28
+	dnsRecordFixedLength = 10 // type(2) + class(2) + ttl(4) + rdlength(2)
+)
+
+var (
+	ErrDnsMessageTooShort = errors.New("dns message too short")
+	ErrDnsNameInvalid     = errors.New("dns name invalid")
+)
+
+type DnsAnswer struct {
+	Name string // 应答记录自身的名字 , CNAME 链上可能和查询的域名不同
+	Addr netip.Addr
+	Ttl  uint32
+}
+
+// ParseDnsResponse 解析 dns 应答报文 , 返回第一个 question 的域名和所有 A/AAAA 记录 ,
+// 报文被截断时返回已经解析出来的记录
+func ParseDnsResponse(payload []byte) (question string, answers []DnsAnswer, err error) {
+	if len(payload) < dnsHeaderLength {
+		return "", nil, ErrDnsMessageTooShort
+	}
+	questionCount := binary.BigEndian.Uint16(payload[4:6])
+	answerCount := binary.BigEndian.Uint16(payload[6:8])
+
+	offset := dnsHeaderLength
+	for index := range questionCount {
+		name, next, err := readDnsName(payload, offset)
+		if err != nil {
+			return "", nil, err
+		}
+		if index == 0 {
+			question = name
+		}
+		// qtype(2) + qclass(2)
+		offset = next + 4
+	}
+
+	for range answerCount {
+		name, next, err := readDnsName(payload, offset)
+		if err != nil || next+dnsRecordFixedLength > len(payload) {
+			break
+		}
+		recordType := binary.BigEndian.Uint16(payload[next : next+2])
+		ttl := binary.BigEndian.Uint32(payload[next+4 : next+8])
+		dataLength := int(binary.BigEndian.Uint16(payload[next+8 : next+10]))
+		dataStart := next + dnsRecordFixedLength
+		if dataStart+dataLength > len(payload) {
+			break
+		}
+		data := payload[dataStart : dataStart+dataLength]
+
+		switch {
+		case recordType == dnsRecordTypeA && dataLength == 4:
+			answers = append(answers, DnsAnswer{Name: name, Addr: netip.AddrFrom4([4]byte(data)), Ttl: ttl})
+		case recordType == dnsRecordTypeAAAA && dataLength == 16:
+			answers = append(answers, DnsAnswer{Name: name, Addr: netip.AddrFrom16([16]byte(data)), Ttl: ttl})
+		}
+		offset = dataStart + dataLength
+	}
+	return question, answers, nil
+}
+
+// 返回域名和域名之后的偏移量 , 支持压缩指针
+func readDnsName(payload []byte, offset int) (string, int, error) {
+	labels := make([]string, 0, 4)
+	next := -1
+	jumps := 0
+	for {
+		if offset >= len(payload) {
+			return "", 0, ErrDnsMessageTooShort
+		}
+		length := int(payload[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(payload) {
+				return "", 0, ErrDnsMessageTooShort
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			jumps += 1
+			if jumps > dnsMaxPointerJumps {
+				return "", 0, ErrDnsNameInvalid
+			}
+			offset = int(binary.BigEndian.Uint16(payload[offset:offset+2]) & 0x3FFF)
+		case length&0xC0 != 0:
+			return "", 0, ErrDnsNameInvalid
+		default:
+			offset += 1
+			if offset+length > len(payload) {
+				return "", 0, ErrDnsMessageTooShort
+			}
+			labels = append(labels, string(payload[offset:offset+length]))
+			offset += length
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// www.youtube.com -> CNAME youtube-ui.l.google.com -> A 142.250.1.1 , AAAA 2404:6800::1
+var testDnsResponse = []byte{
+	0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00,
+	// question : www.youtube.com A IN
+	0x03, 'w', 'w', 'w', 0x07, 'y', 'o', 'u', 't', 'u', 'b', 'e', 0x03, 'c', 'o', 'm', 0x00,
+	0x00, 0x01, 0x00, 0x01,
+	// answer 1 : pointer(12) CNAME ttl=300 -> youtube-ui.l.google + pointer(24 "com")
+	0xC0, 0x0C, 0x00, 0x05, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2C, 0x00, 0x16,
+	0x0A, 'y', 'o', 'u', 't', 'u', 'b', 'e', '-', 'u', 'i', 0x01, 'l', 0x06, 'g', 'o', 'o', 'g', 'l', 'e', 0xC0, 0x18,
+	// answer 2 : pointer(45) A ttl=60 142.250.1.1
+	0xC0, 0x2D, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3C, 0x00, 0x04,
+	142, 250, 1, 1,
+	// answer 3 : pointer(45) AAAA ttl=60 2404:6800::1
+	0xC0, 0x2D, 0x00, 0x1C, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3C, 0x00, 0x10,
+	0x24, 0x04, 0x68, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+}
+
+func TestParseDnsResponse(t *testing.T) {
+	question, answers, err := ParseDnsResponse(testDnsResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, "www.youtube.com", question)
+	assert.Equal(t, []DnsAnswer{
+		{Name: "youtube-ui.l.google.com", Addr: netip.MustParseAddr("142.250.1.1"), Ttl: 60},
+		{Name: "youtube-ui.l.google.com", Addr: netip.MustParseAddr("2404:6800::1"), Ttl: 60},
+	}, answers)
+}
+
+func TestParseDnsResponseTruncated(t *testing.T) {
+	// 截断在 AAAA 记录中间 , 仍然返回已解析的 A 记录
+	question, answers, err := ParseDnsResponse(testDnsResponse[:len(testDnsResponse)-8])
+	assert.NoError(t, err)
+	assert.Equal(t, "www.youtube.com", question)
+	assert.Len(t, answers, 1)
+
+	_, _, err = ParseDnsResponse(testDnsResponse[:8])
+	assert.ErrorIs(t, err, ErrDnsMessageTooShort)
+}
+
+func TestParseDnsResponsePointerLoop(t *testing.T) {
+	payload := []byte{
+		0x00, 0x00, 0x81, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xC0, 0x0C,
+	}
+	_, _, err := ParseDnsResponse(payload)
+	assert.ErrorIs(t, err, ErrDnsNameInvalid)
+}
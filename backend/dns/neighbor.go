@@ -1,76 +1,141 @@
 package dns
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/netip"
 	"openwrt-diskio-api/backend/model"
-	"sync/atomic"
+	"openwrt-diskio-api/backend/oui"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
-const NeighReloadInterval = 30 * time.Second
+const (
+	// 订阅在 socket 缓冲区满 (ENOBUFS) 时会丢事件 , 所以定期全量对账一次
+	NeighResyncInterval     = 5 * time.Minute
+	NeighResubscribeDelay   = 5 * time.Second
+	NeighReceiveBufferSize  = 1024 * 1024
+	NeighUpdateChanSize     = 256
+	neighClockTicksDuration = 10 * time.Millisecond // ndm_* 时间的单位是 USER_HZ , openwrt 上固定是 100
+)
+
+type neighborEntry struct {
+	ip        netip.Addr
+	mac       string
+	state     int
+	flags     int
+	linkIndex int
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// 不完整/失败的条目只保留在邻居表里展示 , 不参与 ip <-> mac 查询
+func (e *neighborEntry) isUsable() bool {
+	return e.mac != "" && e.state&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) == 0 && e.state != netlink.NUD_NONE
+}
 
 type NeighborService struct {
-	ipToMac    atomic.Value
-	macToV4    atomic.Value
-	activeChan chan struct{}
-	lastActive int64
+	mutex     sync.RWMutex
+	entries   map[netip.Addr]*neighborEntry
+	linkNames map[int]string
+	// 方便测试替换
+	linkNameByIndex func(index int) string
+	// 以下是订阅的健康状态 , 由 mutex 保护
+	subscribed    bool
+	lastEventAt   time.Time
+	lastResyncAt  time.Time
+	lastError     string
+	lastFailureAt time.Time
 }
 
 func NewNeighborService() *NeighborService {
 	ns := &NeighborService{
-		activeChan: make(chan struct{}, 1),
-		lastActive: time.Now().UnixNano(),
+		entries:         make(map[netip.Addr]*neighborEntry),
+		linkNames:       make(map[int]string),
+		linkNameByIndex: netlinkLinkName,
 	}
-	ns.ipToMac.Store(make(map[string]string))
-	ns.macToV4.Store(make(map[string]string))
 	_ = ns.Reload()
 	return ns
 }
 
-func (ns *NeighborService) ActiveSignal() {
-	atomic.StoreInt64(&ns.lastActive, time.Now().UnixNano())
-	select {
-	case ns.activeChan <- struct{}{}:
-	default:
+func netlinkLinkName(index int) string {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return ""
 	}
+	return link.Attrs().Name
 }
 
+// Run 订阅内核的 RTM_NEWNEIGH/RTM_DELNEIGH 事件并增量更新邻居表 ,
+// 订阅断开后会自动重新订阅 , 直到 ctx 结束
 func (ns *NeighborService) Run(ctx context.Context) {
-	ticker := time.NewTicker(NeighReloadInterval)
-	defer ticker.Stop()
-
-	isRunning := true
-	// TODO 这里可以做netlink.NeighSubscribe,但是处理Neigh事件挺麻烦的
-	// 先不做
-
+	log.Println("Enable neighbor table subscription")
 	for {
+		err := ns.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Neighbor table subscription stopped: %s , resubscribe after %v", err, NeighResubscribeDelay)
+		ns.setSubscribed(false, err)
 		select {
 		case <-ctx.Done():
 			return
-		case <-ns.activeChan:
-			if !isRunning {
-				isRunning = true
-				log.Println("Enable ipv6 neighbor query service")
-				if err := ns.Reload(); err != nil {
-					log.Println(err)
-				}
-			}
+		case <-time.After(NeighResubscribeDelay):
+		}
+	}
+}
 
-		case <-ticker.C:
-			if !isRunning {
-				continue
-			}
-			lastActiveUnix := atomic.LoadInt64(&ns.lastActive)
-			if time.Since(time.Unix(0, lastActiveUnix)) > model.MinServiceRunDuration {
-				isRunning = false
-				log.Println("Disable ipv6 neighbor query service")
-				continue
+func (ns *NeighborService) watch(ctx context.Context) error {
+	updates := make(chan netlink.NeighUpdate, NeighUpdateChanSize)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		// netlink 的接收协程可能还阻塞在发送上 , 排空后它才能退出
+		go func() {
+			for range updates {
 			}
+		}()
+	}()
+
+	err := netlink.NeighSubscribeWithOptions(updates, done, netlink.NeighSubscribeOptions{
+		ErrorCallback: func(err error) {
+			log.Printf("Netlink neighbor subscription error: %s", err)
+			ns.recordFailure(err)
+		},
+		ReceiveBufferSize: NeighReceiveBufferSize,
+	})
+	if err != nil {
+		return fmt.Errorf("Subscribe netlink neighbor changes failed: %w", err)
+	}
+	ns.setSubscribed(true, nil)
+	// 先订阅再全量拉取 , 这样两者之间发生的变化不会丢
+	if err := ns.Reload(); err != nil {
+		log.Println(err)
+	}
 
+	ticker := time.NewTicker(NeighResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case update, ok := <-updates:
+			if !ok {
+				return errors.New("netlink neighbor update channel closed")
+			}
+			now := time.Now()
+			ns.Apply(update.Neigh, update.Type == unix.RTM_DELNEIGH, now)
+			ns.mutex.Lock()
+			ns.lastEventAt = now
+			ns.mutex.Unlock()
+		case <-ticker.C:
 			if err := ns.Reload(); err != nil {
 				log.Println(err)
 			}
@@ -78,61 +143,235 @@ func (ns *NeighborService) Run(ctx context.Context) {
 	}
 }
 
+// Reload 全量拉取邻居表 , 内核里已经不存在的条目会被删除
 func (ns *NeighborService) Reload() error {
-	// 构造临时 map
-	nextIpToMac := make(map[string]string)
-	nextMacToV4 := make(map[string]string)
-
 	v4, err := netlink.NeighList(0, netlink.FAMILY_V4)
 	if err != nil {
-		return fmt.Errorf("Get ipv4 neigh list failed: %w", err)
+		err = fmt.Errorf("Get ipv4 neigh list failed: %w", err)
+		ns.recordFailure(err)
+		return err
 	}
 	v6, err := netlink.NeighList(0, netlink.FAMILY_V6)
 	if err != nil {
-		return fmt.Errorf("Get ipv6 neigh list failed: %w", err)
+		err = fmt.Errorf("Get ipv6 neigh list failed: %w", err)
+		ns.recordFailure(err)
+		return err
 	}
 
+	ns.mutex.Lock()
+	// 网卡可能被重命名或重建 , 对账时顺便清空缓存
+	clear(ns.linkNames)
+	ns.mutex.Unlock()
+
+	now := time.Now()
+	seen := make(map[netip.Addr]struct{}, len(v4)+len(v6))
 	for _, n := range append(v4, v6...) {
-		if n.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED|netlink.NUD_NONE) == 0 {
-			ip := n.IP.String()
-			mac := n.HardwareAddr.String()
-			if mac == "" {
-				continue
-			}
+		if ip, ok := ns.Apply(n, false, now); ok {
+			seen[ip] = struct{}{}
+		}
+	}
 
-			nextIpToMac[ip] = mac
-			if n.IP.To4() != nil {
-				nextMacToV4[mac] = ip
-			}
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	for ip := range ns.entries {
+		if _, ok := seen[ip]; !ok {
+			delete(ns.entries, ip)
 		}
 	}
-	ns.ipToMac.Store(nextIpToMac)
-	ns.macToV4.Store(nextMacToV4)
+	ns.lastResyncAt = now
 	return nil
 }
 
-func (ns *NeighborService) GetMac(ip string) string {
-	m, ok := ns.ipToMac.Load().(map[string]string)
+func (ns *NeighborService) setSubscribed(subscribed bool, err error) {
+	ns.mutex.Lock()
+	ns.subscribed = subscribed
+	ns.mutex.Unlock()
+	if err != nil {
+		ns.recordFailure(err)
+	}
+}
+
+func (ns *NeighborService) recordFailure(err error) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	ns.lastError = err.Error()
+	ns.lastFailureAt = time.Now()
+}
+
+// Status 订阅中且最近按时对账成功才算健康 ,
+// 邻居表本身没有变化时收不到事件是正常的 , 所以不看 lastEventAt
+func (ns *NeighborService) Status() model.NeighborServiceStatus {
+	ns.mutex.RLock()
+	defer ns.mutex.RUnlock()
+	resyncFresh := time.Since(ns.lastResyncAt) < 2*NeighResyncInterval
+	return model.NeighborServiceStatus{
+		Healthy:       ns.subscribed && resyncFresh && !ns.lastFailureAt.After(ns.lastResyncAt),
+		Subscribed:    ns.subscribed,
+		Entries:       len(ns.entries),
+		LastEventAt:   ns.lastEventAt,
+		LastResyncAt:  ns.lastResyncAt,
+		LastError:     ns.lastError,
+		LastFailureAt: ns.lastFailureAt,
+	}
+}
+
+// Apply 把一条 netlink 邻居消息合并进邻居表 , 返回条目的 ip 和它是否被保留
+func (ns *NeighborService) Apply(n netlink.Neigh, deleted bool, now time.Time) (netip.Addr, bool) {
+	if n.Family != netlink.FAMILY_V4 && n.Family != netlink.FAMILY_V6 {
+		// AF_BRIDGE 的 fdb 条目不是邻居
+		return netip.Addr{}, false
+	}
+	ip, ok := netip.AddrFromSlice(n.IP)
 	if !ok {
-		log.Println("Arp search ipToMac map load failed: not a map[string]string type")
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	if deleted {
+		delete(ns.entries, ip)
+		return ip, false
+	}
+
+	mac := n.HardwareAddr.String()
+	entry, exist := ns.entries[ip]
+	if !exist {
+		if mac == "" {
+			// 还在解析中的条目没有 mac , 不值得记录
+			return ip, false
+		}
+		entry = &neighborEntry{ip: ip}
+		ns.entries[ip] = entry
+	}
+
+	// 条目进入 FAILED 等状态时内核不一定带上 mac , 保留之前的
+	if mac != "" && mac != entry.mac {
+		entry.mac = mac
+		entry.firstSeen = time.Time{}
+		entry.lastSeen = time.Time{}
+	}
+	entry.state = n.State
+	entry.flags = n.Flags
+	entry.linkIndex = n.LinkIndex
+
+	// Confirmed 是距离上次确认可达过去了多少个 tick
+	lastSeen := now.Add(-time.Duration(n.Confirmed) * neighClockTicksDuration)
+	if entry.isUsable() && lastSeen.After(entry.lastSeen) {
+		entry.lastSeen = lastSeen
+	}
+	if entry.firstSeen.IsZero() {
+		entry.firstSeen = now
+		if !entry.lastSeen.IsZero() && entry.lastSeen.Before(now) {
+			entry.firstSeen = entry.lastSeen
+		}
+	}
+	return ip, true
+}
+
+func (ns *NeighborService) GetMac(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
 		return ""
 	}
-	result, ok := m[ip]
-	if !ok {
+	ns.mutex.RLock()
+	defer ns.mutex.RUnlock()
+	entry, ok := ns.entries[addr.Unmap()]
+	if !ok || !entry.isUsable() {
 		return ""
 	}
-	return result
+	return entry.mac
 }
 
+// 同一个 mac 可能有多个 ipv4 , 返回最近见到的那个
 func (ns *NeighborService) GetV4ByMac(mac string) string {
-	m, ok := ns.macToV4.Load().(map[string]string)
-	if !ok {
-		log.Println("Arp search macToV4 map load failed: not a map[string]string type")
-		return ""
+	ns.mutex.RLock()
+	defer ns.mutex.RUnlock()
+	var result *neighborEntry
+	for _, entry := range ns.entries {
+		if !entry.ip.Is4() || entry.mac != mac || !entry.isUsable() {
+			continue
+		}
+		if result == nil || entry.lastSeen.After(result.lastSeen) {
+			result = entry
+		}
 	}
-	result, ok := m[mac]
-	if !ok {
+	if result == nil {
 		return ""
 	}
+	return result.ip.String()
+}
+
+func (ns *NeighborService) Neighbors() []model.NeighborEntry {
+	// 只在锁内复制 , 查网卡名和厂商放到锁外 , 不阻塞邻居表的更新
+	ns.mutex.RLock()
+	entries := make([]neighborEntry, 0, len(ns.entries))
+	for _, entry := range ns.entries {
+		entries = append(entries, *entry)
+	}
+	ns.mutex.RUnlock()
+
+	result := make([]model.NeighborEntry, 0, len(entries))
+	for _, entry := range entries {
+		vendor, randomized := oui.Lookup(entry.mac)
+		result = append(result, model.NeighborEntry{
+			Ip:            entry.ip.String(),
+			Mac:           entry.mac,
+			State:         neighStateString(entry.state),
+			Interface:     ns.linkName(entry.linkIndex),
+			IsRouter:      entry.flags&netlink.NTF_ROUTER != 0,
+			Vendor:        vendor,
+			RandomizedMac: randomized,
+			FirstSeen:     entry.firstSeen,
+			LastSeen:      entry.lastSeen,
+		})
+	}
+	slices.SortFunc(result, func(a, b model.NeighborEntry) int {
+		if c := cmp.Compare(a.Interface, b.Interface); c != 0 {
+			return c
+		}
+		return netip.MustParseAddr(a.Ip).Compare(netip.MustParseAddr(b.Ip))
+	})
 	return result
 }
+
+// 缓存里没有时通过 netlink 查询 , 查询时不持有 ns.mutex
+func (ns *NeighborService) linkName(index int) string {
+	ns.mutex.RLock()
+	name, ok := ns.linkNames[index]
+	ns.mutex.RUnlock()
+	if ok {
+		return name
+	}
+	name = ns.linkNameByIndex(index)
+	if name != "" {
+		ns.mutex.Lock()
+		ns.linkNames[index] = name
+		ns.mutex.Unlock()
+	}
+	return name
+}
+
+func neighStateString(state int) model.NeighborState {
+	switch {
+	case state&netlink.NUD_PERMANENT != 0:
+		return model.NeighborStatePermanent
+	case state&netlink.NUD_NOARP != 0:
+		return model.NeighborStateNoArp
+	case state&netlink.NUD_REACHABLE != 0:
+		return model.NeighborStateReachable
+	case state&netlink.NUD_STALE != 0:
+		return model.NeighborStateStale
+	case state&netlink.NUD_DELAY != 0:
+		return model.NeighborStateDelay
+	case state&netlink.NUD_PROBE != 0:
+		return model.NeighborStateProbe
+	case state&netlink.NUD_FAILED != 0:
+		return model.NeighborStateFailed
+	case state&netlink.NUD_INCOMPLETE != 0:
+		return model.NeighborStateIncomplete
+	default:
+		return model.NeighborStateNone
+	}
+}
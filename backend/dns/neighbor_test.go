@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func newTestNeighborService() *NeighborService {
+	return &NeighborService{
+		entries:   make(map[netip.Addr]*neighborEntry),
+		linkNames: make(map[int]string),
+		linkNameByIndex: func(index int) string {
+			return map[int]string{3: "br-lan", 4: "wan"}[index]
+		},
+	}
+}
+
+func newTestNeigh(ip string, mac string, state int) netlink.Neigh {
+	family := netlink.FAMILY_V4
+	if net.ParseIP(ip).To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	hardwareAddr, _ := net.ParseMAC(mac)
+	return netlink.Neigh{
+		LinkIndex:    3,
+		Family:       family,
+		State:        state,
+		IP:           net.ParseIP(ip),
+		HardwareAddr: hardwareAddr,
+	}
+}
+
+func TestNeighborServiceApply(t *testing.T) {
+	ns := newTestNeighborService()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	mac := "b8:27:eb:dd:ee:01"
+
+	ns.Apply(newTestNeigh("192.168.1.10", mac, netlink.NUD_REACHABLE), false, now)
+	ns.Apply(newTestNeigh("fd00::10", mac, netlink.NUD_STALE), false, now)
+	// 解析中的条目不会被记录
+	ns.Apply(newTestNeigh("192.168.1.99", "", netlink.NUD_INCOMPLETE), false, now)
+	// bridge fdb 条目不是邻居
+	fdb := newTestNeigh("192.168.1.98", mac, netlink.NUD_REACHABLE)
+	fdb.Family = 7
+	ns.Apply(fdb, false, now)
+
+	assert.Equal(t, mac, ns.GetMac("fd00::10"))
+	assert.Equal(t, "192.168.1.10", ns.GetV4ByMac(mac))
+	assert.Equal(t, "", ns.GetMac("192.168.1.99"))
+	assert.Len(t, ns.Neighbors(), 2)
+
+	// 进入 FAILED 时内核不带 mac , 保留条目但不参与查询
+	later := now.Add(time.Minute)
+	ns.Apply(newTestNeigh("192.168.1.10", "", netlink.NUD_FAILED), false, later)
+	assert.Equal(t, "", ns.GetMac("192.168.1.10"))
+	assert.Equal(t, "", ns.GetV4ByMac(mac))
+
+	neighbors := ns.Neighbors()
+	assert.Equal(t, model.NeighborEntry{
+		Ip:        "192.168.1.10",
+		Mac:       mac,
+		State:     model.NeighborStateFailed,
+		Interface: "br-lan",
+		Vendor:    "Raspberry Pi Foundation",
+		FirstSeen: now,
+		LastSeen:  now,
+	}, neighbors[0])
+	assert.Equal(t, model.NeighborStateStale, neighbors[1].State)
+
+	ns.Apply(newTestNeigh("fd00::10", "", 0), true, later)
+	assert.Equal(t, "", ns.GetMac("fd00::10"))
+	assert.Len(t, ns.Neighbors(), 1)
+}
+
+func TestNeighborServiceLastSeen(t *testing.T) {
+	ns := newTestNeighborService()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Confirmed 是 tick 数 , 3000 tick = 30 秒前
+	neigh := newTestNeigh("192.168.1.20", "aa:bb:cc:dd:ee:02", netlink.NUD_STALE)
+	neigh.Confirmed = 3000
+	ns.Apply(neigh, false, now)
+	entry := ns.entries[netip.MustParseAddr("192.168.1.20")]
+	assert.Equal(t, now.Add(-30*time.Second), entry.lastSeen)
+	assert.Equal(t, now.Add(-30*time.Second), entry.firstSeen)
+
+	// 状态变化但没有新的确认 , lastSeen 不会倒退
+	neigh.Confirmed = 9000
+	ns.Apply(neigh, false, now.Add(30*time.Second))
+	assert.Equal(t, now.Add(-30*time.Second), entry.lastSeen)
+
+	// 同一个 ip 换了 mac , 当作新设备重新计时
+	neigh = newTestNeigh("192.168.1.20", "aa:bb:cc:dd:ee:03", netlink.NUD_REACHABLE)
+	ns.Apply(neigh, false, now.Add(time.Minute))
+	assert.Equal(t, now.Add(time.Minute), entry.firstSeen)
+	assert.Equal(t, now.Add(time.Minute), entry.lastSeen)
+}
+
+func TestNeighborServiceStatus(t *testing.T) {
+	ns := newTestNeighborService()
+	assert.False(t, ns.Status().Healthy)
+
+	ns.setSubscribed(true, nil)
+	ns.mutex.Lock()
+	ns.lastResyncAt = time.Now()
+	ns.mutex.Unlock()
+	ns.Apply(newTestNeigh("192.168.1.10", "b8:27:eb:00:00:01", netlink.NUD_REACHABLE), false, time.Now())
+	status := ns.Status()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 1, status.Entries)
+
+	// 丢事件后要等下一次对账成功才恢复
+	ns.recordFailure(errors.New("no buffer space available"))
+	status = ns.Status()
+	assert.False(t, status.Healthy)
+	assert.Equal(t, "no buffer space available", status.LastError)
+
+	ns.mutex.Lock()
+	ns.lastResyncAt = time.Now().Add(time.Second)
+	ns.mutex.Unlock()
+	assert.True(t, ns.Status().Healthy)
+
+	ns.setSubscribed(false, errors.New("netlink neighbor update channel closed"))
+	assert.False(t, ns.Status().Subscribed)
+	assert.False(t, ns.Status().Healthy)
+}
@@ -0,0 +1,455 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/netip"
+	"openwrt-diskio-api/backend/model"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PassiveDnsMaxDomainsPerIp   = 8
+	PassiveDnsMaxClientsPerItem = 16
+	PassiveDnsPendingExpireTime = 10 * time.Second
+	PassiveDnsGcInterval        = 30 * time.Second
+	PassiveDnsTailPollInterval  = 1 * time.Second
+	PassiveDnsRestartInterval   = 5 * time.Second
+)
+
+var errLogFileRotated = errors.New("log file rotated or truncated")
+
+type dnsmasqLogKind int
+
+const (
+	dnsmasqLogKindQuery dnsmasqLogKind = iota
+	dnsmasqLogKindAnswer
+)
+
+// dnsmasqLogEntry 是一行 dnsmasq log-queries 日志的解析结果
+type dnsmasqLogEntry struct {
+	kind   dnsmasqLogKind
+	serial string // 只有 log-queries=extra 才有
+	name   string
+	value  string // query 行是 client ip , answer 行是 ip 或 <CNAME> 之类
+}
+
+type pendingDnsQuery struct {
+	name     string
+	client   string
+	hasCname bool
+	seenAt   time.Time
+}
+
+type passiveDnsEntry struct {
+	domain    string
+	clients   []string
+	firstSeen time.Time
+	lastSeen  time.Time
+	expireAt  time.Time
+}
+
+// PassiveDnsService 从 dnsmasq 的查询日志里记录 "应答 ip -> 客户端查询的域名" 的映射
+type PassiveDnsService struct {
+	logFile    string // 为空时使用 logread -f
+	ttl        time.Duration
+	maxEntries int
+	mutex      sync.RWMutex
+	records    map[netip.Addr][]*passiveDnsEntry
+	count      int
+	pending    map[string]*pendingDnsQuery
+}
+
+func NewPassiveDnsService(logFile string, ttl time.Duration, maxEntries int) *PassiveDnsService {
+	return &PassiveDnsService{
+		logFile:    logFile,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		records:    make(map[netip.Addr][]*passiveDnsEntry),
+		pending:    make(map[string]*pendingDnsQuery),
+	}
+}
+
+func (pds *PassiveDnsService) Run(ctx context.Context) {
+	go pds.runGc(ctx)
+	fromStart := false
+	for {
+		var err error
+		if pds.logFile != "" {
+			err = pds.tailFile(ctx, pds.logFile, fromStart)
+			// 轮转或截断后新文件里的内容都是没读过的 , 立即从头读 ;
+			// 重新打开时文件又不见了 , 下次打开的也是新文件
+			fromStart = errors.Is(err, errLogFileRotated) || (fromStart && errors.Is(err, fs.ErrNotExist))
+		} else {
+			err = pds.followLogread(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errLogFileRotated) {
+			log.Printf("Passive dns log file %q is rotated , reopen it", pds.logFile)
+			continue
+		}
+		if err != nil {
+			log.Printf("Passive dns log source stopped: %s , restart after %v", err, PassiveDnsRestartInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(PassiveDnsRestartInterval):
+		}
+	}
+}
+
+func (pds *PassiveDnsService) runGc(ctx context.Context) {
+	ticker := time.NewTicker(PassiveDnsGcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pds.cleanupExpired(time.Now())
+		}
+	}
+}
+
+// OpenWrt 上 dnsmasq 默认把日志写到 syslog , 所以跟随 logread 的输出
+func (pds *PassiveDnsService) followLogread(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "logread", "-f", "-e", "dnsmasq")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Println("Passive dns is following dnsmasq logs from logread")
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		pds.HandleLogLine(scanner.Text(), time.Now())
+	}
+	return cmd.Wait()
+}
+
+// 类似 tail -F , 第一次打开时从文件末尾开始读 , fromStart 为 true 时从头读 .
+// 文件被截断或者轮转后返回 errLogFileRotated , 轮转的话先把旧文件剩下的内容读完
+func (pds *PassiveDnsService) tailFile(ctx context.Context, path string, fromStart bool) error {
+	file, err := os.Open(path) // #nosec G304 -- path comes from command line flag
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset := int64(0)
+	if !fromStart {
+		if offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	log.Printf("Passive dns is following dnsmasq logs from %q", path)
+
+	reader := bufio.NewReader(file)
+	partial := ""
+	rotated := false
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			pds.HandleLogLine(partial+strings.TrimRight(line, "\r\n"), time.Now())
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+		if rotated {
+			if partial != "" {
+				pds.HandleLogLine(partial, time.Now())
+			}
+			return errLogFileRotated
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(PassiveDnsTailPollInterval):
+		}
+
+		nowInfo, statErr := os.Stat(path)
+		openInfo, fstatErr := file.Stat()
+		if statErr != nil || fstatErr != nil {
+			continue
+		}
+		rotated = !os.SameFile(nowInfo, openInfo) || nowInfo.Size() < offset
+	}
+}
+
+// example :
+//
+//	Sat Jan 10 12:00:00 2026 daemon.info dnsmasq[1234]: query[A] www.youtube.com from 192.168.1.10
+//	Jan 10 12:00:00 dnsmasq[1234]: 1234 192.168.1.10/53211 reply youtube-ui.l.google.com is 142.250.1.1
+func parseDnsmasqLogLine(line string) (dnsmasqLogEntry, bool) {
+	entry := dnsmasqLogEntry{}
+	index := strings.Index(line, "dnsmasq[")
+	if index < 0 {
+		return entry, false
+	}
+	message := line[index:]
+	index = strings.Index(message, "]: ")
+	if index < 0 {
+		return entry, false
+	}
+	fields := strings.Fields(message[index+3:])
+
+	// log-queries=extra : "<serial> <client>/<port> ..."
+	if len(fields) > 2 && strings.Contains(fields[1], "/") && isDigits(fields[0]) {
+		entry.serial = fields[0]
+		fields = fields[2:]
+	}
+	if len(fields) < 4 {
+		return entry, false
+	}
+
+	switch {
+	case strings.HasPrefix(fields[0], "query[") && fields[2] == "from":
+		entry.kind = dnsmasqLogKindQuery
+		entry.name = strings.ToLower(fields[1])
+		entry.value = fields[3]
+	case (fields[0] == "reply" || fields[0] == "cached") && fields[2] == "is":
+		entry.kind = dnsmasqLogKindAnswer
+		entry.name = strings.ToLower(fields[1])
+		entry.value = fields[3]
+	default:
+		return entry, false
+	}
+	return entry, true
+}
+
+func isDigits(input string) bool {
+	if input == "" {
+		return false
+	}
+	for _, char := range input {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (pds *PassiveDnsService) HandleLogLine(line string, now time.Time) {
+	entry, ok := parseDnsmasqLogLine(line)
+	if !ok {
+		return
+	}
+
+	pds.mutex.Lock()
+	defer pds.mutex.Unlock()
+
+	if entry.kind == dnsmasqLogKindQuery {
+		pds.pending[entry.serial] = &pendingDnsQuery{
+			name:   entry.name,
+			client: entry.value,
+			seenAt: now,
+		}
+		return
+	}
+
+	query := pds.pending[entry.serial]
+	if entry.value == "<CNAME>" {
+		if query != nil && query.name == entry.name {
+			query.hasCname = true
+		}
+		return
+	}
+	addr, err := netip.ParseAddr(entry.value)
+	if err != nil {
+		// NXDOMAIN / NODATA 之类
+		return
+	}
+
+	// CNAME 链上的应答记到客户端最初查询的域名上 ,
+	// 没有 serial 时只能靠相邻行判断 , 所以要求 CNAME 出现在同一个查询里
+	domain := entry.name
+	client := ""
+	if query != nil && (query.name == entry.name || query.hasCname) {
+		domain = query.name
+		client = query.client
+	}
+	pds.record(addr.Unmap(), domain, client, now)
+}
+
+// 调用前必须持有写锁
+func (pds *PassiveDnsService) record(addr netip.Addr, domain string, client string, now time.Time) {
+	entries := pds.records[addr]
+	for _, item := range entries {
+		if item.domain != domain {
+			continue
+		}
+		item.lastSeen = now
+		item.expireAt = now.Add(pds.ttl)
+		if client != "" && !slices.Contains(item.clients, client) && len(item.clients) < PassiveDnsMaxClientsPerItem {
+			item.clients = append(item.clients, client)
+		}
+		return
+	}
+
+	if pds.maxEntries > 0 && pds.count >= pds.maxEntries {
+		pds.evictOldest(now)
+	}
+
+	item := &passiveDnsEntry{
+		domain:    domain,
+		firstSeen: now,
+		lastSeen:  now,
+		expireAt:  now.Add(pds.ttl),
+	}
+	if client != "" {
+		item.clients = []string{client}
+	}
+	if len(entries) >= PassiveDnsMaxDomainsPerIp {
+		// 同一个 ip 只保留最近的几个域名 (CDN 的 ip 会被很多域名共用)
+		oldest := 0
+		for index, value := range entries {
+			if value.lastSeen.Before(entries[oldest].lastSeen) {
+				oldest = index
+			}
+		}
+		entries = slices.Delete(entries, oldest, oldest+1)
+		pds.count -= 1
+	}
+	pds.records[addr] = append(entries, item)
+	pds.count += 1
+}
+
+// 超过容量时先清理过期记录 , 还不够就淘汰最久没见到的 1/10
+func (pds *PassiveDnsService) evictOldest(now time.Time) {
+	pds.cleanupExpiredLocked(now)
+	if pds.count < pds.maxEntries {
+		return
+	}
+	type ref struct {
+		addr     netip.Addr
+		lastSeen time.Time
+	}
+	refs := make([]ref, 0, len(pds.records))
+	for addr, entries := range pds.records {
+		latest := time.Time{}
+		for _, item := range entries {
+			if item.lastSeen.After(latest) {
+				latest = item.lastSeen
+			}
+		}
+		refs = append(refs, ref{addr, latest})
+	}
+	slices.SortFunc(refs, func(a, b ref) int { return a.lastSeen.Compare(b.lastSeen) })
+	for _, item := range refs[:max(1, len(refs)/10)] {
+		pds.count -= len(pds.records[item.addr])
+		delete(pds.records, item.addr)
+	}
+}
+
+func (pds *PassiveDnsService) cleanupExpired(now time.Time) {
+	pds.mutex.Lock()
+	defer pds.mutex.Unlock()
+	pds.cleanupExpiredLocked(now)
+}
+
+func (pds *PassiveDnsService) cleanupExpiredLocked(now time.Time) {
+	for addr, entries := range pds.records {
+		kept := entries[:0]
+		for _, item := range entries {
+			if now.Before(item.expireAt) {
+				kept = append(kept, item)
+			}
+		}
+		pds.count -= len(entries) - len(kept)
+		if len(kept) == 0 {
+			delete(pds.records, addr)
+			continue
+		}
+		pds.records[addr] = kept
+	}
+	for serial, query := range pds.pending {
+		if now.Sub(query.seenAt) > PassiveDnsPendingExpireTime {
+			delete(pds.pending, serial)
+		}
+	}
+}
+
+// LookupDomain 返回 ip 对应的域名 , 优先返回 client 自己查询过的域名 ,
+// 找不到返回空字符串
+func (pds *PassiveDnsService) LookupDomain(ip string, client string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	now := time.Now()
+
+	pds.mutex.RLock()
+	defer pds.mutex.RUnlock()
+	var latest, latestByClient *passiveDnsEntry
+	for _, item := range pds.records[addr.Unmap()] {
+		if now.After(item.expireAt) {
+			continue
+		}
+		if latest == nil || item.lastSeen.After(latest.lastSeen) {
+			latest = item
+		}
+		if client != "" && slices.Contains(item.clients, client) &&
+			(latestByClient == nil || item.lastSeen.After(latestByClient.lastSeen)) {
+			latestByClient = item
+		}
+	}
+	if latestByClient != nil {
+		return latestByClient.domain
+	}
+	if latest != nil {
+		return latest.domain
+	}
+	return ""
+}
+
+// Records 返回指定 ip 的记录 , ips 为空时返回全部记录
+func (pds *PassiveDnsService) Records(ips []string) model.PassiveDnsResult {
+	pds.mutex.RLock()
+	defer pds.mutex.RUnlock()
+
+	result := model.PassiveDnsResult{}
+	appendRecords := func(addr netip.Addr, key string) {
+		for _, item := range pds.records[addr] {
+			result[key] = append(result[key], model.PassiveDnsRecord{
+				Domain:    item.domain,
+				Clients:   slices.Clone(item.clients),
+				FirstSeen: item.firstSeen,
+				LastSeen:  item.lastSeen,
+				ExpireAt:  item.expireAt,
+			})
+		}
+	}
+
+	if len(ips) == 0 {
+		for addr := range pds.records {
+			appendRecords(addr, addr.String())
+		}
+		return result
+	}
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		appendRecords(addr.Unmap(), ip)
+	}
+	return result
+}
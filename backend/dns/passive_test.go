@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDnsmasqLogLine(t *testing.T) {
+	testCases := []struct {
+		testName string
+		input    string
+		ok       bool
+		expected dnsmasqLogEntry
+	}{
+		{
+			testName: "logread query",
+			input:    "Sat Jan 10 12:00:00 2026 daemon.info dnsmasq[1234]: query[A] WWW.YouTube.com from 192.168.1.10",
+			ok:       true,
+			expected: dnsmasqLogEntry{kind: dnsmasqLogKindQuery, name: "www.youtube.com", value: "192.168.1.10"},
+		},
+		{
+			testName: "log file extra reply",
+			input:    "Jan 10 12:00:00 dnsmasq[1234]: 77 192.168.1.10/53211 reply youtube-ui.l.google.com is 142.250.1.1",
+			ok:       true,
+			expected: dnsmasqLogEntry{kind: dnsmasqLogKindAnswer, serial: "77", name: "youtube-ui.l.google.com", value: "142.250.1.1"},
+		},
+		{
+			testName: "cached answer",
+			input:    "Jan 10 12:00:00 dnsmasq[1234]: cached example.com is 2606:2800:220:1::1",
+			ok:       true,
+			expected: dnsmasqLogEntry{kind: dnsmasqLogKindAnswer, name: "example.com", value: "2606:2800:220:1::1"},
+		},
+		{
+			testName: "forwarded line ignored",
+			input:    "Jan 10 12:00:00 dnsmasq[1234]: forwarded example.com to 8.8.8.8",
+			ok:       false,
+		},
+		{
+			testName: "not dnsmasq",
+			input:    "Jan 10 12:00:00 odhcpd[99]: reply example.com is 1.1.1.1",
+			ok:       false,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			entry, ok := parseDnsmasqLogLine(testCase.input)
+			assert.Equal(t, testCase.ok, ok)
+			if ok {
+				assert.Equal(t, testCase.expected, entry)
+			}
+		})
+	}
+}
+
+func TestPassiveDnsServiceCnameChain(t *testing.T) {
+	service := NewPassiveDnsService("", time.Hour, 100)
+	now := time.Now()
+	lines := []string{
+		"dnsmasq[1]: query[A] www.youtube.com from 192.168.1.10",
+		"dnsmasq[1]: forwarded www.youtube.com to 8.8.8.8",
+		"dnsmasq[1]: reply www.youtube.com is <CNAME>",
+		"dnsmasq[1]: reply youtube-ui.l.google.com is 142.250.1.1",
+		"dnsmasq[1]: query[A] ntp.org from 192.168.1.20",
+		"dnsmasq[1]: cached ntp.org is 142.250.1.1",
+		"dnsmasq[1]: reply other.example.com is 9.9.9.9",
+	}
+	for _, line := range lines {
+		service.HandleLogLine(line, now)
+	}
+
+	assert.Equal(t, "www.youtube.com", service.LookupDomain("142.250.1.1", "192.168.1.10"))
+	assert.Equal(t, "ntp.org", service.LookupDomain("142.250.1.1", "192.168.1.20"))
+	assert.Equal(t, "other.example.com", service.LookupDomain("9.9.9.9", ""))
+	assert.Equal(t, "", service.LookupDomain("1.2.3.4", ""))
+
+	records := service.Records([]string{"142.250.1.1"})
+	assert.Len(t, records["142.250.1.1"], 2)
+
+	service.cleanupExpired(now.Add(2 * time.Hour))
+	assert.Equal(t, "", service.LookupDomain("9.9.9.9", ""))
+	assert.Equal(t, 0, service.count)
+}
+
+func TestPassiveDnsServiceMaxEntries(t *testing.T) {
+	service := NewPassiveDnsService("", time.Hour, 10)
+	now := time.Now()
+	for index := range 20 {
+		line := "dnsmasq[1]: reply example.com is 10.0.0." + strconv.Itoa(index)
+		service.HandleLogLine(line, now.Add(time.Duration(index)*time.Second))
+	}
+	assert.LessOrEqual(t, service.count, 10)
+	assert.Equal(t, "example.com", service.LookupDomain("10.0.0.19", ""))
+	assert.Equal(t, "", service.LookupDomain("10.0.0.0", ""))
+}
+
+func TestPassiveDnsServiceTailFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.log")
+	appendLines := func(lines ...string) {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		assert.NoError(t, err)
+		for _, line := range lines {
+			_, err = file.WriteString(line + "\n")
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, file.Close())
+	}
+	appendLines("dnsmasq[1]: reply before.example.com is 10.0.0.1")
+	pds := NewPassiveDnsService(path, time.Hour, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pds.Run(ctx)
+	time.Sleep(200 * time.Millisecond)
+	lookup := func(ip string, domain string) func() bool {
+		return func() bool { return pds.LookupDomain(ip, "") == domain }
+	}
+	waitFor := 5 * time.Second
+
+	appendLines("dnsmasq[1]: reply appended.example.com is 10.0.0.2")
+	assert.Eventually(t, lookup("10.0.0.2", "appended.example.com"), waitFor, 50*time.Millisecond)
+	// 第一次打开时从末尾开始读
+	assert.Equal(t, "", pds.LookupDomain("10.0.0.1", ""))
+
+	// logrotate : 旧文件改名后写入的内容和新文件里的内容都要读到
+	assert.NoError(t, os.Rename(path, path+".1"))
+	file, err := os.OpenFile(path+".1", os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = file.WriteString("dnsmasq[1]: reply old-file.example.com is 10.0.0.3\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	appendLines(
+		"dnsmasq[1]: reply rotated-first.example.com is 10.0.0.4",
+		"dnsmasq[1]: reply rotated-second.example.com is 10.0.0.5",
+	)
+	assert.Eventually(t, lookup("10.0.0.3", "old-file.example.com"), waitFor, 50*time.Millisecond)
+	assert.Eventually(t, lookup("10.0.0.4", "rotated-first.example.com"), waitFor, 50*time.Millisecond)
+	assert.Eventually(t, lookup("10.0.0.5", "rotated-second.example.com"), waitFor, 50*time.Millisecond)
+
+	// copytruncate : 截断后从头读
+	assert.NoError(t, os.Truncate(path, 0))
+	appendLines("dnsmasq[1]: reply short.example.com is 10.0.0.6")
+	assert.Eventually(t, lookup("10.0.0.6", "short.example.com"), waitFor, 50*time.Millisecond)
+}
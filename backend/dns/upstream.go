@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"openwrt-diskio-api/backend/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DnsUpstreamMaxFailures   = 3                // 连续失败几次后暂时跳过该上游
+	DnsUpstreamRetryInterval = 30 * time.Second // 被跳过的上游多久后重新尝试
+)
+
+type DnsUpstream struct {
+	Transport  model.DnsTransportType
+	Host       string
+	Port       int
+	ServerName string // DoT 握手时使用的 SNI
+	resolver   *net.Resolver
+
+	mutex               sync.Mutex
+	consecutiveFailures int
+	totalQueries        uint64
+	totalFailures       uint64
+	lastError           string
+	lastSuccessAt       time.Time
+	lastFailureAt       time.Time
+}
+
+// ParseDnsUpstreamList 解析逗号分隔的上游列表
+//
+// example : "udp://127.0.0.1:53,tcp://[::1]:5353,tls://1.1.1.1:853#cloudflare-dns.com"
+func ParseDnsUpstreamList(raw string, queryTimeout time.Duration) ([]*DnsUpstream, error) {
+	var result []*DnsUpstream
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		upstream, err := ParseDnsUpstream(item, queryTimeout)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, upstream)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("No dns upstream found in %q", raw)
+	}
+	return result, nil
+}
+
+// ParseDnsUpstream 支持的格式 :
+//
+//	127.0.0.1 / [::1]:5353            (默认 udp)
+//	udp://127.0.0.1:53
+//	tcp://127.0.0.1:5353
+//	tls://1.1.1.1:853#cloudflare-dns.com ("#" 后面是 SNI , 不填则使用 host)
+func ParseDnsUpstream(raw string, queryTimeout time.Duration) (*DnsUpstream, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = string(model.DnsTransportUdp) + "://" + bracketBareIpv6(raw)
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Parse dns upstream %q failed: %w", raw, err)
+	}
+
+	upstream := &DnsUpstream{
+		Transport:  model.DnsTransportType(strings.ToLower(parsed.Scheme)),
+		Host:       parsed.Hostname(),
+		ServerName: parsed.Fragment,
+	}
+	if upstream.Host == "" {
+		return nil, fmt.Errorf("Dns upstream %q missing host", raw)
+	}
+
+	switch upstream.Transport {
+	case model.DnsTransportUdp, model.DnsTransportTcp:
+		upstream.Port = 53
+	case model.DnsTransportTls:
+		upstream.Port = 853
+		if upstream.ServerName == "" {
+			upstream.ServerName = upstream.Host
+		}
+	default:
+		return nil, fmt.Errorf("Dns upstream %q has unsupported transport %q", raw, parsed.Scheme)
+	}
+
+	if rawPort := parsed.Port(); rawPort != "" {
+		port, err := strconv.Atoi(rawPort)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("Dns upstream %q has invalid port %q", raw, rawPort)
+		}
+		upstream.Port = port
+	}
+
+	upstream.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return upstream.dial(ctx, network, queryTimeout)
+		},
+	}
+	return upstream, nil
+}
+
+// 裸 ipv6 地址(不带端口)需要加上中括号 , 否则 url.Parse 会把最后一段当成端口
+func bracketBareIpv6(raw string) string {
+	if addr, err := netip.ParseAddr(raw); err == nil && addr.Is6() {
+		return "[" + raw + "]"
+	}
+	return raw
+}
+
+func (u *DnsUpstream) Address() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+func (u *DnsUpstream) String() string {
+	return string(u.Transport) + "://" + u.Address()
+}
+
+func (u *DnsUpstream) dial(ctx context.Context, network string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+	}
+	switch u.Transport {
+	case model.DnsTransportTcp:
+		return dialer.DialContext(ctx, "tcp", u.Address())
+	case model.DnsTransportTls:
+		// 返回的不是 PacketConn , go 的 resolver 会自动使用 tcp 的长度前缀格式
+		tlsDialer := tls.Dialer{
+			NetDialer: &dialer,
+			Config: &tls.Config{
+				ServerName: u.ServerName,
+				MinVersion: tls.VersionTLS12,
+			},
+		}
+		return tlsDialer.DialContext(ctx, "tcp", u.Address())
+	default:
+		// udp 响应被截断时 go 的 resolver 会用 tcp 重试 , 所以这里跟随 network
+		if strings.HasPrefix(network, "tcp") {
+			return dialer.DialContext(ctx, "tcp", u.Address())
+		}
+		return dialer.DialContext(ctx, "udp", u.Address())
+	}
+}
+
+func (u *DnsUpstream) LookupAddr(ctx context.Context, ip string) ([]string, error) {
+	names, err := u.resolver.LookupAddr(ctx, ip)
+	// NXDOMAIN 也是上游正常返回的结果
+	var dnsErr *net.DNSError
+	if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		u.markSuccess()
+	} else {
+		u.markFailure(err)
+	}
+	return names, err
+}
+
+func (u *DnsUpstream) markSuccess() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.totalQueries += 1
+	u.consecutiveFailures = 0
+	u.lastSuccessAt = time.Now()
+}
+
+func (u *DnsUpstream) markFailure(err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.totalQueries += 1
+	u.totalFailures += 1
+	u.consecutiveFailures += 1
+	u.lastFailureAt = time.Now()
+	if err != nil {
+		u.lastError = err.Error()
+	}
+}
+
+func (u *DnsUpstream) IsHealthy() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.consecutiveFailures < DnsUpstreamMaxFailures
+}
+
+// 不健康的上游在 DnsUpstreamRetryInterval 之后才会被重新尝试
+func (u *DnsUpstream) isSuspended(now time.Time) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.consecutiveFailures < DnsUpstreamMaxFailures {
+		return false
+	}
+	return now.Before(u.lastFailureAt.Add(DnsUpstreamRetryInterval))
+}
+
+func (u *DnsUpstream) Status() model.DnsUpstreamStatus {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return model.DnsUpstreamStatus{
+		Address:             u.Address(),
+		Transport:           u.Transport,
+		ServerName:          u.ServerName,
+		Healthy:             u.consecutiveFailures < DnsUpstreamMaxFailures,
+		ConsecutiveFailures: u.consecutiveFailures,
+		TotalQueries:        u.totalQueries,
+		TotalFailures:       u.totalFailures,
+		LastError:           u.lastError,
+		LastSuccessAt:       u.lastSuccessAt,
+		LastFailureAt:       u.lastFailureAt,
+	}
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"openwrt-diskio-api/backend/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDnsUpstream(t *testing.T) {
+	testCases := []struct {
+		testName           string
+		input              string
+		expectedTransport  model.DnsTransportType
+		expectedAddress    string
+		expectedServerName string
+		expectedError      bool
+	}{
+		{"bare ipv4", "127.0.0.1", model.DnsTransportUdp, "127.0.0.1:53", "", false},
+		{"bare ipv6", "::1", model.DnsTransportUdp, "[::1]:53", "", false},
+		{"bare ipv6 with port", "[::1]:5353", model.DnsTransportUdp, "[::1]:5353", "", false},
+		{"udp with port", "udp://127.0.0.1:5353", model.DnsTransportUdp, "127.0.0.1:5353", "", false},
+		{"tcp default port", "tcp://192.168.1.1", model.DnsTransportTcp, "192.168.1.1:53", "", false},
+		{"tls with sni", "tls://1.1.1.1#cloudflare-dns.com", model.DnsTransportTls, "1.1.1.1:853", "cloudflare-dns.com", false},
+		{"tls without sni", "tls://dns.google:8853", model.DnsTransportTls, "dns.google:8853", "dns.google", false},
+		{"unsupported transport", "https://1.1.1.1", "", "", "", true},
+		{"invalid port", "udp://127.0.0.1:70000", "", "", "", true},
+		{"missing host", "udp://:53", "", "", "", true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			upstream, err := ParseDnsUpstream(testCase.input, time.Second)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedTransport, upstream.Transport)
+			assert.Equal(t, testCase.expectedAddress, upstream.Address())
+			assert.Equal(t, testCase.expectedServerName, upstream.ServerName)
+		})
+	}
+}
+
+func TestParseDnsUpstreamList(t *testing.T) {
+	upstreams, err := ParseDnsUpstreamList("udp://127.0.0.1:5353, ,tls://1.1.1.1#cloudflare-dns.com", time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, upstreams, 2)
+	assert.Equal(t, "udp://127.0.0.1:5353", upstreams[0].String())
+	assert.Equal(t, "tls://1.1.1.1:853", upstreams[1].String())
+
+	_, err = ParseDnsUpstreamList(" , ", time.Second)
+	assert.Error(t, err)
+}
+
+func TestDnsUpstreamHealth(t *testing.T) {
+	upstream, err := ParseDnsUpstream("127.0.0.1", time.Second)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	for range DnsUpstreamMaxFailures {
+		upstream.markFailure(assert.AnError)
+	}
+	assert.False(t, upstream.IsHealthy())
+	assert.True(t, upstream.isSuspended(now))
+	assert.False(t, upstream.isSuspended(now.Add(DnsUpstreamRetryInterval+time.Second)))
+
+	upstream.markSuccess()
+	status := upstream.Status()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, uint64(DnsUpstreamMaxFailures+1), status.TotalQueries)
+	assert.Equal(t, uint64(DnsUpstreamMaxFailures), status.TotalFailures)
+	assert.Equal(t, assert.AnError.Error(), status.LastError)
+}
@@ -27,14 +27,65 @@ struct flow_stats {
     __u64 last_seen;
 };
 
+#define CONFIG_KEY_CAPTURE   0 // 流量统计开关
+#define CONFIG_KEY_DNS_SNOOP 1 // dns 应答嗅探开关
+#define CONFIG_KEY_SNI       2 // TLS ClientHello / QUIC Initial 采样开关
+#define DNS_PORT             53
+#define DNS_CAPTURE_MAX      1024 // 每个 dns 应答最多复制到用户态的字节数
+#define TLS_PORT             443
+#define SNI_CAPTURE_MAX      1600 // QUIC Initial 需要完整的包才能解密
+
+/* * dns 应答事件：后面紧跟从以太网头开始的报文内容
+ * 域名解析放在用户态做，内核里只做边界检查和头部过滤，保证 5.4 内核的 verifier 能通过
+ */
+struct dns_event {
+    __u32 client_addr[4]; // 应答的目的地址，也就是发起查询的客户端
+    __u32 payload_offset; // dns 头在报文中的偏移
+    __u16 payload_len;    // 复制到用户态的 dns 报文长度
+    __u8  family;
+    __u8  _pad;
+};
+
+struct dns_header {
+    __be16 id;
+    __be16 flags;
+    __be16 qdcount;
+    __be16 ancount;
+    __be16 nscount;
+    __be16 arcount;
+};
+
+/* * 握手采样事件：后面紧跟从以太网头开始的报文内容
+ * SNI 的解析和 QUIC Initial 的解密都在用户态完成
+ */
+struct sni_event {
+    struct flow_key key;
+    __u32 payload_offset; // tcp/udp 负载在报文中的偏移
+    __u16 payload_len;
+    __u16 _pad;
+};
+
 // 配置 Map：控制开关
 struct {
     __uint(type, BPF_MAP_TYPE_ARRAY);
-    __uint(max_entries, 1);
+    __uint(max_entries, 3);
     __type(key, __u32);
     __type(value, __u32);
 } config_map SEC(".maps");
 
+// BPF_MAP_TYPE_RINGBUF 需要 5.8 内核，这里用 perf event array 兼容 5.4
+struct {
+    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u32));
+} dns_events SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u32));
+} sni_events SEC(".maps");
+
 /* * 核心改进：使用 PERCPU_HASH 
  * 1. 消除多核争用造成的 CPU 抖动。
  * 2. 提高在大流量压测下的数据稳定性。
@@ -46,12 +97,61 @@ struct {
     __type(value, struct flow_stats);
 } flow_map SEC(".maps");
 
+static __always_inline void snoop_dns_response(
+    struct __sk_buff *skb, struct flow_key *key,
+    void *dns_data, void *data_end, __u32 dns_offset
+) {
+    struct dns_header *dns = dns_data;
+    if ((void *)dns + sizeof(*dns) > data_end) return;
+
+    // 只要 QR=1、标准查询、NOERROR、且带有 question 和 answer 的应答
+    __u16 flags = bpf_ntohs(dns->flags);
+    if (!(flags & 0x8000) || (flags & 0x7800) || (flags & 0x000f)) return;
+    if (dns->qdcount == 0 || dns->ancount == 0) return;
+
+    __u64 capture_len = skb->len;
+    if (capture_len <= dns_offset) return;
+    if (capture_len > dns_offset + DNS_CAPTURE_MAX) capture_len = dns_offset + DNS_CAPTURE_MAX;
+
+    struct dns_event event = {0};
+    __builtin_memcpy(event.client_addr, key->dst_addr, sizeof(event.client_addr));
+    event.family = key->family;
+    event.payload_offset = dns_offset;
+    event.payload_len = capture_len - dns_offset;
+
+    // flags 的高 32 位是需要追加的 skb 长度，内核会处理非线性 skb
+    bpf_perf_event_output(skb, &dns_events, (capture_len << 32) | BPF_F_CURRENT_CPU, &event, sizeof(event));
+}
+
+static __always_inline void sample_client_hello(
+    struct __sk_buff *skb, struct flow_key *key, __u32 payload_offset
+) {
+    __u64 capture_len = skb->len;
+    if (capture_len <= payload_offset) return;
+    if (capture_len > payload_offset + SNI_CAPTURE_MAX) capture_len = payload_offset + SNI_CAPTURE_MAX;
+
+    struct sni_event event = {0};
+    __builtin_memcpy(&event.key, key, sizeof(event.key));
+    event.payload_offset = payload_offset;
+    event.payload_len = capture_len - payload_offset;
+
+    bpf_perf_event_output(skb, &sni_events, (capture_len << 32) | BPF_F_CURRENT_CPU, &event, sizeof(event));
+}
+
 SEC("classifier")
 int count_flow(struct __sk_buff *skb) {
     // 1. 检查采集开关
-    __u32 config_key = 0;
-    __u32 *enabled = bpf_map_lookup_elem(&config_map, &config_key);
-    if (!enabled || *enabled == 0) {
+    __u32 config_key = CONFIG_KEY_CAPTURE;
+    __u32 *capture_enabled = bpf_map_lookup_elem(&config_map, &config_key);
+    config_key = CONFIG_KEY_DNS_SNOOP;
+    __u32 *dns_snoop_enabled = bpf_map_lookup_elem(&config_map, &config_key);
+    config_key = CONFIG_KEY_SNI;
+    __u32 *sni_enabled = bpf_map_lookup_elem(&config_map, &config_key);
+
+    int capture = capture_enabled && *capture_enabled != 0;
+    int dns_snoop = dns_snoop_enabled && *dns_snoop_enabled != 0;
+    int sni = sni_enabled && *sni_enabled != 0;
+    if (!capture && !dns_snoop && !sni) {
         return TC_ACT_OK;
     }
 
@@ -63,6 +163,7 @@ int count_flow(struct __sk_buff *skb) {
 
     struct flow_key key = {0};
     void *l4_header = NULL;
+    __u32 l4_offset = sizeof(*eth);
 
     // 2. 解析网络层
     if (eth->h_proto == bpf_htons(ETH_P_IP)) {
@@ -74,6 +175,7 @@ int count_flow(struct __sk_buff *skb) {
         key.dst_addr[0] = ip->daddr;
         key.proto = ip->protocol;
         l4_header = (void *)ip + (ip->ihl * 4);
+        l4_offset += ip->ihl * 4;
     } 
     else if (eth->h_proto == bpf_htons(ETH_P_IPV6)) {
         struct ipv6hdr *ip6 = data + sizeof(*eth);
@@ -84,6 +186,7 @@ int count_flow(struct __sk_buff *skb) {
         __builtin_memcpy(key.dst_addr, &ip6->daddr, 16);
         key.proto = ip6->nexthdr;
         l4_header = (void *)ip6 + sizeof(*ip6);
+        l4_offset += sizeof(*ip6);
     } 
     else {
         return TC_ACT_OK;
@@ -96,16 +199,39 @@ int count_flow(struct __sk_buff *skb) {
             if ((void *)tcp + sizeof(*tcp) <= data_end) {
                 key.src_port = bpf_ntohs(tcp->source);
                 key.dst_port = bpf_ntohs(tcp->dest);
+                if (sni && key.dst_port == TLS_PORT) {
+                    // TLS handshake record 且 handshake 类型是 ClientHello
+                    __u32 tcp_len = tcp->doff * 4;
+                    __u8 *payload = (void *)tcp + tcp_len;
+                    if ((void *)payload + 6 <= data_end &&
+                        payload[0] == 0x16 && payload[1] == 0x03 && payload[5] == 0x01) {
+                        sample_client_hello(skb, &key, l4_offset + tcp_len);
+                    }
+                }
             }
         } else if (key.proto == IPPROTO_UDP) {
             struct udphdr *udp = l4_header;
             if ((void *)udp + sizeof(*udp) <= data_end) {
                 key.src_port = bpf_ntohs(udp->source);
                 key.dst_port = bpf_ntohs(udp->dest);
+                if (dns_snoop && key.src_port == DNS_PORT) {
+                    snoop_dns_response(skb, &key, (void *)udp + sizeof(*udp), data_end, l4_offset + sizeof(*udp));
+                }
+                if (sni && key.dst_port == TLS_PORT) {
+                    // QUIC long header , 具体是不是 Initial 交给用户态判断
+                    __u8 *payload = (void *)udp + sizeof(*udp);
+                    if ((void *)payload + 1 <= data_end && (payload[0] & 0xc0) == 0xc0) {
+                        sample_client_hello(skb, &key, l4_offset + sizeof(*udp));
+                    }
+                }
             }
         }
     }
 
+    if (!capture) {
+        return TC_ACT_OK;
+    }
+
     // 4. 更新统计
     // 在 Per-CPU Map 中，lookup 返回的是当前 CPU 核心对应的私有存储区
     struct flow_stats *val = bpf_map_lookup_elem(&flow_map, &key);
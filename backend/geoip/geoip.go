@@ -0,0 +1,109 @@
+// Package geoip 使用本地的 MaxMind 格式 (mmdb) 数据库给公网地址标注国家和 ASN , 不会发起任何网络请求
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var ErrNoDatabase = errors.New("no geoip database configured")
+
+// 兼容 GeoLite2-Country / GeoLite2-City / DB-IP 的国家库
+type countryRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// 兼容 GeoLite2-ASN / DB-IP ASN 库
+type asnRecord struct {
+	AutonomousSystemNumber       uint32 `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+type GeoIpService struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// NewGeoIpService 打开国家库和 ASN 库 , 路径为空表示不使用该库 , 两个都为空时返回 ErrNoDatabase
+func NewGeoIpService(countryFile string, asnFile string) (*GeoIpService, error) {
+	if countryFile == "" && asnFile == "" {
+		return nil, ErrNoDatabase
+	}
+	g := &GeoIpService{}
+	if countryFile != "" {
+		reader, err := maxminddb.Open(countryFile)
+		if err != nil {
+			return nil, fmt.Errorf("Open geoip country database %q failed: %w", countryFile, err)
+		}
+		g.country = reader
+	}
+	if asnFile != "" {
+		reader, err := maxminddb.Open(asnFile)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("Open geoip asn database %q failed: %w", asnFile, err)
+		}
+		g.asn = reader
+	}
+	return g, nil
+}
+
+func (g *GeoIpService) Close() {
+	if g.country != nil {
+		_ = g.country.Close()
+	}
+	if g.asn != nil {
+		_ = g.asn.Close()
+	}
+}
+
+// LookupGeoIp 只查询公网地址 , 局域网等地址或者数据库里没有的地址返回 false
+func (g *GeoIpService) LookupGeoIp(ip string) (model.GeoIpInfo, bool) {
+	result := model.GeoIpInfo{}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return result, false
+	}
+	addr = addr.Unmap()
+	if !isPublicAddr(addr) {
+		return result, false
+	}
+	netIp := net.IP(addr.AsSlice())
+
+	if g.country != nil {
+		var record countryRecord
+		if err := g.country.Lookup(netIp, &record); err == nil {
+			result.CountryCode = record.Country.IsoCode
+			if result.CountryCode == "" {
+				// anycast 等地址没有实际所在国家 , 用注册国家代替
+				result.CountryCode = record.RegisteredCountry.IsoCode
+			}
+		}
+	}
+	if g.asn != nil {
+		var record asnRecord
+		if err := g.asn.Lookup(netIp, &record); err == nil {
+			result.Asn = record.AutonomousSystemNumber
+			result.AsOrganization = record.AutonomousSystemOrganization
+		}
+	}
+	return result, result != model.GeoIpInfo{}
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnatPrefix.Contains(addr)
+}
+
+// 运营商级 NAT 的地址不在 IsPrivate 里
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
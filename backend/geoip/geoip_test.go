@@ -0,0 +1,166 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试用的最小 mmdb 写入器 , 只支持 ipv4 树 , 24 位记录 , 长度小于 285 的 string/uint/map/array
+type testMmdbValue = any
+
+type testMmdbMap [][2]testMmdbValue
+
+func encodeMmdbValue(value testMmdbValue) []byte {
+	controlByte := func(dataType int, size int) []byte {
+		var extraSize []byte
+		if size >= 29 {
+			extraSize = []byte{byte(size - 29)}
+			size = 29
+		}
+		result := []byte{byte(dataType<<5 | size)}
+		if dataType > 7 {
+			result = []byte{byte(size), byte(dataType - 7)}
+		}
+		return append(result, extraSize...)
+	}
+	encodeUint := func(dataType int, v uint64) []byte {
+		raw := binary.BigEndian.AppendUint64(nil, v)
+		for len(raw) > 0 && raw[0] == 0 {
+			raw = raw[1:]
+		}
+		return append(controlByte(dataType, len(raw)), raw...)
+	}
+
+	switch v := value.(type) {
+	case string:
+		return append(controlByte(2, len(v)), v...)
+	case uint16:
+		return encodeUint(5, uint64(v))
+	case uint32:
+		return encodeUint(6, uint64(v))
+	case uint64:
+		return encodeUint(9, v)
+	case testMmdbMap:
+		result := controlByte(7, len(v))
+		for _, pair := range v {
+			result = append(result, encodeMmdbValue(pair[0])...)
+			result = append(result, encodeMmdbValue(pair[1])...)
+		}
+		return result
+	case []testMmdbValue:
+		result := controlByte(11, len(v))
+		for _, item := range v {
+			result = append(result, encodeMmdbValue(item)...)
+		}
+		return result
+	}
+	panic("unsupported mmdb value")
+}
+
+func writeTestMmdb(t *testing.T, databaseType string, networks map[string]testMmdbMap) string {
+	const emptyRecord = -1
+	nodes := [][2]int{{emptyRecord, emptyRecord}}
+	var data []byte
+	type leaf struct{ node, side, offset int }
+	var leaves []leaf
+
+	for rawPrefix, record := range networks {
+		prefix := netip.MustParsePrefix(rawPrefix)
+		ip := binary.BigEndian.Uint32(prefix.Addr().AsSlice())
+		node := 0
+		for bit := 0; bit < prefix.Bits(); bit++ {
+			side := int(ip>>(31-bit)) & 1
+			if bit == prefix.Bits()-1 {
+				leaves = append(leaves, leaf{node, side, len(data)})
+				break
+			}
+			if nodes[node][side] == emptyRecord {
+				nodes = append(nodes, [2]int{emptyRecord, emptyRecord})
+				nodes[node][side] = len(nodes) - 1
+			}
+			node = nodes[node][side]
+		}
+		data = append(data, encodeMmdbValue(record)...)
+	}
+
+	nodeCount := len(nodes)
+	for _, item := range leaves {
+		nodes[item.node][item.side] = nodeCount + 16 + item.offset
+	}
+	var buffer []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			if record == emptyRecord {
+				record = nodeCount
+			}
+			buffer = append(buffer, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	buffer = append(buffer, make([]byte, 16)...)
+	buffer = append(buffer, data...)
+	buffer = append(buffer, "\xAB\xCD\xEFMaxMind.com"...)
+	buffer = append(buffer, encodeMmdbValue(testMmdbMap{
+		{"binary_format_major_version", uint16(2)},
+		{"binary_format_minor_version", uint16(0)},
+		{"build_epoch", uint64(1767225600)},
+		{"database_type", databaseType},
+		{"description", testMmdbMap{}},
+		{"ip_version", uint16(4)},
+		{"languages", []testMmdbValue{}},
+		{"node_count", uint32(nodeCount)},
+		{"record_size", uint16(24)},
+	})...)
+
+	path := filepath.Join(t.TempDir(), databaseType+".mmdb")
+	assert.NoError(t, os.WriteFile(path, buffer, 0o644))
+	return path
+}
+
+func TestLookupGeoIp(t *testing.T) {
+	countryFile := writeTestMmdb(t, "GeoLite2-Country", map[string]testMmdbMap{
+		"1.1.1.0/24": {{"registered_country", testMmdbMap{{"iso_code", "AU"}}}},
+		"8.8.8.0/24": {
+			{"country", testMmdbMap{{"iso_code", "US"}}},
+			{"registered_country", testMmdbMap{{"iso_code", "US"}}},
+		},
+	})
+	asnFile := writeTestMmdb(t, "GeoLite2-ASN", map[string]testMmdbMap{
+		"8.8.8.0/24": {
+			{"autonomous_system_number", uint32(15169)},
+			{"autonomous_system_organization", "GOOGLE"},
+		},
+	})
+
+	g, err := NewGeoIpService(countryFile, asnFile)
+	assert.NoError(t, err)
+	defer g.Close()
+
+	info, ok := g.LookupGeoIp("8.8.8.8")
+	assert.True(t, ok)
+	assert.Equal(t, model.GeoIpInfo{CountryCode: "US", Asn: 15169, AsOrganization: "GOOGLE"}, info)
+
+	// 没有实际所在国家时使用注册国家
+	info, ok = g.LookupGeoIp("::ffff:1.1.1.1")
+	assert.True(t, ok)
+	assert.Equal(t, model.GeoIpInfo{CountryCode: "AU"}, info)
+
+	for _, ip := range []string{"9.9.9.9", "192.168.1.1", "100.64.0.1", "fe80::1", "2001:db8::1", "not an ip"} {
+		_, ok = g.LookupGeoIp(ip)
+		assert.False(t, ok, ip)
+	}
+}
+
+func TestNewGeoIpService(t *testing.T) {
+	_, err := NewGeoIpService("", "")
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	_, err = NewGeoIpService(filepath.Join(t.TempDir(), "missing.mmdb"), "")
+	assert.Error(t, err)
+}
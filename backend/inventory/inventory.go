@@ -0,0 +1,447 @@
+// Package inventory 记录局域网里出现过的所有设备 , 即使它们的流量统计已经被回收
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/oui"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	InventorySyncInterval    = 30 * time.Second
+	InventorySaveInterval    = 10 * time.Minute // 路由器的 /etc 在 flash 上 , 不要写得太频繁
+	InventoryFlushInterval   = 6 * time.Hour    // 只有最后活跃时间变化时 , 隔很久才写一次
+	InventoryMaxDevices      = 4096
+	InventoryMaxIpsPerDevice = 16
+	InventoryWebhookTimeout  = 5 * time.Second
+	DefaultDhcpLeaseFile     = "/tmp/dhcp.leases"
+)
+
+// NeighborSourceInterface 提供内核邻居表
+type NeighborSourceInterface interface {
+	Neighbors() []model.NeighborEntry
+}
+
+type InventoryService struct {
+	fs             afero.Fs
+	file           string // 为空时只保存在内存
+	leaseFile      string
+	webhookUrl     string
+	routerName     string
+	neighborSource NeighborSourceInterface
+	client         *http.Client
+	mutex          sync.RWMutex
+	devices        map[string]*model.InventoryDevice
+	leaseExpiry    map[string]int64
+	dirty          bool // 新设备 , 主机名 , 来源或 ip 有变化
+	touched        bool // 只有活跃时间有变化 , 丢了也不要紧 , 不值得频繁写 flash
+	// 没有历史清单时 , 第一次同步到的设备都是 "已有设备" , 不通知
+	learning bool
+}
+
+func NewInventoryService(
+	fs afero.Fs,
+	file string,
+	leaseFile string,
+	webhookUrl string,
+	neighborSource NeighborSourceInterface,
+) *InventoryService {
+	routerName, _ := os.Hostname()
+	s := &InventoryService{
+		fs:             fs,
+		file:           file,
+		leaseFile:      leaseFile,
+		webhookUrl:     webhookUrl,
+		routerName:     routerName,
+		neighborSource: neighborSource,
+		client:         &http.Client{Timeout: InventoryWebhookTimeout},
+		devices:        make(map[string]*model.InventoryDevice),
+		leaseExpiry:    make(map[string]int64),
+	}
+	if err := s.Load(); err != nil {
+		log.Printf("Load device inventory failed: %s", err)
+	}
+	return s
+}
+
+// Load 读取持久化的设备清单 , 文件不存在时进入学习模式
+func (s *InventoryService) Load() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == "" {
+		s.learning = true
+		return nil
+	}
+	data, err := afero.ReadFile(s.fs, s.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.learning = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var devices []model.InventoryDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		// 文件损坏时不能进入学习模式 , 否则之后的新设备都不会通知
+		return fmt.Errorf("Decode %q failed: %w", s.file, err)
+	}
+	for index := range devices {
+		device := devices[index]
+		s.devices[device.Mac] = &device
+	}
+	log.Printf("Load %d devices from %s", len(devices), s.file)
+	return nil
+}
+
+// Save 只在清单有结构变化时写入 , 先写临时文件再改名 , 防止断电时文件损坏
+func (s *InventoryService) Save() error {
+	return s.save(false)
+}
+
+// Flush 连同只更新了活跃时间的设备一起写入
+func (s *InventoryService) Flush() error {
+	return s.save(true)
+}
+
+func (s *InventoryService) save(flush bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == "" || !(s.dirty || flush && s.touched) {
+		return nil
+	}
+
+	devices := make([]model.InventoryDevice, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, *device)
+	}
+	slices.SortFunc(devices, func(a, b model.InventoryDevice) int {
+		return strings.Compare(a.Mac, b.Mac)
+	})
+	data, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll(filepath.Dir(s.file), 0o755); err != nil {
+		return err
+	}
+	tmpFile := s.file + ".tmp"
+	if err := afero.WriteFile(s.fs, tmpFile, data, 0o644); err != nil {
+		return err
+	}
+	if err := s.fs.Rename(tmpFile, s.file); err != nil {
+		return err
+	}
+	s.dirty = false
+	s.touched = false
+	return nil
+}
+
+func (s *InventoryService) Run(ctx context.Context) {
+	syncTicker := time.NewTicker(InventorySyncInterval)
+	defer syncTicker.Stop()
+	saveTicker := time.NewTicker(InventorySaveInterval)
+	defer saveTicker.Stop()
+	flushTicker := time.NewTicker(InventoryFlushInterval)
+	defer flushTicker.Stop()
+
+	s.Sync(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncTicker.C:
+			s.Sync(time.Now())
+		case <-saveTicker.C:
+			if err := s.Save(); err != nil {
+				log.Printf("Save device inventory failed: %s", err)
+			}
+		case <-flushTicker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("Save device inventory failed: %s", err)
+			}
+		}
+	}
+}
+
+// Close 在退出前保存清单 , 包括只更新了活跃时间的设备
+func (s *InventoryService) Close() {
+	if err := s.Flush(); err != nil {
+		log.Printf("Save device inventory failed: %s", err)
+	}
+}
+
+// Sync 从邻居表和 dhcp 租约同步一次
+func (s *InventoryService) Sync(now time.Time) {
+	if s.neighborSource != nil {
+		for _, neighbor := range s.neighborSource.Neighbors() {
+			if !isActiveNeighbor(neighbor) {
+				continue
+			}
+			s.observe(neighbor.Mac, neighbor.Ip, "", model.DeviceSourceNeighbor, neighbor.LastSeen)
+		}
+	}
+	if s.leaseFile != "" {
+		if err := s.syncDhcpLeases(now); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Read dhcp leases failed: %s", err)
+		}
+	}
+
+	s.mutex.Lock()
+	s.learning = false
+	s.mutex.Unlock()
+}
+
+func isActiveNeighbor(neighbor model.NeighborEntry) bool {
+	switch neighbor.State {
+	case model.NeighborStateFailed, model.NeighborStateIncomplete, model.NeighborStateNone:
+		return false
+	}
+	return neighbor.Mac != "" && !neighbor.LastSeen.IsZero()
+}
+
+// dnsmasq 租约格式 : <到期时间> <mac> <ip> <主机名或*> <client id>
+func (s *InventoryService) syncDhcpLeases(now time.Time) error {
+	file, err := s.fs.Open(s.leaseFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		mac := normalizeMac(fields[1])
+		if mac == "" {
+			continue
+		}
+		hostname := fields[3]
+		if hostname == "*" {
+			hostname = ""
+		}
+
+		// 租约在设备离开后还会保留很久 , 只有续租 (到期时间变化) 才说明设备还在
+		s.mutex.Lock()
+		previous, known := s.leaseExpiry[mac]
+		s.leaseExpiry[mac] = expiry
+		_, exist := s.devices[mac]
+		s.mutex.Unlock()
+
+		seenAt := time.Time{}
+		if !exist || (known && previous != expiry) {
+			seenAt = now
+		}
+		s.observe(mac, fields[2], hostname, model.DeviceSourceDhcp, seenAt)
+	}
+	return scanner.Err()
+}
+
+// ObserveTraffic 记录 ebpf 统计到的有流量的局域网设备
+func (s *InventoryService) ObserveTraffic(ip string, mac string) {
+	s.observe(mac, ip, "", model.DeviceSourceEbpf, time.Now())
+}
+
+// seenAt 为零值时只更新主机名等信息 , 不刷新最后活跃时间
+func (s *InventoryService) observe(rawMac string, rawIp string, hostname string, source model.DeviceSourceType, seenAt time.Time) {
+	mac := normalizeMac(rawMac)
+	if mac == "" {
+		return
+	}
+	ip := ""
+	if addr, err := netip.ParseAddr(rawIp); err == nil {
+		ip = addr.Unmap().String()
+	}
+
+	s.mutex.Lock()
+	device, exist := s.devices[mac]
+	if !exist {
+		if seenAt.IsZero() {
+			s.mutex.Unlock()
+			return
+		}
+		if len(s.devices) >= InventoryMaxDevices {
+			s.evictOldestLocked()
+		}
+		device = &model.InventoryDevice{
+			Mac:       mac,
+			FirstSeen: seenAt,
+		}
+		s.devices[mac] = device
+	}
+
+	if hostname != "" && device.Hostname != hostname {
+		device.Hostname = hostname
+		s.dirty = true
+	}
+	if !slices.Contains(device.Sources, source) {
+		device.Sources = append(device.Sources, source)
+		slices.Sort(device.Sources)
+		s.dirty = true
+	}
+	if !seenAt.IsZero() {
+		if seenAt.After(device.LastSeen) {
+			device.LastSeen = seenAt
+			s.touched = true
+		}
+		if seenAt.Before(device.FirstSeen) {
+			device.FirstSeen = seenAt
+			s.touched = true
+		}
+		if ip != "" {
+			if updateDeviceIp(device, ip, seenAt) {
+				s.dirty = true
+			} else {
+				s.touched = true
+			}
+		}
+	}
+
+	notify := !exist && !s.learning && s.webhookUrl != ""
+	var snapshot model.InventoryDevice
+	if notify {
+		snapshot = cloneDevice(device)
+	}
+	s.mutex.Unlock()
+
+	if !exist {
+		log.Printf("New device %s (%s) found by %s", mac, ip, source)
+	}
+	if notify {
+		go s.notifyNewDevice(snapshot)
+	}
+}
+
+// 返回是否新增了 ip , 只刷新活跃时间时返回 false
+func updateDeviceIp(device *model.InventoryDevice, ip string, seenAt time.Time) bool {
+	for index := range device.Ips {
+		if device.Ips[index].Ip == ip {
+			if seenAt.After(device.Ips[index].LastSeen) {
+				device.Ips[index].LastSeen = seenAt
+			}
+			return false
+		}
+	}
+	if len(device.Ips) >= InventoryMaxIpsPerDevice {
+		oldest := 0
+		for index := range device.Ips {
+			if device.Ips[index].LastSeen.Before(device.Ips[oldest].LastSeen) {
+				oldest = index
+			}
+		}
+		device.Ips = slices.Delete(device.Ips, oldest, oldest+1)
+	}
+	device.Ips = append(device.Ips, model.InventoryDeviceIp{
+		Ip:        ip,
+		FirstSeen: seenAt,
+		LastSeen:  seenAt,
+	})
+	return true
+}
+
+// 调用前必须持有 s.mutex
+func (s *InventoryService) evictOldestLocked() {
+	var oldest *model.InventoryDevice
+	for _, device := range s.devices {
+		if oldest == nil || device.LastSeen.Before(oldest.LastSeen) {
+			oldest = device
+		}
+	}
+	if oldest != nil {
+		delete(s.devices, oldest.Mac)
+		delete(s.leaseExpiry, oldest.Mac)
+	}
+}
+
+// Devices 返回 since 之后第一次出现的设备 , since 为零值时返回全部 , 按最后活跃时间倒序
+func (s *InventoryService) Devices(since time.Time) []model.InventoryDevice {
+	s.mutex.RLock()
+	result := make([]model.InventoryDevice, 0, len(s.devices))
+	for _, device := range s.devices {
+		if !since.IsZero() && device.FirstSeen.Before(since) {
+			continue
+		}
+		result = append(result, cloneDevice(device))
+	}
+	s.mutex.RUnlock()
+
+	slices.SortFunc(result, func(a, b model.InventoryDevice) int {
+		if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Mac, b.Mac)
+	})
+	return result
+}
+
+// 厂商在输出时再查 , 这样更新 oui 表之后旧设备也能识别
+func cloneDevice(device *model.InventoryDevice) model.InventoryDevice {
+	result := *device
+	result.Ips = slices.Clone(device.Ips)
+	slices.SortFunc(result.Ips, func(a, b model.InventoryDeviceIp) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	result.Sources = slices.Clone(device.Sources)
+	result.Vendor, result.RandomizedMac = oui.Lookup(device.Mac)
+	return result
+}
+
+func (s *InventoryService) notifyNewDevice(device model.InventoryDevice) {
+	payload, err := json.Marshal(model.NewDeviceWebhookPayload{
+		Event:  model.NewDeviceWebhookEvent,
+		Router: s.routerName,
+		Device: device,
+	})
+	if err != nil {
+		log.Printf("New device webhook json marshal error : %s", err)
+		return
+	}
+	response, err := s.client.Post(s.webhookUrl, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("New device webhook request failed: %s", err)
+		return
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		log.Printf("New device webhook got unexpected status: %s", response.Status)
+	}
+}
+
+func normalizeMac(raw string) string {
+	mac, err := net.ParseMAC(raw)
+	if err != nil || len(mac) != 6 {
+		return ""
+	}
+	// 全零 mac 一般是隧道或者 ppp 接口
+	if bytes.Equal(mac, make(net.HardwareAddr, 6)) {
+		return ""
+	}
+	return mac.String()
+}
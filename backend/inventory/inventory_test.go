@@ -0,0 +1,140 @@
+package inventory
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNeighborSource []model.NeighborEntry
+
+func (f fakeNeighborSource) Neighbors() []model.NeighborEntry {
+	return f
+}
+
+const testLeaseFile = "/tmp/dhcp.leases"
+
+func TestInventorySync(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	_ = afero.WriteFile(fs, testLeaseFile, []byte(
+		"1767272400 b8:27:eb:00:00:01 192.168.1.10 raspberrypi 01:b8:27:eb:00:00:01\n"+
+			"1767272400 aa:bb:cc:00:00:02 192.168.1.11 * *\n",
+	), 0o644)
+	neighbors := fakeNeighborSource{
+		{Ip: "192.168.1.10", Mac: "b8:27:eb:00:00:01", State: model.NeighborStateReachable, LastSeen: now.Add(-time.Minute)},
+		{Ip: "fd00::10", Mac: "B8:27:EB:00:00:01", State: model.NeighborStateStale, LastSeen: now.Add(-time.Hour)},
+		{Ip: "192.168.1.12", Mac: "b8:27:eb:00:00:03", State: model.NeighborStateFailed},
+	}
+
+	s := NewInventoryService(fs, "", testLeaseFile, "", neighbors)
+	s.Sync(now)
+
+	devices := s.Devices(time.Time{})
+	assert.Len(t, devices, 2)
+	assert.Equal(t, "aa:bb:cc:00:00:02", devices[0].Mac)
+	assert.Equal(t, []model.DeviceSourceType{model.DeviceSourceDhcp}, devices[0].Sources)
+	assert.True(t, devices[0].RandomizedMac)
+
+	pi := devices[1]
+	assert.Equal(t, "b8:27:eb:00:00:01", pi.Mac)
+	assert.Equal(t, "raspberrypi", pi.Hostname)
+	assert.Equal(t, "Raspberry Pi Foundation", pi.Vendor)
+	assert.Equal(t, now.Add(-time.Hour), pi.FirstSeen)
+	assert.Equal(t, now.Add(-time.Minute), pi.LastSeen)
+	assert.Equal(t, []model.DeviceSourceType{model.DeviceSourceDhcp, model.DeviceSourceNeighbor}, pi.Sources)
+	assert.Equal(t, "192.168.1.10", pi.Ips[0].Ip)
+	assert.Equal(t, "fd00::10", pi.Ips[1].Ip)
+
+	assert.Len(t, s.Devices(now.Add(-30*time.Minute)), 1)
+}
+
+func TestInventoryDhcpLeaseRenewal(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	_ = afero.WriteFile(fs, testLeaseFile, []byte("1767272400 b8:27:eb:00:00:01 192.168.1.10 pi *\n"), 0o644)
+	s := NewInventoryService(fs, "", testLeaseFile, "", nil)
+	s.Sync(now)
+
+	// 租约没变化 , 设备可能已经离开 , 不刷新最后活跃时间
+	s.Sync(now.Add(time.Hour))
+	assert.Equal(t, now, s.Devices(time.Time{})[0].LastSeen)
+
+	_ = afero.WriteFile(fs, testLeaseFile, []byte("1767276000 b8:27:eb:00:00:01 192.168.1.10 pi *\n"), 0o644)
+	s.Sync(now.Add(2 * time.Hour))
+	assert.Equal(t, now.Add(2*time.Hour), s.Devices(time.Time{})[0].LastSeen)
+}
+
+func TestInventoryPersistAndWebhook(t *testing.T) {
+	received := make(chan model.NewDeviceWebhookPayload, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload model.NewDeviceWebhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	file := "/etc/diskio-api/devices.json"
+	now := time.Now()
+	neighbors := fakeNeighborSource{
+		{Ip: "192.168.1.10", Mac: "b8:27:eb:00:00:01", State: model.NeighborStateReachable, LastSeen: now},
+	}
+
+	// 第一次启动没有历史清单 , 已有设备不通知
+	s := NewInventoryService(fs, file, "", server.URL, neighbors)
+	s.Sync(now)
+	s.ObserveTraffic("192.168.1.20", "24:0a:c4:00:00:02")
+	payload := <-received
+	assert.Equal(t, model.NewDeviceWebhookEvent, payload.Event)
+	assert.Equal(t, "24:0a:c4:00:00:02", payload.Device.Mac)
+	assert.Equal(t, "Espressif", payload.Device.Vendor)
+
+	s.ObserveTraffic("192.168.1.20", "24:0a:c4:00:00:02")
+	assert.NoError(t, s.Save())
+
+	// 重启后已知设备不会再通知
+	restarted := NewInventoryService(fs, file, "", server.URL, neighbors)
+	restarted.Sync(now)
+	restarted.ObserveTraffic("192.168.1.20", "24:0a:c4:00:00:02")
+	assert.Len(t, restarted.Devices(time.Time{}), 2)
+	select {
+	case payload := <-received:
+		t.Fatalf("unexpected webhook for %s", payload.Device.Mac)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestInventorySaveOnlyStructuralChanges(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	file := "/etc/diskio-api/devices.json"
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewInventoryService(fs, file, "", "", nil)
+	s.observe("b8:27:eb:00:00:01", "192.168.1.10", "", model.DeviceSourceEbpf, now)
+	assert.NoError(t, s.Save())
+	saved, _ := afero.ReadFile(fs, file)
+
+	// 只刷新活跃时间 , Save 不写 flash
+	s.observe("b8:27:eb:00:00:01", "192.168.1.10", "", model.DeviceSourceEbpf, now.Add(time.Minute))
+	assert.NoError(t, s.Save())
+	data, _ := afero.ReadFile(fs, file)
+	assert.Equal(t, saved, data)
+
+	// Flush 会把活跃时间一起写入
+	assert.NoError(t, s.Flush())
+	restarted := NewInventoryService(fs, file, "", "", nil)
+	assert.Equal(t, now.Add(time.Minute), restarted.Devices(time.Time{})[0].LastSeen)
+
+	// 新的 ip 是结构变化
+	s.observe("b8:27:eb:00:00:01", "192.168.1.11", "", model.DeviceSourceEbpf, now.Add(2*time.Minute))
+	assert.NoError(t, s.Save())
+	restarted = NewInventoryService(fs, file, "", "", nil)
+	assert.Len(t, restarted.Devices(time.Time{})[0].Ips, 2)
+}
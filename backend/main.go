@@ -12,18 +12,26 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	frontend "openwrt-diskio-api"
+	"openwrt-diskio-api/backend/alert"
+	"openwrt-diskio-api/backend/api"
 	"openwrt-diskio-api/backend/dns"
+	"openwrt-diskio-api/backend/geoip"
+	"openwrt-diskio-api/backend/inventory"
 	"openwrt-diskio-api/backend/metric"
 	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/openapi"
+	"openwrt-diskio-api/backend/oui"
 
 	"github.com/spf13/afero"
 )
@@ -38,153 +46,296 @@ var (
 		Runner:          runner,
 		UpdateEventChan: make(chan string, workerNumber),
 	}
-	dnsQueryService *dns.DnsQueryService
+	dnsQueryService   *dns.DnsQueryService
+	passiveDnsService *dns.PassiveDnsService
+	neighborService   *dns.NeighborService
+	inventoryService  *inventory.InventoryService
+	alertEngine       *alert.Engine
+	startedAt         = time.Now().UTC()
 )
 
 func setJsonHeader(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 }
-func setGzipHeader(w http.ResponseWriter) {
-	w.Header().Set("Content-Encoding", "gzip")
-}
 
-func DynamicMetricHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "only GET", http.StatusMethodNotAllowed)
-		return
-	}
+// legacyCachedMetricHandler 旧的 /metric/* 接口 , 缓存还没有时返回空结构体
+func legacyCachedMetricHandler(key string, empty any, activeSignal func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET", http.StatusMethodNotAllowed)
+			return
+		}
 
-	setJsonHeader(w)
+		if activeSignal != nil {
+			defer activeSignal()
+		}
 
-	jsonBytes, isGzip := background.GetJsonBytes(model.JsonCacheKeyDynamicMetric)
-	if len(jsonBytes) == 0 {
-		var err error
-		jsonBytes, err = json.Marshal(&model.DynamicMetric{})
-		if err != nil {
-			errMsg := fmt.Sprintf("json marshal error : %s", err.Error())
-			http.Error(w, errMsg, http.StatusInternalServerError)
+		cache, ok := background.GetJsonCache(key)
+		if ok && api.WriteCacheHeaders(w, r, api.ETag(cache.UpdateAt, ""), cache.UpdateAt, cache.ExpireAt) {
+			api.WriteNotModified(w)
 			return
 		}
+		jsonBytes := cache.Data
+		if len(jsonBytes) == 0 {
+			var err error
+			jsonBytes, err = json.Marshal(empty)
+			if err != nil {
+				errMsg := fmt.Sprintf("json marshal error : %s", err.Error())
+				http.Error(w, errMsg, http.StatusInternalServerError)
+				return
+			}
+		}
+		api.WriteJson(w, r, http.StatusOK, jsonBytes, cache.Gzip)
 	}
-	if isGzip {
-		setGzipHeader(w)
+}
+
+// cachedMetric 读取后台生成的缓存 , 没有缓存时区分 "还没采集过" 和 "采集失败"
+func cachedMetric(key string, activeSignal func()) api.HandlerFunc {
+	return func(r *http.Request) (api.Result, *api.Error) {
+		if activeSignal != nil {
+			defer activeSignal()
+		}
+		cache, ok := background.GetJsonCache(key)
+		failure, failed := background.CollectorFailure(key)
+		var collectorErr *api.Error
+		if failed {
+			collectorErr = api.NewError(
+				http.StatusServiceUnavailable,
+				model.ApiErrorCollectorFailed,
+				fmt.Sprintf("%s : %s", failure.Name, failure.LastError),
+			)
+		}
+		if !ok {
+			if failed {
+				return api.Result{}, collectorErr
+			}
+			return api.Result{}, api.NewError(http.StatusServiceUnavailable, model.ApiErrorNoData, "no data yet , try again later")
+		}
+
+		return api.Result{
+			Raw:         cache.Data,
+			RawGzip:     cache.Gzip,
+			GeneratedAt: cache.UpdateAt,
+			ExpireAt:    cache.ExpireAt,
+			Warning:     collectorErr,
+		}, nil
 	}
-	_, _ = w.Write(jsonBytes)
-	background.DynamicMetricServiceActiveSignal()
 }
 
-func NetworkConnectionMetricHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "only GET", http.StatusMethodNotAllowed)
-		return
+// 支持 name=a&name=b 和 name=a,b 两种写法
+func queryList(r *http.Request, name string) []string {
+	var values []string
+	for _, item := range r.URL.Query()[name] {
+		for _, value := range strings.Split(item, ",") {
+			if trimmed := strings.TrimSpace(value); trimmed != "" {
+				values = append(values, trimmed)
+			}
+		}
 	}
+	return values
+}
 
-	setJsonHeader(w)
+func queryIps(r *http.Request) []string {
+	return queryList(r, "ip")
+}
 
-	jsonBytes, isGzip := background.GetJsonBytes(model.JsonCacheKeyNetworkConnectionMetric)
-	if len(jsonBytes) == 0 {
-		var err error
-		jsonBytes, err = json.Marshal(&model.NetworkConnectionMetric{})
-		if err != nil {
-			errMsg := fmt.Sprintf("json marshal error : %s", err.Error())
-			http.Error(w, errMsg, http.StatusInternalServerError)
-			return
-		}
+func dnsQuery(r *http.Request) (api.Result, *api.Error) {
+	if _, ok := r.URL.Query()["ip"]; !ok {
+		return api.Result{}, api.BadRequest("missing \"ip\" query parameter")
 	}
-	if isGzip {
-		setGzipHeader(w)
+	ips := queryIps(r)
+	if len(ips) == 0 {
+		return api.Result{}, api.BadRequest("\"ip\" parameter is empty")
 	}
-	_, _ = w.Write(jsonBytes)
+
+	results, err := dnsQueryService.LookupAddr(ips)
+	if err != nil {
+		return api.Result{}, api.NewError(http.StatusInternalServerError, model.ApiErrorUpstreamFailed, err.Error())
+	}
+	return api.Result{Data: results}, nil
 }
 
-func StaticMetricHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "only GET", http.StatusMethodNotAllowed)
-		return
+func dnsUpstreamStatus(_ *http.Request) (api.Result, *api.Error) {
+	return api.Result{Data: dnsQueryService.UpstreamStatus()}, nil
+}
+
+func passiveDns(r *http.Request) (api.Result, *api.Error) {
+	if passiveDnsService == nil {
+		return api.Result{}, api.Disabled("passive dns is disabled , enable it with --passive-dns")
 	}
-	setJsonHeader(w)
+	return api.Result{Data: passiveDnsService.Records(queryIps(r))}, nil
+}
 
-	jsonBytes, isGzip := background.GetJsonBytes(model.JsonCacheKeyStaticMetric)
-	if len(jsonBytes) == 0 {
-		var err error
-		jsonBytes, err = json.Marshal(&model.StaticMetric{})
-		if err != nil {
-			errMsg := fmt.Sprintf("json marshal error : %s", err.Error())
-			http.Error(w, errMsg, http.StatusInternalServerError)
-			return
+func neighbors(_ *http.Request) (api.Result, *api.Error) {
+	return api.Result{Data: neighborService.Neighbors()}, nil
+}
+
+// since 支持 RFC3339 时间或者 "24h" 这样的时长 (表示多久以前)
+func deviceInventory(r *http.Request) (api.Result, *api.Error) {
+	var since time.Time
+	if rawSince := strings.TrimSpace(r.URL.Query().Get("since")); rawSince != "" {
+		if duration, err := time.ParseDuration(rawSince); err == nil {
+			since = time.Now().Add(-duration)
+		} else if since, err = time.Parse(time.RFC3339, rawSince); err != nil {
+			return api.Result{}, api.BadRequest("\"since\" must be RFC3339 time or duration like 24h")
 		}
 	}
-	if isGzip {
-		setGzipHeader(w)
-	}
-	_, _ = w.Write(jsonBytes)
+	return api.Result{Data: inventoryService.Devices(since)}, nil
 }
-func AggregationTrafficHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "only GET", http.StatusMethodNotAllowed)
-		return
-	}
-	setJsonHeader(w)
 
-	jsonBytes, isGzip := background.GetJsonBytes(model.JsonCacheKeyAggregationTraffic)
-	if len(jsonBytes) == 0 {
-		var err error
-		jsonBytes, err = json.Marshal(&model.AggregationTrafficMetric{})
-		if err != nil {
-			errMsg := fmt.Sprintf("json marshal error : %s", err.Error())
-			http.Error(w, errMsg, http.StatusInternalServerError)
-			return
-		}
+func alerts(_ *http.Request) (api.Result, *api.Error) {
+	if alertEngine == nil {
+		return api.Result{}, api.Disabled("alert is disabled , enable it with --alert-rules-file")
 	}
-	if isGzip {
-		setGzipHeader(w)
+	return api.Result{Data: alertEngine.Alerts()}, nil
+}
+
+func queryGroupHandler(group model.QueryGroup) (api.HandlerFunc, bool) {
+	switch group {
+	case model.QueryGroupStatic:
+		return cachedMetric(model.JsonCacheKeyStaticMetric, nil), true
+	case model.QueryGroupDynamic:
+		return cachedMetric(model.JsonCacheKeyDynamicMetric, background.DynamicMetricServiceActiveSignal), true
+	case model.QueryGroupConnections:
+		return cachedMetric(model.JsonCacheKeyNetworkConnectionMetric, nil), true
+	case model.QueryGroupAggregation:
+		return cachedMetric(model.JsonCacheKeyAggregationTraffic, background.AggregationTrafficServiceActiveSignal), true
+	case model.QueryGroupDns:
+		return dnsQuery, true
 	}
-	_, _ = w.Write(jsonBytes)
-	background.AggregationTrafficServiceActiveSignal()
+	return nil, false
 }
-func DnsQueryHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "only GET", http.StatusMethodNotAllowed)
-		return
+
+func setQueryGroup(result *model.QueryResult, group model.QueryGroup, data json.RawMessage) {
+	switch group {
+	case model.QueryGroupStatic:
+		result.Static = data
+	case model.QueryGroupDynamic:
+		result.Dynamic = data
+	case model.QueryGroupConnections:
+		result.Connections = data
+	case model.QueryGroupAggregation:
+		result.Aggregation = data
+	case model.QueryGroupDns:
+		result.Dns = data
 	}
+}
 
-	query := r.URL.Query()
-	ipParams, ok := query["ip"]
-	if !ok {
-		http.Error(w, "missing \"ip\" query parameter", http.StatusBadRequest)
-		return
+// query 一次返回多个分组 , include 选择整个分组 , fields 只选择分组里的字段 ,
+// 例如 fields=dynamic.cpu.total,dynamic.network.pppoe-wan , 某个分组没有数据不影响其它分组
+func query(r *http.Request) (api.Result, *api.Error) {
+	include := queryList(r, "include")
+	fields := queryList(r, "fields")
+	if len(include) == 0 && len(fields) == 0 {
+		return api.Result{}, api.BadRequest("missing \"include\" or \"fields\" query parameter")
 	}
 
-	var ips []string
-	for _, item := range ipParams {
-		if strings.Contains(item, ",") {
-			ips = append(ips, strings.Split(item, ",")...)
+	wholeGroups := make(map[model.QueryGroup]bool)
+	groupFields := make(map[model.QueryGroup][]string)
+	for _, name := range include {
+		wholeGroups[model.QueryGroup(name)] = true
+	}
+	for _, field := range fields {
+		name, path, _ := strings.Cut(field, ".")
+		if path == "" {
+			wholeGroups[model.QueryGroup(name)] = true
 		} else {
-			ips = append(ips, item)
+			groupFields[model.QueryGroup(name)] = append(groupFields[model.QueryGroup(name)], path)
 		}
 	}
+	// 先检查所有分组 , 不合法的请求不触发任何采集
+	handlers := make(map[model.QueryGroup]api.HandlerFunc)
+	for _, group := range slices.Concat(slices.Collect(maps.Keys(wholeGroups)), slices.Collect(maps.Keys(groupFields))) {
+		handler, ok := queryGroupHandler(group)
+		if !ok {
+			return api.Result{}, api.BadRequest(fmt.Sprintf("unknown group %q", group))
+		}
+		handlers[group] = handler
+	}
 
-	finalIps := make([]string, 0, len(ips))
-	for _, ip := range ips {
-		trimmed := strings.TrimSpace(ip)
-		if trimmed != "" {
-			finalIps = append(finalIps, trimmed)
+	result := model.QueryResult{
+		UpdateAt: make(map[model.QueryGroup]time.Time),
+		Errors:   make(map[model.QueryGroup]string),
+	}
+	for group, handler := range handlers {
+		groupResult, apiErr := handler(r)
+		if apiErr != nil {
+			if apiErr.Status == http.StatusBadRequest {
+				return api.Result{}, apiErr
+			}
+			result.Errors[group] = apiErr.Error()
+			continue
+		}
+		if groupResult.Warning != nil {
+			result.Errors[group] = groupResult.Warning.Error()
+		}
+		if !groupResult.GeneratedAt.IsZero() {
+			result.UpdateAt[group] = groupResult.GeneratedAt
+		}
+
+		raw := groupResult.Raw
+		if len(raw) == 0 {
+			var err error
+			if raw, err = json.Marshal(groupResult.Data); err != nil {
+				return api.Result{}, api.NewError(http.StatusInternalServerError, model.ApiErrorInternal, "json marshal error : "+err.Error())
+			}
+		}
+		paths := groupFields[group]
+		if wholeGroups[group] {
+			paths = nil
+		}
+		selected, missing, err := api.SelectFields(raw, paths)
+		if err != nil {
+			return api.Result{}, api.NewError(http.StatusInternalServerError, model.ApiErrorInternal, "select fields error : "+err.Error())
 		}
+		for _, path := range missing {
+			result.Missing = append(result.Missing, string(group)+"."+path)
+		}
+		setQueryGroup(&result, group, selected)
 	}
+	slices.Sort(result.Missing)
+	return api.Result{Data: result}, nil
+}
 
-	if len(finalIps) == 0 {
-		http.Error(w, "\"ip\" parameter is empty", http.StatusBadRequest)
-		return
+func buildServiceStatus() model.ServiceStatus {
+	status := model.ServiceStatus{
+		GeneratedAt: time.Now().UTC(),
+		StartedAt:   startedAt,
+		Ebpf:        background.EbpfStatus(),
+		JsonCache:   background.JsonCacheStatus(),
+		Neighbor:    neighborService.Status(),
+		Dns:         dnsQueryService.Status(),
+		Collectors:  background.CollectorStatus(),
 	}
+	status.CheckProblems()
+	return status
+}
 
-	results, err := dnsQueryService.LookupAddr(finalIps)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+func serviceStatus(_ *http.Request) (api.Result, *api.Error) {
+	return api.Result{Data: buildServiceStatus()}, nil
+}
+
+func selfMetric(_ *http.Request) (api.Result, *api.Error) {
+	return api.Result{Data: background.SelfMetric()}, nil
+}
+
+// 不健康时返回 503 , 方便 procd / 外部监控直接判断
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "only GET", http.StatusMethodNotAllowed)
 		return
 	}
 
+	status := buildServiceStatus()
 	setJsonHeader(w)
-	_ = json.NewEncoder(w).Encode(results)
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(model.HealthzResponse{
+		Healthy:  status.Healthy,
+		Starting: status.Starting,
+		Problems: status.Problems,
+	})
 }
 
 func PrettyExit(httpServer *http.Server) {
@@ -202,10 +353,25 @@ func main() {
 		dynamicMetricInterval       = flag.Uint("dynamic-metric-interval", 1, "metric update interval")
 		networkConnectionInterval   = flag.Uint("network-connection-interval", 10, "network connection details update interval")
 		staticMetricInterval        = flag.Uint("static-metric-interval", 60, "metric update interval")
+		processMetricInterval       = flag.Uint("process-metric-interval", 5, "process metric update interval , process cpu usage is computed between two updates")
 		trafficCaptureInterfaceName = flag.String("traffic-capture-interface-name", "br-lan", "traffic capture interface name , only use on realtime traffic capture and should be input LAN interface")
 		trafficKeyExpiredTime       = flag.Duration("traffic-key-expired-time", model.MinServiceRunDuration, "metric update interval")
-		dnsServerIp                 = flag.String("dns-server-ip", "127.0.0.1", "dns server ip , ipv6 support , only support tcp or udp 53 port dns")
+		dnsServerIp                 = flag.String("dns-server-ip", "127.0.0.1", "dns server ip , ipv6 support , only use udp 53 port , ignored when --dns-servers is set")
+		dnsServers                  = flag.String("dns-servers", "", "dns upstream list separated by comma , tried in order with failover , support udp/tcp/tls(DoT) , example : \"udp://127.0.0.1:5353,tcp://[::1]:53,tls://1.1.1.1:853#cloudflare-dns.com\"")
 		dnsQueryTimeout             = flag.Duration("dns-query-timeout", 1*time.Second, "dns query timeout")
+		dnsSnoopingEnable           = flag.Bool("dns-snooping", false, "snoop dns responses on traffic capture interface by ebpf , label traffic with queried domain")
+		sniCaptureEnable            = flag.Bool("sni-capture", false, "sample tls client hello and quic initial packets on traffic capture interface by ebpf , break down traffic by server name")
+		passiveDnsEnable            = flag.Bool("passive-dns", false, "record answer ip to queried domain mapping from dnsmasq query logs , dnsmasq must enable log-queries")
+		passiveDnsLogFile           = flag.String("passive-dns-log-file", "", "dnsmasq log file to follow (dnsmasq log-facility) , use \"logread -f\" when empty")
+		passiveDnsTtl               = flag.Duration("passive-dns-ttl", 1*time.Hour, "how long a passive dns record is kept after last seen")
+		passiveDnsMaxEntries        = flag.Int("passive-dns-max-entries", 65536, "max passive dns records kept in memory")
+		deviceInventoryFile         = flag.String("device-inventory-file", "", "file to persist every device ever seen on lan , keep in memory only when empty")
+		dhcpLeaseFile               = flag.String("dhcp-lease-file", inventory.DefaultDhcpLeaseFile, "dnsmasq dhcp lease file used by device inventory , disable when empty")
+		newDeviceWebhook            = flag.String("new-device-webhook", "", "url to POST json when a never-before-seen mac joins lan")
+		geoIpCountryDb              = flag.String("geoip-country-db", "", "local mmdb country database (GeoLite2-Country/City , DB-IP country) to annotate wan addresses , never downloaded by this program")
+		geoIpAsnDb                  = flag.String("geoip-asn-db", "", "local mmdb asn database (GeoLite2-ASN , DB-IP asn) to annotate wan addresses , never downloaded by this program")
+		alertRulesFile              = flag.String("alert-rules-file", "", "json file of threshold alert rules and webhooks , disable alert when empty")
+		ouiFile                     = flag.String("oui-file", "", "mac vendor table file to replace the embedded one , support ieee oui.csv/oui.txt , wireshark manuf or \"prefix<tab>vendor\" lines , gzip allowed")
 	)
 	flag.Parse()
 
@@ -225,10 +391,25 @@ func main() {
 	log.Printf("dynamicMetricInterval : %v", *dynamicMetricInterval)
 	log.Printf("networkConnectionInterval : %v", *networkConnectionInterval)
 	log.Printf("staticMetricInterval : %v", *staticMetricInterval)
+	log.Printf("processMetricInterval : %v", *processMetricInterval)
 	log.Printf("trafficCaptureInterfaceName : %v", *trafficCaptureInterfaceName)
 	log.Printf("trafficKeyExpiredTime : %v", *trafficKeyExpiredTime)
 	log.Printf("dnsServerIp : %v", *dnsServerIp)
+	log.Printf("dnsServers : %v", *dnsServers)
 	log.Printf("dnsQueryTimeout : %v", *dnsQueryTimeout)
+	log.Printf("dnsSnoopingEnable : %v", *dnsSnoopingEnable)
+	log.Printf("sniCaptureEnable : %v", *sniCaptureEnable)
+	log.Printf("passiveDnsEnable : %v", *passiveDnsEnable)
+	log.Printf("passiveDnsLogFile : %v", *passiveDnsLogFile)
+	log.Printf("passiveDnsTtl : %v", *passiveDnsTtl)
+	log.Printf("passiveDnsMaxEntries : %v", *passiveDnsMaxEntries)
+	log.Printf("deviceInventoryFile : %v", *deviceInventoryFile)
+	log.Printf("dhcpLeaseFile : %v", *dhcpLeaseFile)
+	log.Printf("newDeviceWebhook : %v", *newDeviceWebhook)
+	log.Printf("geoIpCountryDb : %v", *geoIpCountryDb)
+	log.Printf("geoIpAsnDb : %v", *geoIpAsnDb)
+	log.Printf("ouiFile : %v", *ouiFile)
+	log.Printf("alertRulesFile : %v", *alertRulesFile)
 
 	background.SetConfig(
 		*staticMetricInterval,
@@ -237,13 +418,71 @@ func main() {
 		*trafficCaptureInterfaceName,
 		*trafficKeyExpiredTime,
 	)
+	background.SetUpdateProcessMetricInterval(*processMetricInterval)
+	dnsUpstreamList := *dnsServers
+	if strings.TrimSpace(dnsUpstreamList) == "" {
+		dnsUpstreamList = *dnsServerIp
+	}
+	dnsUpstreams, err := dns.ParseDnsUpstreamList(dnsUpstreamList, *dnsQueryTimeout)
+	if err != nil {
+		log.Fatalf("parse dns upstream error : %s", err)
+	}
+	if *ouiFile != "" {
+		table, err := oui.LoadFile(*ouiFile)
+		if err != nil {
+			log.Printf("load oui file error , fallback to embedded table : %s", err)
+		} else {
+			log.Printf("Load %d oui prefixes from %s", table.Len(), *ouiFile)
+		}
+	}
+	neighborService = dns.NewNeighborService()
+	background.MacAnnotator = neighborService
+	inventoryService = inventory.NewInventoryService(
+		afero.NewOsFs(),
+		*deviceInventoryFile,
+		*dhcpLeaseFile,
+		*newDeviceWebhook,
+		neighborService,
+	)
+	background.DeviceObserver = inventoryService
 	dnsQueryService = dns.NewDnsQueryService(
-		*dnsServerIp,
+		dnsUpstreams,
 		*dnsQueryTimeout,
+		neighborService,
 	)
 
+	background.DnsSnoopingEnable = *dnsSnoopingEnable
+	background.SniCaptureEnable = *sniCaptureEnable
+	if *passiveDnsEnable {
+		passiveDnsService = dns.NewPassiveDnsService(
+			*passiveDnsLogFile,
+			*passiveDnsTtl,
+			*passiveDnsMaxEntries,
+		)
+		background.DomainAnnotator = passiveDnsService
+	}
+
+	if *geoIpCountryDb != "" || *geoIpAsnDb != "" {
+		geoIpService, err := geoip.NewGeoIpService(*geoIpCountryDb, *geoIpAsnDb)
+		if err != nil {
+			log.Printf("geoip disabled : %s", err)
+		} else {
+			defer geoIpService.Close()
+			background.GeoIpAnnotator = geoIpService
+		}
+	}
+
+	if *alertRulesFile != "" {
+		alertConfig, err := alert.LoadConfig(afero.NewOsFs(), *alertRulesFile)
+		if err != nil {
+			log.Fatalf("load alert rules error : %s", err)
+		}
+		alertEngine = alert.NewEngine(alertConfig, metric.NewAlertSampleCollector(reader, &background))
+	}
+
 	background.UpdateStaticMetric()
 	background.UpdateNetworkConnectionDetails()
+	background.UpdateProcessMetric()
 
 	canExit := make(chan struct{})
 	ctx, cancel := context.WithCancel(context.Background())
@@ -262,30 +501,86 @@ func main() {
 			log.Printf("HTTP Server releasing failed: %v", err)
 		}
 		background.Close()
+		inventoryService.Close()
 		close(canExit)
 	}()
 
+	go neighborService.Run(ctx)
+	go inventoryService.Run(ctx)
+	if passiveDnsService != nil {
+		go passiveDnsService.Run(ctx)
+	}
 	go background.RunDynamicMetricService(ctx)
 	go background.RunAggregationTrafficService(ctx)
 	for index := range workerNumber {
 		go background.Worker(index)
 	}
+	if alertEngine != nil {
+		go alertEngine.Run(ctx)
+	}
 
 	webFS, _ := fs.Sub(frontend.WebEmb, frontend.FrontendDistPath)
-	http.Handle("/", http.FileServer(http.FS(webFS)))
-
-	http.HandleFunc("/metric/dynamic", DynamicMetricHandler)
-	http.HandleFunc("/metric/network_connection", NetworkConnectionMetricHandler)
-	http.HandleFunc("/metric/static", StaticMetricHandler)
-	http.HandleFunc("/metric/aggregation_traffic", AggregationTrafficHandler)
-	http.HandleFunc("/dns/query", DnsQueryHandler)
-
+	http.Handle("/", api.StaticHandler(webFS))
+
+	// /api/v1 下是统一格式的接口 , 旧路径保留为兼容别名 , 返回格式不变
+	// 增删路由时同步修改 openapi.Endpoints 并 go generate ./openapi
+	routes := []struct {
+		path    string
+		handler api.HandlerFunc
+		legacy  http.HandlerFunc // 为空时用 api.Legacy 包装 handler
+	}{
+		{
+			"/metric/dynamic",
+			cachedMetric(model.JsonCacheKeyDynamicMetric, background.DynamicMetricServiceActiveSignal),
+			legacyCachedMetricHandler(model.JsonCacheKeyDynamicMetric, &model.DynamicMetric{}, background.DynamicMetricServiceActiveSignal),
+		},
+		{
+			"/metric/network_connection",
+			cachedMetric(model.JsonCacheKeyNetworkConnectionMetric, nil),
+			legacyCachedMetricHandler(model.JsonCacheKeyNetworkConnectionMetric, &model.NetworkConnectionMetric{}, nil),
+		},
+		{
+			"/metric/static",
+			cachedMetric(model.JsonCacheKeyStaticMetric, nil),
+			legacyCachedMetricHandler(model.JsonCacheKeyStaticMetric, &model.StaticMetric{}, nil),
+		},
+		{
+			"/metric/aggregation_traffic",
+			cachedMetric(model.JsonCacheKeyAggregationTraffic, background.AggregationTrafficServiceActiveSignal),
+			legacyCachedMetricHandler(model.JsonCacheKeyAggregationTraffic, &model.AggregationTrafficMetric{}, background.AggregationTrafficServiceActiveSignal),
+		},
+		{
+			"/metric/processes",
+			cachedMetric(model.JsonCacheKeyProcessMetric, nil),
+			legacyCachedMetricHandler(model.JsonCacheKeyProcessMetric, &model.ProcessesMetric{}, nil),
+		},
+		{"/metric/self", selfMetric, nil},
+		{"/dns/query", dnsQuery, nil},
+		{"/dns/upstreams", dnsUpstreamStatus, nil},
+		{"/dns/passive", passiveDns, nil},
+		{"/neighbors", neighbors, nil},
+		{"/devices", deviceInventory, nil},
+		{"/alerts", alerts, nil},
+		{"/status", serviceStatus, nil},
+		{"/query", query, nil},
+	}
 	log.Printf("listen http://%s/", addr)
-	log.Printf("Interface url : http://%s/metric/dynamic", addr)
-	log.Printf("Interface url : http://%s/metric/network_connection", addr)
-	log.Printf("Interface url : http://%s/metric/static", addr)
-	log.Printf("Interface url : http://%s/metric/aggregation_traffic", addr)
-	log.Printf("Interface url : http://%s/dns/query", addr)
+	for _, route := range routes {
+		legacy := route.legacy
+		if legacy == nil {
+			legacy = api.Legacy(route.handler)
+		}
+		http.HandleFunc(route.path, legacy)
+		http.HandleFunc(api.V1Prefix+route.path, api.V1(route.handler))
+		log.Printf("Interface url : http://%s%s%s", addr, api.V1Prefix, route.path)
+	}
+	http.HandleFunc(api.V1Prefix+"/", api.V1(func(r *http.Request) (api.Result, *api.Error) {
+		return api.Result{}, api.NewError(http.StatusNotFound, model.ApiErrorNotFound, "unknown api "+r.URL.Path)
+	}))
+	http.HandleFunc("/healthz", HealthzHandler)
+	log.Printf("Interface url : http://%s/healthz", addr)
+	http.HandleFunc("/openapi.json", openapi.Handler)
+	log.Printf("Interface url : http://%s/openapi.json", addr)
 	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("listen error: %s\n", err)
 	}
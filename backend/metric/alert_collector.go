@@ -0,0 +1,183 @@
+//go:build linux
+
+package metric
+
+import (
+	"strconv"
+	"strings"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+// AlertSampleCollector 按告警规则的需要采集指标 , 它自己保存 cpu 快照 ,
+// 不依赖按需运行的 DynamicMetricService
+type AlertSampleCollector struct {
+	reader     FsReaderInterface
+	background *BackgroundService
+	cpuSnap    model.CpuSnap
+	hasCpuSnap bool
+}
+
+func NewAlertSampleCollector(reader FsReaderInterface, background *BackgroundService) *AlertSampleCollector {
+	return &AlertSampleCollector{
+		reader:     reader,
+		background: background,
+	}
+}
+
+func (c *AlertSampleCollector) CollectAlertSamples(requests []model.AlertSampleRequest) []model.AlertSample {
+	var result []model.AlertSample
+	collected := make(map[model.AlertMetricName]bool)
+	for _, request := range requests {
+		switch request.Metric {
+		case model.AlertMetricFilesystemUsedPercent:
+			mountPoint := request.Labels["mount"]
+			if mountPoint == "" {
+				continue
+			}
+			if value, ok := readFilesystemUsedPercent(mountPoint); ok {
+				result = append(result, model.AlertSample{
+					Metric: request.Metric,
+					Labels: map[string]string{"mount": mountPoint},
+					Value:  value,
+				})
+			}
+			continue
+		case model.AlertMetricLinkUp:
+			name := request.Labels["interface"]
+			if name == "" {
+				continue
+			}
+			result = append(result, model.AlertSample{
+				Metric: request.Metric,
+				Labels: map[string]string{"interface": name},
+				Value:  readLinkUp(c.reader, name),
+			})
+			continue
+		}
+
+		// 以下指标与标签无关 , 多条规则共用一次采集
+		if collected[request.Metric] {
+			continue
+		}
+		collected[request.Metric] = true
+		result = append(result, c.collect(request.Metric)...)
+	}
+	return result
+}
+
+func (c *AlertSampleCollector) collect(metric model.AlertMetricName) []model.AlertSample {
+	single := func(value float64) []model.AlertSample {
+		return []model.AlertSample{{Metric: metric, Value: value}}
+	}
+	switch metric {
+	case model.AlertMetricCpuTemperature:
+		temperature, _ := readCpuTemperature(c.reader)
+		if temperature < 0 {
+			return nil
+		}
+		return single(temperature)
+	case model.AlertMetricCpuUsage:
+		usage, _ := readTotalCpuUsage(c.reader, &c.cpuSnap)
+		// 第一次没有上一个快照 , 算出来的是开机以来的平均值
+		if !c.hasCpuSnap {
+			c.hasCpuSnap = true
+			return nil
+		}
+		return single(usage)
+	case model.AlertMetricMemoryUsedPercent:
+		memory := ReadMemoryMetric(c.reader)
+		return single(memory.UsedPercent.Value)
+	case model.AlertMetricConntrackUsedPercent:
+		value, ok := readConntrackUsedPercent(c.reader)
+		if !ok {
+			return nil
+		}
+		return single(value)
+	case model.AlertMetricHostRate:
+		if c.background == nil {
+			return nil
+		}
+		return c.background.hostRateSamples()
+	}
+	return nil
+}
+
+func readConntrackUsedPercent(reader FsReaderInterface) (float64, bool) {
+	rawCount, err := reader.ReadFile(procPaths.ConntrackCount())
+	if err != nil {
+		return 0, false
+	}
+	rawMax, err := reader.ReadFile(procPaths.ConntrackMax())
+	if err != nil {
+		return 0, false
+	}
+	count, err := strconv.ParseFloat(strings.TrimSpace(rawCount), 64)
+	if err != nil {
+		return 0, false
+	}
+	maxCount, err := strconv.ParseFloat(strings.TrimSpace(rawMax), 64)
+	if err != nil || maxCount <= 0 {
+		return 0, false
+	}
+	return count / maxCount * 100, true
+}
+
+// 网卡不存在也算断开 , 比如 pppoe 掉线后 pppoe-wan 会消失
+func readLinkUp(reader FsReaderInterface, name string) float64 {
+	raw, err := reader.ReadFile(procPaths.NetworkInterfaceOperState(name))
+	if err != nil {
+		return 0
+	}
+	switch strings.TrimSpace(raw) {
+	// ppp 和 tun 等点对点设备的 operstate 一直是 unknown
+	case "up", "unknown":
+		return 1
+	}
+	return 0
+}
+
+func readFilesystemUsedPercent(mountPoint string) (float64, bool) {
+	stat, err := getStatfs(mountPoint)
+	if err != nil {
+		return 0, false
+	}
+	total := stat.Blocks * uint64(stat.Bsize)
+	if total == 0 {
+		return 0, false
+	}
+	// 和 df 一样 , 普通用户可用的空间才算可用
+	used := (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
+	available := stat.Bavail * uint64(stat.Bsize)
+	if used+available == 0 {
+		return 0, false
+	}
+	return float64(used) / float64(used+available) * 100, true
+}
+
+// 采集每个 ip 的实时速率 , 同时保持 ebpf 抓包处于开启状态
+func (b *BackgroundService) hostRateSamples() []model.AlertSample {
+	svc := b.ebpfService.Load()
+	if svc == nil {
+		return nil
+	}
+	svc.ActiveSignal()
+
+	svc.mutex.RLock()
+	defer svc.mutex.RUnlock()
+	result := make([]model.AlertSample, 0, len(svc.metricsMap))
+	for ip, value := range svc.metricsMap {
+		ipType := model.IpAddressTypeWan
+		if svc.IsLanIp(ip) {
+			ipType = model.IpAddressTypeLan
+		} else if IsUnknownIp(ip) {
+			ipType = model.IpAddressTypeUnknown
+		}
+		result = append(result, model.AlertSample{
+			Metric: model.AlertMetricHostRate,
+			Labels: map[string]string{"ip": formatIP(ip), "ip_type": string(ipType)},
+			Value:  value.SmoothDownloadRate + value.SmoothUploadRate,
+		})
+	}
+	return result
+}
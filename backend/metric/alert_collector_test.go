@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package metric
+
+import (
+	"testing"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectAlertSamples(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, testProcPaths.ConntrackCount(), []byte("3072\n"), 0o644)
+	_ = afero.WriteFile(fs, testProcPaths.ConntrackMax(), []byte("4096\n"), 0o644)
+	_ = afero.WriteFile(fs, testProcPaths.NetworkInterfaceOperState("eth1"), []byte("down\n"), 0o644)
+	_ = afero.WriteFile(fs, testProcPaths.NetworkInterfaceOperState("pppoe-wan"), []byte("unknown\n"), 0o644)
+
+	c := NewAlertSampleCollector(FsReader{Fs: fs}, nil)
+	samples := c.CollectAlertSamples([]model.AlertSampleRequest{
+		{Metric: model.AlertMetricConntrackUsedPercent},
+		{Metric: model.AlertMetricConntrackUsedPercent, Labels: map[string]string{"unused": "x"}},
+		{Metric: model.AlertMetricLinkUp, Labels: map[string]string{"interface": "eth1"}},
+		{Metric: model.AlertMetricLinkUp, Labels: map[string]string{"interface": "pppoe-wan"}},
+		{Metric: model.AlertMetricLinkUp, Labels: map[string]string{"interface": "wwan0"}},
+		{Metric: model.AlertMetricLinkUp},
+		{Metric: model.AlertMetricHostRate},
+	})
+
+	assert.Equal(t, []model.AlertSample{
+		{Metric: model.AlertMetricConntrackUsedPercent, Value: 75},
+		{Metric: model.AlertMetricLinkUp, Labels: map[string]string{"interface": "eth1"}, Value: 0},
+		{Metric: model.AlertMetricLinkUp, Labels: map[string]string{"interface": "pppoe-wan"}, Value: 1},
+		{Metric: model.AlertMetricLinkUp, Labels: map[string]string{"interface": "wwan0"}, Value: 0},
+	}, samples)
+}
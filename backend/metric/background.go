@@ -7,13 +7,39 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
 	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/oui"
 	"openwrt-diskio-api/backend/utils"
 )
 
+// DomainAnnotatorInterface 用于给连接的目标 ip 标注客户端查询过的域名
+type DomainAnnotatorInterface interface {
+	LookupDomain(ip string, client string) string
+}
+
+// MacAnnotatorInterface 用于查询局域网 ip 对应的 mac 地址
+type MacAnnotatorInterface interface {
+	GetMac(ip string) string
+}
+
+// GeoIpAnnotatorInterface 用于给公网地址标注国家和 ASN
+type GeoIpAnnotatorInterface interface {
+	LookupGeoIp(ip string) (model.GeoIpInfo, bool)
+}
+
+// DeviceObserverInterface 用于记录有流量的局域网设备
+type DeviceObserverInterface interface {
+	ObserveTraffic(ip string, mac string)
+}
+
 type BackgroundService struct {
 	Reader                                 FsReaderInterface
 	Runner                                 CommandRunnerInterface
@@ -21,13 +47,26 @@ type BackgroundService struct {
 	UpdateStaticMetricInterval             uint
 	UpdateDynamicMetricInterval            uint
 	UpdateNetworkConnectionDetailsInterval uint
+	UpdateProcessMetricInterval            uint
 	TrafficCaptureInterfaceName            string
 	TrafficKeyExpiredTime                  time.Duration
 	updatingStatusMap                      sync.Map
 	UpdateEventChan                        chan string
 	wg                                     sync.WaitGroup
-	ebpfService                            *EbpfNetTrafficService
+	ebpfService                            atomic.Pointer[EbpfNetTrafficService] // 加载完 ebpf 程序后才设置 , 之前为 nil
 	dynamicMetricService                   *DynamicMetricService
+	DomainAnnotator                        DomainAnnotatorInterface
+	MacAnnotator                           MacAnnotatorInterface
+	DeviceObserver                         DeviceObserverInterface
+	GeoIpAnnotator                         GeoIpAnnotatorInterface
+	DnsSnoopingEnable                      bool
+	SniCaptureEnable                       bool
+	collectorStatus                        collectorStatusRecorder
+	collectorTiming                        collectorTimingRecorder
+	jsonCacheCounters                      jsonCacheCounters
+	processSnap                            model.ProcessSnap
+	processSnapMutex                       sync.Mutex
+	linkTracker                            linkTracker
 }
 
 func (b *BackgroundService) SetConfig(
@@ -52,37 +91,40 @@ func (b *BackgroundService) SetUpdateDynamicMetricInterval(interval uint) {
 func (b *BackgroundService) SetUpdateNetworkConnectionDetailsInterval(interval uint) {
 	b.UpdateNetworkConnectionDetailsInterval = interval
 }
+func (b *BackgroundService) SetUpdateProcessMetricInterval(interval uint) {
+	b.UpdateProcessMetricInterval = interval
+}
 
 func (b *BackgroundService) UpdateStaticMetric() {
+	defer b.collectorTiming.observe(CollectorStaticMetric, time.Now())
 	updateInterval := b.UpdateStaticMetricInterval
 
 	staticSystemMetric := ReadStaticSystemMetric(b.Reader, b.Runner)
 	staticNetworkMetric := ReadStaticNetworkMetric(b.Reader, b.Runner)
+	links, err := ReadNetworkLinks(b.Reader, &b.linkTracker)
+	b.collectorStatus.record(CollectorNetworkLink, err)
+	for name, link := range links {
+		interfaceInfo := staticNetworkMetric[name]
+		interfaceInfo.Link = &link
+		staticNetworkMetric[name] = interfaceInfo
+	}
 
 	jsonBytes, err := json.Marshal(&model.StaticMetric{
-		Network: staticNetworkMetric,
-		System:  staticSystemMetric,
+		Network:     staticNetworkMetric,
+		System:      staticSystemMetric,
+		Filesystems: ReadFilesystemMetric(b.Reader),
 	})
 	if err != nil {
-		log.Fatalf("StaticMetric json marshal error : %s", err)
-	}
-
-	isGzip := false
-	if len(jsonBytes) > model.GzipThreshold {
-		gzipBytes, err := utils.GzipBytes(jsonBytes)
-		if err != nil {
-			log.Printf("StaticMetric gzip error : %s", err)
-		} else {
-			isGzip = true
-			jsonBytes = gzipBytes
-		}
+		log.Printf("StaticMetric json marshal error : %s", err)
+		b.collectorStatus.record(CollectorStaticMetric, err)
+		return
 	}
 
+	b.collectorStatus.record(CollectorStaticMetric, nil)
 	b.setJsonBytes(
 		model.JsonCacheKeyStaticMetric,
 		time.Duration(updateInterval)*time.Second,
 		jsonBytes,
-		isGzip,
 	)
 }
 
@@ -92,6 +134,7 @@ func (b *BackgroundService) RunDynamicMetricService(ctx context.Context) {
 			b.Reader,
 			b.UpdateDynamicMetricInterval,
 		)
+		b.dynamicMetricService.timing = &b.collectorTiming
 	}
 
 	go b.dynamicMetricService.Run(ctx)
@@ -107,68 +150,75 @@ func (b *BackgroundService) UpdateDynamicMetric() {
 	updateInterval := b.UpdateDynamicMetricInterval
 	jsonBytes, err := json.Marshal(dynamicMetric)
 	if err != nil {
-		log.Fatalf("dynamicMetric json marshal error : %s", err)
-	}
-
-	isGzip := false
-	if len(jsonBytes) > model.GzipThreshold {
-		gzipBytes, err := utils.GzipBytes(jsonBytes)
-		if err != nil {
-			log.Printf("StaticMetric gzip error : %s", err)
-		} else {
-			isGzip = true
-			jsonBytes = gzipBytes
-		}
+		log.Printf("dynamicMetric json marshal error : %s", err)
+		b.collectorStatus.record(CollectorDynamicMetric, err)
+		return
 	}
 
+	b.collectorStatus.record(CollectorDynamicMetric, nil)
 	b.setJsonBytes(
 		model.JsonCacheKeyDynamicMetric,
 		time.Duration(updateInterval)*time.Second,
 		jsonBytes,
-		isGzip,
 	)
 }
 
+// RunAggregationTrafficService 加载 ebpf 程序需要一段时间 , 其它 goroutine 和 http 请求同时在读 ebpfService ,
+// 所以全部初始化完成后才发布出去
 func (b *BackgroundService) RunAggregationTrafficService(ctx context.Context) {
-	if b.ebpfService == nil {
-		b.ebpfService = NewEbpfNetTrafficService(
-			b.TrafficKeyExpiredTime,
-		)
-	}
-	if err := b.ebpfService.InitEbpfInterfaceDevice(b.TrafficCaptureInterfaceName); err != nil {
+	svc := NewEbpfNetTrafficService(
+		b.TrafficKeyExpiredTime,
+		b.DnsSnoopingEnable,
+		b.SniCaptureEnable,
+	)
+	if err := svc.InitEbpfInterfaceDevice(b.TrafficCaptureInterfaceName); err != nil {
 		log.Fatalf("init ebpf interface device error : %s", err)
 	}
-	go b.ebpfService.Run(ctx)
+	svc.linkObserver = b.onLinkUpdate
+	b.ebpfService.Store(svc)
+	go svc.Run(ctx)
 	b.UpdateAggregationTrafficMetric()
 }
 
+// onLinkUpdate 网卡的任何变化都刷新静态信息 , 刷新还没完成时的事件会被合并
+func (b *BackgroundService) onLinkUpdate(update netlink.LinkUpdate) {
+	name := update.Attrs().Name
+	if update.Header.Type == unix.RTM_DELLINK {
+		b.linkTracker.forget(name)
+	} else {
+		b.linkTracker.observe(name, linkCarrier(update.Attrs()), time.Now())
+	}
+	b.requestUpdate(model.JsonCacheKeyStaticMetric)
+}
+
 func (b *BackgroundService) AggregationTrafficServiceActiveSignal() {
-	b.ebpfService.ActiveSignal()
+	if svc := b.ebpfService.Load(); svc != nil {
+		svc.ActiveSignal()
+	}
 }
 
 func (b *BackgroundService) UpdateAggregationTrafficMetric() {
-	aggregationTrafficMetric := b.ebpfService.GetAggregationTrafficMetric()
+	svc := b.ebpfService.Load()
+	// 还在加载 ebpf 程序 , 加载完成后会立即更新一次
+	if svc == nil {
+		return
+	}
+	defer b.collectorTiming.observe(CollectorAggregationTraffic, time.Now())
+	aggregationTrafficMetric := svc.GetAggregationTrafficMetric()
+	b.annotateMacVendor(aggregationTrafficMetric.Details)
+	b.annotateGeoIp(aggregationTrafficMetric.Details)
 	jsonBytes, err := json.Marshal(aggregationTrafficMetric)
 	if err != nil {
-		log.Fatalf("AggregationTrafficMetric json marshal error : %s", err)
-	}
-
-	isGzip := false
-	if len(jsonBytes) > model.GzipThreshold {
-		gzipBytes, err := utils.GzipBytes(jsonBytes)
-		if err != nil {
-			log.Printf("StaticMetric gzip error : %s", err)
-		} else {
-			isGzip = true
-			jsonBytes = gzipBytes
-		}
+		log.Printf("AggregationTrafficMetric json marshal error : %s", err)
+		b.collectorStatus.record(CollectorAggregationTraffic, err)
+		return
 	}
 
+	b.collectorStatus.record(CollectorAggregationTraffic, nil)
 	b.setJsonBytes(
 		model.JsonCacheKeyAggregationTraffic,
 		time.Duration(1)*time.Second,
 		jsonBytes,
-		isGzip,
 	)
 }
 
@@ -178,32 +228,115 @@ func (b *BackgroundService) UpdateNetworkConnectionDetails() {
 	privateCidr := ReadPrivateIpv4Addresses(b.Runner)
 
 	networkConnectionMetric := &model.NetworkConnectionMetric{}
-	ReadConnectionMetric(b.Reader, networkConnectionMetric, privateCidr)
+	readStart := time.Now()
+	err := ReadConnectionMetric(b.Reader, networkConnectionMetric, privateCidr)
+	b.collectorTiming.observe(CollectorNetworkConnection, readStart)
+	b.collectorStatus.record(CollectorNetworkConnection, err)
+	for index, connection := range networkConnectionMetric.Details {
+		networkConnectionMetric.Details[index].DestinationDomain = b.lookupDomain(
+			connection.DestinationIp,
+			connection.SourceIp,
+		)
+		if b.GeoIpAnnotator != nil {
+			if geo, ok := b.GeoIpAnnotator.LookupGeoIp(connection.DestinationIp); ok {
+				networkConnectionMetric.Details[index].DestinationGeo = &geo
+			}
+		}
+		if svc := b.ebpfService.Load(); b.SniCaptureEnable && svc != nil {
+			networkConnectionMetric.Details[index].ServerName = svc.LookupServerName(
+				connection.SourceIp,
+				connection.SourcePort,
+				connection.DestinationIp,
+				connection.DestinationPort,
+				connection.Protocol,
+			)
+		}
+	}
 
 	jsonBytes, err := json.Marshal(networkConnectionMetric)
 	if err != nil {
-		log.Fatalf("NetworkConnectionDetails json marshal error : %s", err)
-	}
-
-	isGzip := false
-	if len(jsonBytes) > model.GzipThreshold {
-		gzipBytes, err := utils.GzipBytes(jsonBytes)
-		if err != nil {
-			log.Printf("StaticMetric gzip error : %s", err)
-		} else {
-			isGzip = true
-			jsonBytes = gzipBytes
-		}
+		log.Printf("NetworkConnectionDetails json marshal error : %s", err)
+		b.collectorStatus.record(CollectorNetworkConnection, err)
+		return
 	}
 
 	b.setJsonBytes(
 		model.JsonCacheKeyNetworkConnectionMetric,
 		time.Duration(updateInterval)*time.Second,
 		jsonBytes,
-		isGzip,
 	)
 }
 
+// UpdateProcessMetric 进程的 cpu 占用是两次刷新之间的差值 , 所以只在有人请求时才会按间隔刷新
+func (b *BackgroundService) UpdateProcessMetric() {
+	defer b.collectorTiming.observe(CollectorProcessMetric, time.Now())
+	b.processSnapMutex.Lock()
+	processMetric, err := ReadProcessMetric(b.Reader, &b.processSnap)
+	b.processSnapMutex.Unlock()
+	b.collectorStatus.record(CollectorProcessMetric, err)
+	if err != nil {
+		log.Printf("ProcessMetric read error : %s", err)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(processMetric)
+	if err != nil {
+		log.Printf("ProcessMetric json marshal error : %s", err)
+		b.collectorStatus.record(CollectorProcessMetric, err)
+		return
+	}
+
+	b.setJsonBytes(
+		model.JsonCacheKeyProcessMetric,
+		time.Duration(b.UpdateProcessMetricInterval)*time.Second,
+		jsonBytes,
+	)
+}
+
+func (b *BackgroundService) annotateMacVendor(details []model.AggregationTrafficDetails) {
+	if b.MacAnnotator == nil {
+		return
+	}
+	for index, detail := range details {
+		mac := b.MacAnnotator.GetMac(detail.Ip)
+		if mac == "" {
+			continue
+		}
+		details[index].Mac = mac
+		details[index].Vendor, details[index].RandomizedMac = oui.Lookup(mac)
+		if b.DeviceObserver != nil && (detail.Incoming.Value > 0 || detail.Outgoing.Value > 0) {
+			b.DeviceObserver.ObserveTraffic(detail.Ip, mac)
+		}
+	}
+}
+
+func (b *BackgroundService) annotateGeoIp(details []model.AggregationTrafficDetails) {
+	if b.GeoIpAnnotator == nil {
+		return
+	}
+	for index, detail := range details {
+		if detail.IpType != model.IpAddressTypeWan {
+			continue
+		}
+		if geo, ok := b.GeoIpAnnotator.LookupGeoIp(detail.Ip); ok {
+			details[index].Geo = &geo
+		}
+	}
+}
+
+// 优先使用 passive dns 的结果 , 找不到再用 ebpf 嗅探到的 dns 应答
+func (b *BackgroundService) lookupDomain(ip string, client string) string {
+	if b.DomainAnnotator != nil {
+		if domain := b.DomainAnnotator.LookupDomain(ip, client); domain != "" {
+			return domain
+		}
+	}
+	if svc := b.ebpfService.Load(); b.DnsSnoopingEnable && svc != nil {
+		return svc.LookupDomain(ip, client)
+	}
+	return ""
+}
+
 func (b *BackgroundService) Worker(index int) {
 	b.wg.Add(1)
 	defer b.wg.Done()
@@ -217,6 +350,8 @@ func (b *BackgroundService) Worker(index int) {
 			b.UpdateNetworkConnectionDetails()
 		case model.JsonCacheKeyAggregationTraffic:
 			b.UpdateAggregationTrafficMetric()
+		case model.JsonCacheKeyProcessMetric:
+			b.UpdateProcessMetric()
 		}
 
 		b.updatingStatusMap.Delete(key)
@@ -224,22 +359,42 @@ func (b *BackgroundService) Worker(index int) {
 	log.Printf("worker %d exit", index)
 }
 
-func (b *BackgroundService) setJsonBytes(key string, updateInterval time.Duration, value []byte, isGzip bool) {
+// setJsonBytes 同时缓存未压缩和 gzip 两个版本 , 由请求的 Accept-Encoding 决定返回哪个
+func (b *BackgroundService) setJsonBytes(key string, updateInterval time.Duration, value []byte) {
+	b.jsonCacheCounters.get(key).refreshes.Add(1)
+	var gzipBytes []byte
+	if len(value) > model.GzipThreshold {
+		var err error
+		if gzipBytes, err = utils.GzipBytes(value); err != nil {
+			log.Printf("%s gzip error : %s", key, err)
+		}
+	}
 	now := time.Now().UTC()
 	b.jsonCache.Store(key,
 		model.CacheValue{
 			UpdateAt: now,
 			ExpireAt: now.Add(updateInterval),
 			Data:     value,
-			IsGzip:   isGzip,
+			Gzip:     gzipBytes,
 		},
 	)
 }
-func (b *BackgroundService) GetJsonBytes(key string) ([]byte, bool) {
-	rawCache, ok := b.jsonCache.Load(key)
+func (b *BackgroundService) GetJsonBytes(key string) []byte {
+	cache, ok := b.GetJsonCache(key)
 	if !ok {
 		log.Printf("get json cache failed : %s not found", key)
-		return []byte{}, false
+		return []byte{}
+	}
+	return cache.Data
+}
+
+// GetJsonCache 返回缓存和它的时间信息 , 缓存过期时触发后台刷新并返回旧数据
+func (b *BackgroundService) GetJsonCache(key string) (model.CacheValue, bool) {
+	counter := b.jsonCacheCounters.get(key)
+	rawCache, ok := b.jsonCache.Load(key)
+	if !ok {
+		counter.misses.Add(1)
+		return model.CacheValue{}, false
 	}
 	cache, ok := rawCache.(model.CacheValue)
 	if !ok {
@@ -247,22 +402,54 @@ func (b *BackgroundService) GetJsonBytes(key string) ([]byte, bool) {
 	}
 
 	now := time.Now().UTC()
-	if now.After(cache.ExpireAt) {
-		if _, loading := b.updatingStatusMap.LoadOrStore(key, true); !loading {
-			select {
-			case b.UpdateEventChan <- key:
-			default:
-				b.updatingStatusMap.Delete(key)
-			}
+	if !now.After(cache.ExpireAt) {
+		counter.hits.Add(1)
+		return cache, true
+	}
+	counter.stale.Add(1)
+	b.requestUpdate(key)
+	return cache, true
+}
+
+// requestUpdate 通知 worker 刷新缓存 , 同一个缓存正在刷新时不重复通知
+func (b *BackgroundService) requestUpdate(key string) {
+	if _, loading := b.updatingStatusMap.LoadOrStore(key, true); loading {
+		return
+	}
+	select {
+	case b.UpdateEventChan <- key:
+	default:
+		b.jsonCacheCounters.get(key).dropped.Add(1)
+		b.updatingStatusMap.Delete(key)
+	}
+}
+
+// 缓存数据依赖的采集器 , 任何一个失败都说明缓存可能不可信
+var jsonCacheCollectors = map[string][]string{
+	model.JsonCacheKeyStaticMetric:            {CollectorStaticMetric, CollectorNetworkLink},
+	model.JsonCacheKeyDynamicMetric:           {CollectorDynamicMetric},
+	model.JsonCacheKeyNetworkConnectionMetric: {CollectorNetworkConnection},
+	model.JsonCacheKeyAggregationTraffic:      {CollectorAggregationTraffic, CollectorEbpfAttach, CollectorEbpfFlowMap},
+	model.JsonCacheKeyProcessMetric:           {CollectorProcessMetric},
+}
+
+// CollectorFailure 返回缓存依赖的采集器里最近一次失败的那个
+func (b *BackgroundService) CollectorFailure(key string) (model.CollectorStatus, bool) {
+	names := jsonCacheCollectors[key]
+	for _, status := range b.CollectorStatus() {
+		if !status.Healthy && slices.Contains(names, status.Name) {
+			return status, true
 		}
 	}
-	return cache.Data, cache.IsGzip
+	return model.CollectorStatus{}, false
 }
 
 func (b *BackgroundService) Close() {
 	if b.UpdateEventChan != nil {
 		close(b.UpdateEventChan)
 	}
-	b.ebpfService.Close()
+	if svc := b.ebpfService.Load(); svc != nil {
+		svc.Close()
+	}
 	b.wg.Wait()
 }
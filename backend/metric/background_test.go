@@ -1 +1,91 @@
 package metric
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMacAnnotator map[string]string
+
+func (f fakeMacAnnotator) GetMac(ip string) string {
+	return f[ip]
+}
+
+func TestAnnotateMacVendor(t *testing.T) {
+	b := &BackgroundService{MacAnnotator: fakeMacAnnotator{
+		"192.168.1.10": "b8:27:eb:12:34:56",
+		"192.168.1.11": "da:a1:19:12:34:56",
+	}}
+	details := []model.AggregationTrafficDetails{
+		{Ip: "192.168.1.10"},
+		{Ip: "192.168.1.11"},
+		{Ip: "1.1.1.1"},
+	}
+	b.annotateMacVendor(details)
+
+	assert.Equal(t, "b8:27:eb:12:34:56", details[0].Mac)
+	assert.Equal(t, "Raspberry Pi Foundation", details[0].Vendor)
+	assert.False(t, details[0].RandomizedMac)
+	assert.Equal(t, "", details[1].Vendor)
+	assert.True(t, details[1].RandomizedMac)
+	assert.Equal(t, "", details[2].Mac)
+}
+
+type fakeGeoIpAnnotator map[string]model.GeoIpInfo
+
+func (f fakeGeoIpAnnotator) LookupGeoIp(ip string) (model.GeoIpInfo, bool) {
+	info, ok := f[ip]
+	return info, ok
+}
+
+func TestAnnotateGeoIp(t *testing.T) {
+	b := &BackgroundService{GeoIpAnnotator: fakeGeoIpAnnotator{
+		"8.8.8.8":      {CountryCode: "US", Asn: 15169, AsOrganization: "GOOGLE"},
+		"192.168.1.10": {CountryCode: "ZZ"},
+	}}
+	details := []model.AggregationTrafficDetails{
+		{Ip: "8.8.8.8", IpType: model.IpAddressTypeWan},
+		{Ip: "192.168.1.10", IpType: model.IpAddressTypeLan},
+	}
+	b.annotateGeoIp(details)
+
+	assert.Equal(t, &model.GeoIpInfo{CountryCode: "US", Asn: 15169, AsOrganization: "GOOGLE"}, details[0].Geo)
+	assert.Nil(t, details[1].Geo)
+}
+
+func TestSetJsonBytesKeepsBothEncodings(t *testing.T) {
+	b := &BackgroundService{}
+	small := []byte(`{}`)
+	large := []byte(`{"data":"` + strings.Repeat("a", model.GzipThreshold) + `"}`)
+	b.setJsonBytes(model.JsonCacheKeyStaticMetric, time.Minute, small)
+	b.setJsonBytes(model.JsonCacheKeyDynamicMetric, time.Minute, large)
+
+	cache, ok := b.GetJsonCache(model.JsonCacheKeyStaticMetric)
+	assert.True(t, ok)
+	assert.Equal(t, small, cache.Data)
+	assert.Empty(t, cache.Gzip)
+
+	cache, ok = b.GetJsonCache(model.JsonCacheKeyDynamicMetric)
+	assert.True(t, ok)
+	assert.Equal(t, large, cache.Data)
+	decoded, err := utils.GunzipBytes(cache.Gzip)
+	assert.NoError(t, err)
+	assert.Equal(t, large, decoded)
+}
+
+// ebpf 程序加载完成之前 , 请求和其它 goroutine 都可能访问抓包服务
+func TestAggregationTrafficBeforeEbpfLoaded(t *testing.T) {
+	b := &BackgroundService{}
+	b.AggregationTrafficServiceActiveSignal()
+	b.UpdateAggregationTrafficMetric()
+	_, ok := b.GetJsonCache(model.JsonCacheKeyAggregationTraffic)
+	assert.False(t, ok)
+	assert.Empty(t, b.hostRateSamples())
+	assert.Empty(t, b.CollectorStatus())
+}
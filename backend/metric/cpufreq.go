@@ -0,0 +1,93 @@
+//go:build linux
+
+package metric
+
+import (
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+// readFrequencyMHz cpufreq 里的频率单位是 kHz , 读不到时为 -1
+func readFrequencyMHz(reader FsReaderInterface, file string) model.MetricUnit {
+	value, ok := readSysfsValue(reader, file, 1000)
+	if !ok {
+		return model.MetricUnit{Value: -1, Unit: model.MHz}
+	}
+	return model.MetricUnit{Value: value, Unit: model.MHz}
+}
+
+// parseTimeInState 解析 stats/time_in_state , 每行是频率 kHz 和累计时间 , 时间单位是 10ms :
+// 408000 123456
+func parseTimeInState(raw string) map[uint64]uint64 {
+	result := map[uint64]uint64{}
+	for _, line := range strings.Split(raw, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		frequency, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		result[frequency], _ = strconv.ParseUint(fields[1], 10, 64)
+	}
+	return result
+}
+
+// readCpuFrequency 读取一个核心的 cpufreq , 没有 cpufreq 目录时返回 nil . last 为 nil 表示没有上一次的采样
+func readCpuFrequency(reader FsReaderInterface, core int, last *model.CpuFreqSnap) (*model.CpuFrequencyMetric, model.CpuFreqSnap) {
+	snap := model.CpuFreqSnap{}
+	dir := procPaths.CpuFreqDir(core)
+	governor, err := reader.ReadFile(path.Join(dir, "scaling_governor"))
+	if err != nil {
+		return nil, snap
+	}
+	result := &model.CpuFrequencyMetric{
+		Current:        readFrequencyMHz(reader, path.Join(dir, "scaling_cur_freq")),
+		Min:            readFrequencyMHz(reader, path.Join(dir, "scaling_min_freq")),
+		Max:            readFrequencyMHz(reader, path.Join(dir, "scaling_max_freq")),
+		HardwareMin:    readFrequencyMHz(reader, path.Join(dir, "cpuinfo_min_freq")),
+		HardwareMax:    readFrequencyMHz(reader, path.Join(dir, "cpuinfo_max_freq")),
+		Governor:       strings.TrimSpace(governor),
+		TimeInState:    []model.FrequencyInState{},
+		ThrottleEvents: -1,
+	}
+	result.Throttled = result.Max.Value > 0 && result.Max.Value < result.HardwareMax.Value
+
+	if raw, err := reader.ReadFile(path.Join(dir, "stats", "time_in_state")); err == nil {
+		snap.TimeInState = parseTimeInState(raw)
+		frequencies := make([]uint64, 0, len(snap.TimeInState))
+		for frequency := range snap.TimeInState {
+			frequencies = append(frequencies, frequency)
+		}
+		slices.Sort(frequencies)
+		for _, frequency := range frequencies {
+			seconds := -1.0
+			// 重置统计后计数会变小 , 这时也当作没有上一次的采样
+			if last != nil {
+				if lastTime, ok := last.TimeInState[frequency]; ok && snap.TimeInState[frequency] >= lastTime {
+					seconds = float64(snap.TimeInState[frequency]-lastTime) / 100
+				}
+			}
+			result.TimeInState = append(result.TimeInState, model.FrequencyInState{
+				Frequency: model.MetricUnit{Value: float64(frequency) / 1000, Unit: model.MHz},
+				Seconds:   seconds,
+			})
+		}
+	}
+
+	if raw, err := reader.ReadFile(procPaths.CpuThrottleCount(core)); err == nil {
+		count, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 64)
+		if err == nil {
+			snap.ThrottleCount = count
+			if last != nil && count >= last.ThrottleCount {
+				result.ThrottleEvents = int64(count - last.ThrottleCount)
+			}
+		}
+	}
+	return result, snap
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package metric
+
+import (
+	"testing"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFakeCpuFreq(t *testing.T, fs afero.Fs, timeInState string) {
+	dir := "/sys/devices/system/cpu/cpu0/cpufreq/"
+	files := map[string]string{
+		"scaling_governor":    "schedutil\n",
+		"scaling_cur_freq":    "408000\n",
+		"scaling_min_freq":    "408000\n",
+		"scaling_max_freq":    "1200000\n",
+		"cpuinfo_min_freq":    "408000\n",
+		"cpuinfo_max_freq":    "1800000\n",
+		"stats/time_in_state": timeInState,
+	}
+	for name, content := range files {
+		assert.NoError(t, afero.WriteFile(fs, dir+name, []byte(content), 0644))
+	}
+}
+
+func TestReadCpuFrequency(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+
+	writeFakeCpuFreq(t, fs, "1200000 300\n408000 1000\n")
+	first, snap := readCpuFrequency(reader, 0, nil)
+	assert.Equal(t, model.MetricUnit{Value: 408, Unit: model.MHz}, first.Current)
+	assert.Equal(t, 1800.0, first.HardwareMax.Value)
+	assert.Equal(t, "schedutil", first.Governor)
+	assert.True(t, first.Throttled)
+	assert.Equal(t, int64(-1), first.ThrottleEvents)
+	assert.Equal(t, []model.FrequencyInState{
+		{Frequency: model.MetricUnit{Value: 408, Unit: model.MHz}, Seconds: -1},
+		{Frequency: model.MetricUnit{Value: 1200, Unit: model.MHz}, Seconds: -1},
+	}, first.TimeInState)
+
+	writeFakeCpuFreq(t, fs, "1200000 350\n408000 1150\n")
+	second, _ := readCpuFrequency(reader, 0, &snap)
+	assert.Equal(t, 1.5, second.TimeInState[0].Seconds)
+	assert.Equal(t, 0.5, second.TimeInState[1].Seconds)
+
+	// 没有 cpufreq 的核心
+	missing, _ := readCpuFrequency(reader, 1, nil)
+	assert.Nil(t, missing)
+}
@@ -0,0 +1,168 @@
+//go:build linux
+
+package metric
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/netip"
+	"time"
+
+	"openwrt-diskio-api/backend/dns"
+	bpf "openwrt-diskio-api/backend/pkg/ebpf"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+)
+
+const (
+	EbpfConfigKeyCapture   uint32 = 0
+	EbpfConfigKeyDnsSnoop  uint32 = 1
+	EbpfDnsSnoopMaxEntries        = 32768
+	EbpfDnsSnoopBufferSize        = 64 * 1024 // 每个 cpu 的 perf buffer 大小
+	// dns 记录的 ttl 往往只有几十秒 , 但连接会一直存在 , 所以至少保留这么久
+	EbpfDnsSnoopMinKeepTime = 30 * time.Minute
+	// 对应 monitor.c 中 struct dns_event 的大小
+	ebpfDnsEventHeaderSize = 24
+)
+
+type snoopedDomain struct {
+	domain   string
+	expireAt time.Time
+}
+
+func (svc *EbpfNetTrafficService) runDnsSnooping(ctx context.Context) {
+	reader, err := perf.NewReader(svc.objs.DnsEvents, EbpfDnsSnoopBufferSize)
+	if err != nil {
+		log.Printf("Create dns snooping perf reader failed: %s", err)
+		svc.collectorStatus.record(CollectorDnsSnooping, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		_ = reader.Close()
+	}()
+	log.Println("Enable ebpf dns response snooping")
+
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return
+			}
+			log.Printf("Read dns snooping event failed: %s", err)
+			svc.collectorStatus.record(CollectorDnsSnooping, err)
+			continue
+		}
+		if record.LostSamples > 0 {
+			continue
+		}
+		client, payload, ok := parseDnsEvent(record.RawSample)
+		if !ok {
+			continue
+		}
+		svc.handleDnsResponse(client, payload, time.Now())
+		svc.collectorStatus.record(CollectorDnsSnooping, nil)
+	}
+}
+
+// 事件格式 : struct dns_event + 从以太网头开始的报文
+func parseDnsEvent(raw []byte) (client netip.Addr, payload []byte, ok bool) {
+	if len(raw) < ebpfDnsEventHeaderSize {
+		return client, nil, false
+	}
+	offset := int(binary.NativeEndian.Uint32(raw[16:20]))
+	length := int(binary.NativeEndian.Uint16(raw[20:22]))
+	family := raw[22]
+	packet := raw[ebpfDnsEventHeaderSize:]
+	if offset+length > len(packet) {
+		return client, nil, false
+	}
+
+	// 地址在内核里是按网络字节序直接拷贝的
+	switch family {
+	case 2: // AF_INET
+		client = netip.AddrFrom4([4]byte(raw[0:4]))
+	case 10: // AF_INET6
+		client = netip.AddrFrom16([16]byte(raw[0:16]))
+	default:
+		return client, nil, false
+	}
+	return client, packet[offset : offset+length], true
+}
+
+func (svc *EbpfNetTrafficService) handleDnsResponse(_ netip.Addr, payload []byte, now time.Time) {
+	question, answers, err := dns.ParseDnsResponse(payload)
+	if err != nil || len(answers) == 0 {
+		return
+	}
+
+	svc.dnsMutex.Lock()
+	defer svc.dnsMutex.Unlock()
+	for _, answer := range answers {
+		domain := question
+		if domain == "" {
+			domain = answer.Name
+		}
+		keepTime := max(time.Duration(answer.Ttl)*time.Second, EbpfDnsSnoopMinKeepTime)
+
+		addr := answer.Addr.Unmap()
+		if _, exist := svc.dnsDomainMap[addr]; !exist && len(svc.dnsDomainMap) >= EbpfDnsSnoopMaxEntries {
+			svc.cleanupExpiredDomainsLocked(now)
+			if len(svc.dnsDomainMap) >= EbpfDnsSnoopMaxEntries {
+				continue
+			}
+		}
+		svc.dnsDomainMap[addr] = snoopedDomain{
+			domain:   domain,
+			expireAt: now.Add(keepTime),
+		}
+	}
+}
+
+func (svc *EbpfNetTrafficService) cleanupExpiredDomains(now time.Time) {
+	svc.dnsMutex.Lock()
+	defer svc.dnsMutex.Unlock()
+	svc.cleanupExpiredDomainsLocked(now)
+}
+
+func (svc *EbpfNetTrafficService) cleanupExpiredDomainsLocked(now time.Time) {
+	for addr, item := range svc.dnsDomainMap {
+		if now.After(item.expireAt) {
+			delete(svc.dnsDomainMap, addr)
+		}
+	}
+}
+
+func (svc *EbpfNetTrafficService) lookupSnoopedDomain(addr netip.Addr) string {
+	svc.dnsMutex.RLock()
+	defer svc.dnsMutex.RUnlock()
+	item, ok := svc.dnsDomainMap[addr.Unmap()]
+	if !ok || time.Now().After(item.expireAt) {
+		return ""
+	}
+	return item.domain
+}
+
+// LookupDomain 实现 DomainAnnotatorInterface , 找不到返回空字符串
+func (svc *EbpfNetTrafficService) LookupDomain(ip string, _ string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	return svc.lookupSnoopedDomain(addr)
+}
+
+func setDnsSnooping(objs *bpf.BpfObjects, enabled bool) {
+	if objs == nil || objs.ConfigMap == nil {
+		return
+	}
+	key := EbpfConfigKeyDnsSnoop
+	val := uint32(0)
+	if enabled {
+		val = 1
+	}
+	_ = objs.ConfigMap.Update(&key, &val, ebpf.UpdateAny)
+}
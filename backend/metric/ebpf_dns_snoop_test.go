@@ -0,0 +1,56 @@
+//go:build linux
+
+package metric
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// example.com A 93.184.216.34 ttl=60
+var testDnsSnoopPayload = []byte{
+	0x00, 0x01, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00, 0x00, 0x01, 0x00, 0x01,
+	0xC0, 0x0C, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3C, 0x00, 0x04, 93, 184, 216, 34,
+}
+
+func newTestDnsEvent(client [4]byte, packetHeaderLength int, payload []byte) []byte {
+	raw := make([]byte, ebpfDnsEventHeaderSize)
+	copy(raw[0:4], client[:])
+	binary.NativeEndian.PutUint32(raw[16:20], uint32(packetHeaderLength))
+	binary.NativeEndian.PutUint16(raw[20:22], uint16(len(payload)))
+	raw[22] = 2
+	raw = append(raw, make([]byte, packetHeaderLength)...)
+	raw = append(raw, payload...)
+	// perf 事件末尾可能有对齐填充
+	return append(raw, 0, 0, 0, 0)
+}
+
+func TestParseDnsEvent(t *testing.T) {
+	raw := newTestDnsEvent([4]byte{192, 168, 1, 10}, 42, testDnsSnoopPayload)
+	client, payload, ok := parseDnsEvent(raw)
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("192.168.1.10"), client)
+	assert.Equal(t, testDnsSnoopPayload, payload)
+
+	_, _, ok = parseDnsEvent(raw[:ebpfDnsEventHeaderSize+20])
+	assert.False(t, ok)
+	_, _, ok = parseDnsEvent(raw[:10])
+	assert.False(t, ok)
+}
+
+func TestHandleDnsResponse(t *testing.T) {
+	svc := NewEbpfNetTrafficService(time.Minute, true, false)
+	now := time.Now()
+	svc.handleDnsResponse(netip.MustParseAddr("192.168.1.10"), testDnsSnoopPayload, now)
+
+	assert.Equal(t, "example.com", svc.LookupDomain("93.184.216.34", ""))
+	assert.Equal(t, "", svc.LookupDomain("1.1.1.1", ""))
+
+	svc.cleanupExpiredDomains(now.Add(EbpfDnsSnoopMinKeepTime + time.Second))
+	assert.Equal(t, "", svc.LookupDomain("93.184.216.34", ""))
+}
@@ -0,0 +1,261 @@
+//go:build linux
+
+package metric
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+	bpf "openwrt-diskio-api/backend/pkg/ebpf"
+	"openwrt-diskio-api/backend/sni"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+)
+
+const (
+	EbpfConfigKeySni                uint32 = 2
+	EbpfSniMaxEntries                      = 8192
+	EbpfSniMaxServicesPerIp                = 32
+	EbpfSniBufferSize                      = 128 * 1024 // 每个 cpu 的 perf buffer 大小
+	EbpfSniMinKeepTime                     = 5 * time.Minute
+	EbpfSniOtherServiceName                = "other"
+	EbpfQuicMaxAssemblies                  = 64
+	EbpfQuicMaxAssemblyBytes               = 8 * 1024
+	EbpfQuicAssemblyTimeout                = 3 * time.Second
+	ebpfSniEventHeaderSize                 = 48 // 对应 monitor.c 中 struct sni_event 的大小
+	ebpfFlowKeySrcPortOffset               = 32
+	ebpfFlowKeyFamilyOffset                = 36
+	ebpfFlowKeyProtoOffset                 = 37
+	ebpfSniEventPayloadOffsetOffset        = 40
+	ebpfSniEventPayloadLengthOffset        = 44
+)
+
+// flowTuple 以客户端 (发出 ClientHello 的一方) 为视角的五元组
+type flowTuple struct {
+	clientAddr netip.Addr
+	serverAddr netip.Addr
+	clientPort uint16
+	serverPort uint16
+	proto      uint8
+}
+
+type sniEntry struct {
+	serverName string
+	lastSeen   int64 // unix nano , frame 中使用时原子更新
+}
+
+type ServiceMetrics struct {
+	UploadRate         float64
+	DownloadRate       float64
+	SmoothUploadRate   float64
+	SmoothDownloadRate float64
+	TotalUpload        uint64
+	TotalDownload      uint64
+}
+
+func (svc *EbpfNetTrafficService) runSniSampling(ctx context.Context) {
+	reader, err := perf.NewReader(svc.objs.SniEvents, EbpfSniBufferSize)
+	if err != nil {
+		log.Printf("Create sni sampling perf reader failed: %s", err)
+		svc.collectorStatus.record(CollectorSniSampling, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		_ = reader.Close()
+	}()
+	log.Println("Enable ebpf tls sni / quic initial sampling")
+
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return
+			}
+			log.Printf("Read sni sampling event failed: %s", err)
+			svc.collectorStatus.record(CollectorSniSampling, err)
+			continue
+		}
+		if record.LostSamples > 0 {
+			continue
+		}
+		tuple, payload, ok := parseSniEvent(record.RawSample)
+		if !ok {
+			continue
+		}
+		svc.handleClientHello(tuple, payload, time.Now())
+		svc.collectorStatus.record(CollectorSniSampling, nil)
+	}
+}
+
+// 事件格式 : struct sni_event + 从以太网头开始的报文
+func parseSniEvent(raw []byte) (tuple flowTuple, payload []byte, ok bool) {
+	if len(raw) < ebpfSniEventHeaderSize {
+		return tuple, nil, false
+	}
+	offset := int(binary.NativeEndian.Uint32(raw[ebpfSniEventPayloadOffsetOffset:]))
+	length := int(binary.NativeEndian.Uint16(raw[ebpfSniEventPayloadLengthOffset:]))
+	packet := raw[ebpfSniEventHeaderSize:]
+	if offset+length > len(packet) {
+		return tuple, nil, false
+	}
+
+	switch raw[ebpfFlowKeyFamilyOffset] {
+	case 2: // AF_INET
+		tuple.clientAddr = netip.AddrFrom4([4]byte(raw[0:4]))
+		tuple.serverAddr = netip.AddrFrom4([4]byte(raw[16:20]))
+	case 10: // AF_INET6
+		tuple.clientAddr = netip.AddrFrom16([16]byte(raw[0:16]))
+		tuple.serverAddr = netip.AddrFrom16([16]byte(raw[16:32]))
+	default:
+		return tuple, nil, false
+	}
+	// 端口在内核里已经转换成主机字节序
+	tuple.clientPort = binary.NativeEndian.Uint16(raw[ebpfFlowKeySrcPortOffset:])
+	tuple.serverPort = binary.NativeEndian.Uint16(raw[ebpfFlowKeySrcPortOffset+2:])
+	tuple.proto = raw[ebpfFlowKeyProtoOffset]
+	return tuple, packet[offset : offset+length], true
+}
+
+func (svc *EbpfNetTrafficService) handleClientHello(tuple flowTuple, payload []byte, now time.Time) {
+	var serverName string
+	switch tuple.proto {
+	case model.ProtoTCP:
+		name, err := sni.ParseTlsRecords(payload)
+		if err != nil {
+			return
+		}
+		serverName = name
+	case model.ProtoUDP:
+		name, ok := svc.quicAssembler.Add(payload, now)
+		if !ok {
+			return
+		}
+		serverName = name
+	default:
+		return
+	}
+
+	svc.sniMutex.Lock()
+	defer svc.sniMutex.Unlock()
+	if _, exist := svc.sniMap[tuple]; !exist && len(svc.sniMap) >= EbpfSniMaxEntries {
+		svc.cleanupExpiredServerNamesLocked(now)
+		if len(svc.sniMap) >= EbpfSniMaxEntries {
+			return
+		}
+	}
+	svc.sniMap[tuple] = &sniEntry{
+		serverName: serverName,
+		lastSeen:   now.UnixNano(),
+	}
+}
+
+// 返回 flow 对应的 SNI , isUpload 表示 flow 的方向是 客户端 -> 服务端
+func (svc *EbpfNetTrafficService) lookupServerName(
+	srcAddr netip.Addr, srcPort uint16,
+	dstAddr netip.Addr, dstPort uint16,
+	proto uint8, now time.Time,
+) (serverName string, isUpload bool) {
+	svc.sniMutex.RLock()
+	defer svc.sniMutex.RUnlock()
+	if entry, ok := svc.sniMap[flowTuple{srcAddr, dstAddr, srcPort, dstPort, proto}]; ok {
+		atomic.StoreInt64(&entry.lastSeen, now.UnixNano())
+		return entry.serverName, true
+	}
+	if entry, ok := svc.sniMap[flowTuple{dstAddr, srcAddr, dstPort, srcPort, proto}]; ok {
+		atomic.StoreInt64(&entry.lastSeen, now.UnixNano())
+		return entry.serverName, false
+	}
+	return "", false
+}
+
+// LookupServerName 给 conntrack 的连接标注 SNI , 找不到返回空字符串
+func (svc *EbpfNetTrafficService) LookupServerName(srcIp string, srcPort int, dstIp string, dstPort int, protocol string) string {
+	srcAddr, err := netip.ParseAddr(srcIp)
+	if err != nil {
+		return ""
+	}
+	dstAddr, err := netip.ParseAddr(dstIp)
+	if err != nil {
+		return ""
+	}
+	if srcPort < 0 || srcPort > 65535 || dstPort < 0 || dstPort > 65535 {
+		return ""
+	}
+	var proto uint8
+	switch protocol {
+	case "tcp":
+		proto = model.ProtoTCP
+	case "udp":
+		proto = model.ProtoUDP
+	default:
+		return ""
+	}
+	serverName, _ := svc.lookupServerName(srcAddr, uint16(srcPort), dstAddr, uint16(dstPort), proto, time.Now())
+	return serverName
+}
+
+// 调用前必须持有 svc.mutex
+func (svc *EbpfNetTrafficService) serviceAggregate(clientAddr netip.Addr, serverName string, delta uint64, rate float64, isUpload bool) {
+	metric, ok := svc.metricsMap[clientAddr]
+	if !ok || delta == 0 {
+		return
+	}
+	if metric.Services == nil {
+		metric.Services = make(map[string]*ServiceMetrics)
+	}
+	service, ok := metric.Services[serverName]
+	if !ok {
+		if len(metric.Services) >= EbpfSniMaxServicesPerIp {
+			serverName = EbpfSniOtherServiceName
+		}
+		service, ok = metric.Services[serverName]
+		if !ok {
+			service = &ServiceMetrics{}
+			metric.Services[serverName] = service
+		}
+	}
+	if isUpload {
+		service.UploadRate += rate
+		service.TotalUpload += delta
+	} else {
+		service.DownloadRate += rate
+		service.TotalDownload += delta
+	}
+}
+
+func (svc *EbpfNetTrafficService) cleanupExpiredServerNames(now time.Time) {
+	svc.sniMutex.Lock()
+	defer svc.sniMutex.Unlock()
+	svc.cleanupExpiredServerNamesLocked(now)
+}
+
+func (svc *EbpfNetTrafficService) cleanupExpiredServerNamesLocked(now time.Time) {
+	keepTime := max(svc.keyExpiredTime, EbpfSniMinKeepTime)
+	for tuple, entry := range svc.sniMap {
+		lastSeen := time.Unix(0, atomic.LoadInt64(&entry.lastSeen))
+		if now.Sub(lastSeen) > keepTime {
+			delete(svc.sniMap, tuple)
+		}
+	}
+	svc.quicAssembler.Cleanup(now)
+}
+
+func setSniSampling(objs *bpf.BpfObjects, enabled bool) {
+	if objs == nil || objs.ConfigMap == nil {
+		return
+	}
+	key := EbpfConfigKeySni
+	val := uint32(0)
+	if enabled {
+		val = 1
+	}
+	_ = objs.ConfigMap.Update(&key, &val, ebpf.UpdateAny)
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package metric
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/sni/snitest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSniEvent(client, server [4]byte, clientPort, serverPort uint16, proto uint8, packetHeaderLength int, payload []byte) []byte {
+	raw := make([]byte, ebpfSniEventHeaderSize)
+	copy(raw[0:4], client[:])
+	copy(raw[16:20], server[:])
+	binary.NativeEndian.PutUint16(raw[ebpfFlowKeySrcPortOffset:], clientPort)
+	binary.NativeEndian.PutUint16(raw[ebpfFlowKeySrcPortOffset+2:], serverPort)
+	raw[ebpfFlowKeyFamilyOffset] = 2
+	raw[ebpfFlowKeyProtoOffset] = proto
+	binary.NativeEndian.PutUint32(raw[ebpfSniEventPayloadOffsetOffset:], uint32(packetHeaderLength))
+	binary.NativeEndian.PutUint16(raw[ebpfSniEventPayloadLengthOffset:], uint16(len(payload)))
+	raw = append(raw, make([]byte, packetHeaderLength)...)
+	raw = append(raw, payload...)
+	return append(raw, 0, 0, 0, 0)
+}
+
+func TestParseSniEvent(t *testing.T) {
+	payload := []byte{0x16, 0x03, 0x01}
+	raw := newTestSniEvent([4]byte{192, 168, 1, 10}, [4]byte{1, 1, 1, 1}, 50000, 443, model.ProtoTCP, 54, payload)
+
+	tuple, got, ok := parseSniEvent(raw)
+	assert.True(t, ok)
+	assert.Equal(t, flowTuple{
+		clientAddr: netip.MustParseAddr("192.168.1.10"),
+		serverAddr: netip.MustParseAddr("1.1.1.1"),
+		clientPort: 50000,
+		serverPort: 443,
+		proto:      model.ProtoTCP,
+	}, tuple)
+	assert.Equal(t, payload, got)
+
+	_, _, ok = parseSniEvent(raw[:ebpfSniEventHeaderSize+10])
+	assert.False(t, ok)
+	_, _, ok = parseSniEvent(raw[:10])
+	assert.False(t, ok)
+}
+
+func TestHandleClientHello(t *testing.T) {
+	svc := NewEbpfNetTrafficService(time.Minute, false, true)
+	client := netip.MustParseAddr("192.168.1.10")
+	server := netip.MustParseAddr("1.1.1.1")
+	tuple := flowTuple{client, server, 50000, 443, model.ProtoTCP}
+	now := time.Now()
+
+	svc.handleClientHello(tuple, snitest.ClientHello(t, "video.example.com"), now)
+
+	serverName, isUpload := svc.lookupServerName(client, 50000, server, 443, model.ProtoTCP, now)
+	assert.Equal(t, "video.example.com", serverName)
+	assert.True(t, isUpload)
+	serverName, isUpload = svc.lookupServerName(server, 443, client, 50000, model.ProtoTCP, now)
+	assert.Equal(t, "video.example.com", serverName)
+	assert.False(t, isUpload)
+
+	assert.Equal(t, "video.example.com", svc.LookupServerName("1.1.1.1", 443, "192.168.1.10", 50000, "tcp"))
+	assert.Equal(t, "", svc.LookupServerName("192.168.1.10", 50000, "1.1.1.1", 443, "udp"))
+
+	svc.cleanupExpiredServerNames(now.Add(EbpfSniMinKeepTime + time.Second))
+	assert.Equal(t, "", svc.LookupServerName("192.168.1.10", 50000, "1.1.1.1", 443, "tcp"))
+}
+
+func TestServiceAggregate(t *testing.T) {
+	svc := NewEbpfNetTrafficService(time.Minute, false, true)
+	client := netip.MustParseAddr("192.168.1.10")
+	svc.metricsMap[client] = &IPMetrics{}
+
+	svc.serviceAggregate(client, "video.example.com", 1000, 500, true)
+	svc.serviceAggregate(client, "video.example.com", 4000, 2000, false)
+	svc.serviceAggregate(client, "ignored.example.com", 0, 0, true)
+	assert.Len(t, svc.metricsMap[client].Services, 1)
+	service := svc.metricsMap[client].Services["video.example.com"]
+	assert.Equal(t, uint64(1000), service.TotalUpload)
+	assert.Equal(t, uint64(4000), service.TotalDownload)
+	assert.Equal(t, float64(2000), service.DownloadRate)
+
+	for i := range EbpfSniMaxServicesPerIp + 5 {
+		svc.serviceAggregate(client, "host"+string(rune('a'+i))+".example.com", 10, 10, true)
+	}
+	assert.Len(t, svc.metricsMap[client].Services, EbpfSniMaxServicesPerIp+1)
+	assert.NotNil(t, svc.metricsMap[client].Services[EbpfSniOtherServiceName])
+
+	details := formatServiceMetrics(svc.metricsMap[client].Services)
+	assert.Equal(t, "video.example.com", details[0].Name)
+}
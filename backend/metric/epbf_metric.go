@@ -3,18 +3,23 @@
 package metric
 
 import (
+	"cmp"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"net/netip"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"openwrt-diskio-api/backend/model"
 	bpf "openwrt-diskio-api/backend/pkg/ebpf"
+	"openwrt-diskio-api/backend/sni"
 	"openwrt-diskio-api/backend/utils"
 
 	"github.com/cilium/ebpf"
@@ -46,6 +51,8 @@ type IPMetrics struct {
 	Tcp           int32
 	Udp           int32
 	Other         int32
+	// 按 SNI 拆分的流量 , 只有开启 sni 采样且该 ip 是客户端时才有
+	Services map[string]*ServiceMetrics
 }
 
 type IpStatus struct {
@@ -74,15 +81,35 @@ type EbpfNetTrafficService struct {
 	captureStartAt      int64
 	lastFrameTime       time.Time
 	possibleCpuNumber   int
-}
-
-func NewEbpfNetTrafficService(keyExpiredTime time.Duration) *EbpfNetTrafficService {
+	dnsSnoopEnable      bool
+	dnsDomainMap        map[netip.Addr]snoopedDomain
+	dnsMutex            sync.RWMutex
+	sniEnable           bool
+	sniMap              map[flowTuple]*sniEntry
+	sniMutex            sync.RWMutex
+	quicAssembler       *sni.QuicAssembler
+	collectorStatus     collectorStatusRecorder
+	collectorTiming     collectorTimingRecorder
+	flowMapEntries      int64                    // 最近一帧遍历到的 flow_map 条目数
+	linkObserver        func(netlink.LinkUpdate) // 收到任何网卡的变化都会调用
+}
+
+func NewEbpfNetTrafficService(keyExpiredTime time.Duration, dnsSnoopEnable bool, sniEnable bool) *EbpfNetTrafficService {
 	return &EbpfNetTrafficService{
 		keyExpiredTime:    keyExpiredTime,
 		activeChan:        make(chan struct{}, 1),
 		metricsMap:        make(map[netip.Addr]*IPMetrics),
 		captureStartAt:    time.Now().UnixNano(),
 		possibleCpuNumber: runtime.NumCPU(),
+		dnsSnoopEnable:    dnsSnoopEnable,
+		dnsDomainMap:      make(map[netip.Addr]snoopedDomain),
+		sniEnable:         sniEnable,
+		sniMap:            make(map[flowTuple]*sniEntry),
+		quicAssembler: sni.NewQuicAssembler(
+			EbpfQuicMaxAssemblies,
+			EbpfQuicMaxAssemblyBytes,
+			EbpfQuicAssemblyTimeout,
+		),
 	}
 }
 
@@ -130,8 +157,11 @@ func (svc *EbpfNetTrafficService) InitEbpfInterfaceDevice(targetInterface string
 		log.Fatalf("Attach network interface %q failed: %s", targetInterface, err)
 	}
 	log.Printf("Capture traffic from interface %q now\n", targetInterface)
+	svc.collectorStatus.record(CollectorEbpfAttach, nil)
 
 	startCapture(&objs)
+	setDnsSnooping(&objs, svc.dnsSnoopEnable)
+	setSniSampling(&objs, svc.sniEnable)
 	svc.link = link
 	svc.objs = &objs
 
@@ -147,6 +177,7 @@ func (svc *EbpfNetTrafficService) frame(
 	}
 
 	now := time.Now()
+	defer svc.collectorTiming.observe(CollectorEbpfFrame, now)
 	// 计算采样间隔 dt
 	dt := now.Sub(svc.lastFrameTime).Seconds()
 	if dt <= 0 {
@@ -176,11 +207,17 @@ func (svc *EbpfNetTrafficService) frame(
 		m.Tcp = 0
 		m.Udp = 0
 		m.Other = 0
+		for _, service := range m.Services {
+			service.UploadRate = 0
+			service.DownloadRate = 0
+		}
 	}
 
 	// 2. 迭代 eBPF Map 进行采样
+	entries := 0
 	for {
 		count, err := objs.FlowMap.BatchLookup(&cursor, keys, vals, nil)
+		entries += count
 
 		for index := range count {
 			key := keys[index]
@@ -205,12 +242,24 @@ func (svc *EbpfNetTrafficService) frame(
 			rate := float64(delta) / dt
 
 			svc.trafficAggregateWithDuration(srcAddr, dstAddr, delta, rate, key.Proto)
+
+			if svc.sniEnable && delta > 0 {
+				serverName, isUpload := svc.lookupServerName(srcAddr, key.SrcPort, dstAddr, key.DstPort, key.Proto, now)
+				if serverName != "" && isUpload {
+					svc.serviceAggregate(srcAddr, serverName, delta, rate, true)
+				} else if serverName != "" {
+					svc.serviceAggregate(dstAddr, serverName, delta, rate, false)
+				}
+			}
 		}
 		if err != nil || count < batchSize {
+			svc.recordBatchLookupResult(err)
 			break
 		}
 	}
 
+	atomic.StoreInt64(&svc.flowMapEntries, int64(entries))
+
 	// 3. 更新时间轴并应用平滑
 	svc.lastFrameTime = now
 	svc.applySmoothing()
@@ -218,6 +267,15 @@ func (svc *EbpfNetTrafficService) frame(
 	svc.mutex.Unlock()
 }
 
+// 遍历到结尾时返回的是 ErrKeyNotExist , 不算错误
+func (svc *EbpfNetTrafficService) recordBatchLookupResult(err error) {
+	if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		svc.collectorStatus.record(CollectorEbpfFlowMap, fmt.Errorf("batch lookup: %w", err))
+		return
+	}
+	svc.collectorStatus.record(CollectorEbpfFlowMap, nil)
+}
+
 func (svc *EbpfNetTrafficService) trafficAggregateWithDuration(srcAddr netip.Addr, dstAddr netip.Addr, delta uint64, rate float64, proto uint8) {
 	// 统计上传
 	if !IsIgnoredAddr(srcAddr) {
@@ -256,7 +314,14 @@ func (svc *EbpfNetTrafficService) Run(ctx context.Context) {
 		log.Fatalln(err)
 	}
 	defer close(done)
+	svc.collectorStatus.record(CollectorNetlinkSubscribe, nil)
 	go svc.WatchNetworkChanges(ctx, addrChan, linkChan)
+	if svc.dnsSnoopEnable {
+		go svc.runDnsSnooping(ctx)
+	}
+	if svc.sniEnable {
+		go svc.runSniSampling(ctx)
+	}
 
 	objs := svc.objs
 	ticker := time.NewTicker(1 * time.Second)
@@ -289,6 +354,12 @@ func (svc *EbpfNetTrafficService) Run(ctx context.Context) {
 		case <-gcTicker.C:
 			// 异步执行清理
 			go svc.cleanupExpiredFlows(objs, keyExpiredTime, lastSnapshots)
+			if svc.dnsSnoopEnable {
+				svc.cleanupExpiredDomains(time.Now())
+			}
+			if svc.sniEnable {
+				svc.cleanupExpiredServerNames(time.Now())
+			}
 		}
 	}
 }
@@ -296,6 +367,7 @@ func (svc *EbpfNetTrafficService) Run(ctx context.Context) {
 func (svc *EbpfNetTrafficService) shutdownCapture(objs *bpf.BpfObjects, lastSnapshots map[bpf.BpfFlowKey]uint64) {
 	stopCapture(objs)
 	clearFlowMap(objs.FlowMap, svc.possibleCpuNumber)
+	atomic.StoreInt64(&svc.flowMapEntries, 0)
 	svc.mutex.Lock()
 	clear(svc.metricsMap)
 	svc.mutex.Unlock()
@@ -379,8 +451,15 @@ func (svc *EbpfNetTrafficService) GetAggregationTrafficMetric() *model.Aggregati
 			ipFamily = model.IpFamilyTypeIpv6
 		}
 
+		domain := ""
+		if svc.dnsSnoopEnable {
+			domain = svc.lookupSnoopedDomain(ip)
+		}
+
 		result.Details = append(result.Details, model.AggregationTrafficDetails{
 			Ip:              ipStr,
+			Domain:          domain,
+			Services:        formatServiceMetrics(value.Services),
 			IpType:          IpType,
 			IpFamily:        ipFamily,
 			Incoming:        incoming,
@@ -399,33 +478,73 @@ func (svc *EbpfNetTrafficService) GetAggregationTrafficMetric() *model.Aggregati
 
 // 在 frame 函数末尾，BatchLookup 循环结束后执行：
 func (svc *EbpfNetTrafficService) applySmoothing() {
+	for _, m := range svc.metricsMap {
+		m.SmoothUploadRate = smoothRate(m.SmoothUploadRate, m.UploadRate)
+		m.SmoothDownloadRate = smoothRate(m.SmoothDownloadRate, m.DownloadRate)
+		for _, service := range m.Services {
+			service.SmoothUploadRate = smoothRate(service.SmoothUploadRate, service.UploadRate)
+			service.SmoothDownloadRate = smoothRate(service.SmoothDownloadRate, service.DownloadRate)
+		}
+	}
+}
+
+func smoothRate(smooth float64, rate float64) float64 {
 	// 建议 Alpha 设为 0.3 - 0.5 之间
 	// 0.3 极其平滑，但有 1-2 秒延迟；0.5 反应快，但仍有轻微跳动
 	const alpha = SmoothingAlphaRate
 
-	for _, m := range svc.metricsMap {
-		// 对上传速率进行平滑
-		if m.SmoothUploadRate == 0 {
-			m.SmoothUploadRate = m.UploadRate
-		} else {
-			m.SmoothUploadRate = (alpha * m.UploadRate) + ((1 - alpha) * m.SmoothUploadRate)
-		}
+	if smooth == 0 {
+		smooth = rate
+	} else {
+		smooth = (alpha * rate) + ((1 - alpha) * smooth)
+	}
 
-		// 对下载速率进行平滑
-		if m.SmoothDownloadRate == 0 {
-			m.SmoothDownloadRate = m.DownloadRate
-		} else {
-			m.SmoothDownloadRate = (alpha * m.DownloadRate) + ((1 - alpha) * m.SmoothDownloadRate)
-		}
+	// 补偿：如果平滑后的值极小（比如小于 1B/s），直接归零，防止 UI 长期显示微小余波
+	if smooth < 1 {
+		smooth = 0
+	}
+	return smooth
+}
 
-		// 补偿：如果平滑后的值极小（比如小于 1B/s），直接归零，防止 UI 长期显示微小余波
-		if m.SmoothUploadRate < 1 {
-			m.SmoothUploadRate = 0
-		}
-		if m.SmoothDownloadRate < 1 {
-			m.SmoothDownloadRate = 0
+// 按总流量从大到小排序
+func formatServiceMetrics(services map[string]*ServiceMetrics) []model.AggregationServiceTraffic {
+	if len(services) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		totalA := services[a].TotalUpload + services[a].TotalDownload
+		totalB := services[b].TotalUpload + services[b].TotalDownload
+		if totalA != totalB {
+			return cmp.Compare(totalB, totalA)
 		}
+		return strings.Compare(a, b)
+	})
+
+	result := make([]model.AggregationServiceTraffic, 0, len(names))
+	for _, name := range names {
+		service := services[name]
+		rate, unit := utils.ConvertBytes(service.SmoothDownloadRate, model.BSecond)
+		incoming := model.MetricUnit{Value: rate, Unit: unit}
+		rate, unit = utils.ConvertBytes(service.SmoothUploadRate, model.BSecond)
+		outgoing := model.MetricUnit{Value: rate, Unit: unit}
+		total, unit := utils.ConvertBytes(float64(service.TotalDownload), model.Byte)
+		totalIncoming := model.MetricUnit{Value: total, Unit: unit}
+		total, unit = utils.ConvertBytes(float64(service.TotalUpload), model.Byte)
+		totalOutgoing := model.MetricUnit{Value: total, Unit: unit}
+
+		result = append(result, model.AggregationServiceTraffic{
+			Name:          name,
+			Incoming:      incoming,
+			Outgoing:      outgoing,
+			TotalIncoming: totalIncoming,
+			TotalOutgoing: totalOutgoing,
+		})
 	}
+	return result
 }
 
 func (svc *EbpfNetTrafficService) parseToAddr(addr [4]uint32, family uint8) netip.Addr {
@@ -449,6 +568,7 @@ func (svc *EbpfNetTrafficService) cleanupExpiredFlows(
 	timeout time.Duration,
 	lastSnapshots map[bpf.BpfFlowKey]uint64,
 ) {
+	defer svc.collectorTiming.observe(CollectorEbpfCleanupFlows, time.Now())
 	nowKtime := getKtimeNS()
 	timeoutNS := uint64(timeout.Nanoseconds())
 
@@ -490,6 +610,7 @@ func (svc *EbpfNetTrafficService) cleanupExpiredFlows(
 		_, err := objs.FlowMap.BatchDelete(keysToDelete, nil)
 		if err != nil {
 			log.Println("Batch delete ebpf flow map failed:", err)
+			svc.collectorStatus.record(CollectorEbpfFlowMap, fmt.Errorf("batch delete: %w", err))
 		}
 
 		// 2. 从 Go 内存快照删除 (由于 lastSnapshots 不是并发安全的，需要加锁或交给下一帧处理)
@@ -556,6 +677,7 @@ func (svc *EbpfNetTrafficService) WatchNetworkChanges(ctx context.Context, addrC
 		case signal, ok := <-addrChan:
 			if !ok {
 				log.Println("Netlink address update channel closed")
+				svc.collectorStatus.record(CollectorNetlinkSubscribe, errors.New("netlink address update channel closed"))
 				return
 			}
 			link, _ := netlink.LinkByIndex(signal.LinkIndex)
@@ -567,8 +689,12 @@ func (svc *EbpfNetTrafficService) WatchNetworkChanges(ctx context.Context, addrC
 		case signal, ok := <-linkChan:
 			if !ok {
 				log.Println("Netlink device update channel closed")
+				svc.collectorStatus.record(CollectorNetlinkSubscribe, errors.New("netlink device update channel closed"))
 				return
 			}
+			if svc.linkObserver != nil {
+				svc.linkObserver(signal)
+			}
 			// 网卡状态变了 (重点解决 eBPF 失效)
 			if signal.Attrs().Name != svc.captureInterface {
 				continue
@@ -603,7 +729,10 @@ func (svc *EbpfNetTrafficService) WatchNetworkChanges(ctx context.Context, addrC
 			err := attachTCObjects(targetLink, svc.objs.CountFlow.FD())
 			if err != nil {
 				log.Printf("Ebpf re-attach failed: %v", err)
+				svc.collectorStatus.record(CollectorEbpfAttach, fmt.Errorf("re-attach to %q: %w", svc.captureInterface, err))
+				continue
 			}
+			svc.collectorStatus.record(CollectorEbpfAttach, nil)
 
 			svc.mutex.Lock()
 			svc.link = targetLink
@@ -744,7 +873,7 @@ func cleanUpTC(link netlink.Link) {
 
 func startCapture(objs *bpf.BpfObjects) {
 	log.Println("Enable ebpf network traffic capture")
-	key := uint32(0)
+	key := EbpfConfigKeyCapture
 	val := uint32(1)
 	_ = objs.ConfigMap.Update(&key, &val, ebpf.UpdateAny)
 }
@@ -754,13 +883,13 @@ func stopCapture(objs *bpf.BpfObjects) {
 		return
 	}
 	log.Println("Disable ebpf network traffic capture")
-	key := uint32(0)
+	key := EbpfConfigKeyCapture
 	val := uint32(0)
 	_ = objs.ConfigMap.Update(&key, &val, ebpf.UpdateAny)
 }
 
 func isCapturing(objs *bpf.BpfObjects) bool {
-	key := uint32(0)
+	key := EbpfConfigKeyCapture
 	val := uint32(0)
 
 	err := objs.ConfigMap.Lookup(&key, &val)
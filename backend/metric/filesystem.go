@@ -0,0 +1,220 @@
+//go:build linux
+
+package metric
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/utils"
+)
+
+var (
+	// 内核的虚拟文件系统 , 没有容量可言
+	pseudoFsTypeList = []string{
+		"proc", "sysfs", "devtmpfs", "devpts", "cgroup", "cgroup2", "debugfs", "tracefs",
+		"securityfs", "pstore", "bpf", "mqueue", "configfs", "fusectl", "hugetlbfs",
+		"efivarfs", "selinuxfs", "autofs", "binfmt_misc", "nsfs", "rpc_pipefs",
+	}
+	// 网络文件系统的服务端没有响应时 statfs 会一直阻塞 , fuse 的用户态进程卡住时也一样 , fuse 在 skipFsType 里按前缀判断
+	remoteFsTypeList = []string{
+		"nfs", "nfs4", "cifs", "smb3", "smbfs", "9p", "ceph", "glusterfs", "afs", "davfs", "ncpfs", "lustre",
+	}
+	ubiVolumePattern = regexp.MustCompile(`^ubi\d+_\d+$`)
+)
+
+type mountEntry struct {
+	source     string
+	mountPoint string
+	fsType     string
+	options    []string
+}
+
+func (m mountEntry) option(name string) (string, bool) {
+	for _, option := range m.options {
+		key, value, _ := strings.Cut(option, "=")
+		if key == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// unescapeMountField /proc/mounts 里的空格 , tab , 换行和反斜杠会转义成 \040 这样的八进制
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var builder strings.Builder
+	for index := 0; index < len(field); index++ {
+		if field[index] == '\\' && index+3 < len(field) {
+			if value, err := strconv.ParseUint(field[index+1:index+4], 8, 8); err == nil {
+				builder.WriteByte(byte(value))
+				index += 3
+				continue
+			}
+		}
+		builder.WriteByte(field[index])
+	}
+	return builder.String()
+}
+
+// parseMounts 解析 /proc/mounts :
+// ubi0:rootfs_data /overlay ubifs rw,noatime,assert=read-only,ubi=0,vol=1 0 0
+func parseMounts(raw string) []mountEntry {
+	var result []mountEntry
+	for _, line := range strings.Split(raw, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		result = append(result, mountEntry{
+			source:     unescapeMountField(fields[0]),
+			mountPoint: unescapeMountField(fields[1]),
+			fsType:     fields[2],
+			options:    strings.Split(fields[3], ","),
+		})
+	}
+	return result
+}
+
+// ubiVolumeName 根据挂载的来源找到 ubi 卷 , 支持 ubi0_1 , /dev/ubi0_1 , /dev/ubiblock0_1 , ubi0:rootfs_data 和 ubi:rootfs_data
+func ubiVolumeName(reader FsReaderInterface, source string) string {
+	source = strings.TrimPrefix(source, "/dev/")
+	if strings.HasPrefix(source, "ubiblock") {
+		source = "ubi" + strings.TrimPrefix(source, "ubiblock")
+	}
+	if ubiVolumePattern.MatchString(source) {
+		return source
+	}
+	device, name, found := strings.Cut(source, ":")
+	if !found || !strings.HasPrefix(device, "ubi") {
+		return ""
+	}
+	volumes, _ := reader.ReadDir(procPaths.UbiDir())
+	slices.SortFunc(volumes, compareNumbered)
+	for _, volume := range volumes {
+		// 没有写设备号时在所有 ubi 设备里找
+		if !ubiVolumePattern.MatchString(volume) || (device != "ubi" && !strings.HasPrefix(volume, device+"_")) {
+			continue
+		}
+		if readSysfsString(reader, path.Join(procPaths.UbiDir(), volume, "name")) == name {
+			return volume
+		}
+	}
+	return ""
+}
+
+func resolveUbiVolume(reader FsReaderInterface, source string) *model.UbiVolume {
+	volume := ubiVolumeName(reader, source)
+	if volume == "" {
+		return nil
+	}
+	name := readSysfsString(reader, path.Join(procPaths.UbiDir(), volume, "name"))
+	if name == "" {
+		return nil
+	}
+	result := &model.UbiVolume{Volume: volume, Name: name}
+	device, _, _ := strings.Cut(volume, "_")
+	if mtdNum := readSysfsString(reader, path.Join(procPaths.UbiDir(), device, "mtd_num")); mtdNum != "" {
+		result.Mtd = readSysfsString(reader, path.Join(procPaths.MtdDir(), "mtd"+mtdNum, "name"))
+	}
+	return result
+}
+
+// newFilesystemUsage 和 df 的算法一样 , 已用的按 total - free , 百分比的分母是 used + available
+func newFilesystemUsage(entry mountEntry, stat syscall.Statfs_t) model.FilesystemUsage {
+	blockSize := uint64(stat.Bsize)
+	total := stat.Blocks * blockSize
+	used := (stat.Blocks - stat.Bfree) * blockSize
+	available := stat.Bavail * blockSize
+	usedPercent := 0.0
+	if used+available > 0 {
+		usedPercent = float64(used) / float64(used+available) * 100
+	}
+	convertTotal, totalUnit := utils.ConvertBytes(float64(total), model.Byte)
+	convertUsed, usedUnit := utils.ConvertBytes(float64(used), model.Byte)
+	convertAvailable, availableUnit := utils.ConvertBytes(float64(available), model.Byte)
+
+	inodes := model.InodeUsage{Total: stat.Files}
+	if stat.Files >= stat.Ffree {
+		inodes.Used = stat.Files - stat.Ffree
+	}
+	if inodes.Total > 0 {
+		inodes.UsedPercent = float64(inodes.Used) / float64(inodes.Total) * 100
+	}
+	return model.FilesystemUsage{
+		Source:      entry.source,
+		FsType:      entry.fsType,
+		ReadOnly:    slices.Contains(entry.options, "ro"),
+		Total:       model.MetricUnit{Value: convertTotal, Unit: totalUnit},
+		Used:        model.MetricUnit{Value: convertUsed, Unit: usedUnit},
+		Available:   model.MetricUnit{Value: convertAvailable, Unit: availableUnit},
+		UsedPercent: model.MetricUnit{Value: usedPercent, Unit: model.Percent},
+		Inodes:      inodes,
+	}
+}
+
+// backingMountPoint 找到 dir 所在的挂载点 , 也就是最长的前缀 , exclude 是 overlay 自己
+func backingMountPoint(metric model.FilesystemMetric, dir string, exclude string) string {
+	result := ""
+	for mountPoint := range metric {
+		if mountPoint == exclude {
+			continue
+		}
+		prefix := strings.TrimSuffix(mountPoint, "/") + "/"
+		if (dir == mountPoint || strings.HasPrefix(dir, prefix)) && len(mountPoint) > len(result) {
+			result = mountPoint
+		}
+	}
+	return result
+}
+
+func skipFsType(fsType string) bool {
+	return slices.Contains(pseudoFsTypeList, fsType) || slices.Contains(remoteFsTypeList, fsType) ||
+		fsType == "fuse" || strings.HasPrefix(fsType, "fuse.") || fsType == "fuseblk"
+}
+
+// ReadFilesystemMetric 读取所有挂载点的容量 , 跳过内核的虚拟文件系统 , 网络文件系统 , fuse 和容量为 0 的挂载
+func ReadFilesystemMetric(reader FsReaderInterface) model.FilesystemMetric {
+	result := model.FilesystemMetric{}
+	raw, err := reader.ReadFile(procPaths.StorageDeviceMounts())
+	if err != nil {
+		return result
+	}
+	upperDirs := map[string]string{}
+	for _, entry := range parseMounts(raw) {
+		if skipFsType(entry.fsType) {
+			continue
+		}
+		stat, err := getStatfs(entry.mountPoint)
+		if err != nil || stat.Blocks == 0 {
+			continue
+		}
+		usage := newFilesystemUsage(entry, stat)
+		usage.UbiVolume = resolveUbiVolume(reader, entry.source)
+		// 后挂载的会盖住先挂载的 , 比如 / 上的 rootfs 和 overlay
+		result[entry.mountPoint] = usage
+		delete(upperDirs, entry.mountPoint)
+		if upperDir, ok := entry.option("upperdir"); ok && entry.fsType == "overlay" {
+			upperDirs[entry.mountPoint] = upperDir
+		}
+	}
+
+	// overlay 的数据写在 upperdir 里 , 用 upperdir 所在的挂载找 ubi 卷
+	for mountPoint, upperDir := range upperDirs {
+		backing := backingMountPoint(result, upperDir, mountPoint)
+		if backing == "" {
+			continue
+		}
+		usage := result[mountPoint]
+		usage.UbiVolume = result[backing].UbiVolume
+		result[mountPoint] = usage
+	}
+	return result
+}
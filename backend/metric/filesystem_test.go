@@ -0,0 +1,75 @@
+//go:build linux
+
+package metric
+
+import (
+	"syscall"
+	"testing"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMounts(t *testing.T) {
+	mounts := parseMounts(`/dev/root /rom squashfs ro,relatime,errors=continue 0 0
+ubi0:rootfs_data /overlay ubifs rw,noatime,assert=read-only,ubi=0,vol=1 0 0
+overlayfs:/overlay / overlay rw,noatime,lowerdir=/,upperdir=/overlay/upper,workdir=/overlay/work 0 0
+/dev/sda1 /mnt/usb\040disk vfat rw,relatime 0 0
+`)
+	assert.Len(t, mounts, 4)
+	assert.Equal(t, "ubi0:rootfs_data", mounts[1].source)
+	upperDir, ok := mounts[2].option("upperdir")
+	assert.True(t, ok)
+	assert.Equal(t, "/overlay/upper", upperDir)
+	assert.Equal(t, "/mnt/usb disk", mounts[3].mountPoint)
+}
+
+func TestResolveUbiVolume(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+	_ = afero.WriteFile(fs, "/sys/class/ubi/ubi0_0/name", []byte("rootfs\n"), 0o644)
+	_ = afero.WriteFile(fs, "/sys/class/ubi/ubi0_1/name", []byte("rootfs_data\n"), 0o644)
+	_ = afero.WriteFile(fs, "/sys/class/ubi/ubi0/mtd_num", []byte("5\n"), 0o644)
+	_ = afero.WriteFile(fs, "/sys/class/mtd/mtd5/name", []byte("ubi\n"), 0o644)
+
+	expected := &model.UbiVolume{Volume: "ubi0_1", Name: "rootfs_data", Mtd: "ubi"}
+	assert.Equal(t, expected, resolveUbiVolume(reader, "ubi0:rootfs_data"))
+	assert.Equal(t, expected, resolveUbiVolume(reader, "ubi:rootfs_data"))
+	assert.Equal(t, expected, resolveUbiVolume(reader, "/dev/ubi0_1"))
+	assert.Equal(t, "rootfs", resolveUbiVolume(reader, "/dev/ubiblock0_0").Name)
+	assert.Nil(t, resolveUbiVolume(reader, "ubi1:rootfs_data"))
+	assert.Nil(t, resolveUbiVolume(reader, "tmpfs"))
+	assert.Nil(t, resolveUbiVolume(reader, "/dev/sda1"))
+}
+
+func TestNewFilesystemUsage(t *testing.T) {
+	entry := mountEntry{source: "tmpfs", mountPoint: "/tmp", fsType: "tmpfs", options: []string{"ro", "nosuid"}}
+	stat := syscall.Statfs_t{Bsize: 4096, Blocks: 1000, Bfree: 300, Bavail: 200, Files: 500, Ffree: 400}
+	usage := newFilesystemUsage(entry, stat)
+	assert.True(t, usage.ReadOnly)
+	assert.Equal(t, "tmpfs", usage.FsType)
+	assert.InDelta(t, 77.78, usage.UsedPercent.Value, 0.01)
+	assert.Equal(t, model.InodeUsage{Total: 500, Used: 100, UsedPercent: 20}, usage.Inodes)
+
+	// ubifs 不统计 inode
+	usage = newFilesystemUsage(entry, syscall.Statfs_t{Bsize: 4096, Blocks: 1000, Bfree: 1000, Bavail: 900})
+	assert.Equal(t, model.InodeUsage{}, usage.Inodes)
+}
+
+func TestBackingMountPoint(t *testing.T) {
+	metric := model.FilesystemMetric{"/": {}, "/overlay": {}, "/rom": {}, "/overlayfoo": {}}
+	assert.Equal(t, "/overlay", backingMountPoint(metric, "/overlay/upper", "/"))
+	assert.Equal(t, "", backingMountPoint(metric, "/data/upper", "/"))
+	assert.Equal(t, "/", backingMountPoint(metric, "/data/upper", "/overlay"))
+}
+
+func TestSkipFsType(t *testing.T) {
+	for _, fsType := range []string{"proc", "nfs4", "cifs", "fuse", "fuseblk", "fuse.sshfs"} {
+		assert.True(t, skipFsType(fsType), fsType)
+	}
+	for _, fsType := range []string{"ubifs", "overlay", "ext4", "tmpfs", "vfat"} {
+		assert.False(t, skipFsType(fsType), fsType)
+	}
+}
@@ -0,0 +1,176 @@
+//go:build linux
+
+package metric
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	CollectorStaticMetric       = "static_metric"
+	CollectorDynamicMetric      = "dynamic_metric"
+	CollectorNetworkConnection  = "network_connection"
+	CollectorAggregationTraffic = "aggregation_traffic"
+	CollectorEbpfAttach         = "ebpf_attach"
+	CollectorEbpfFlowMap        = "ebpf_flow_map"
+	CollectorNetlinkSubscribe   = "netlink_subscribe"
+	CollectorDnsSnooping        = "dns_snooping"
+	CollectorSniSampling        = "sni_sampling"
+)
+
+// collectorStatusRecorder 零值可用
+type collectorStatusRecorder struct {
+	mutex    sync.Mutex
+	statuses map[string]*model.CollectorStatus
+}
+
+// record err 为 nil 时记录一次成功
+func (r *collectorStatusRecorder) record(name string, err error) {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.statuses == nil {
+		r.statuses = make(map[string]*model.CollectorStatus)
+	}
+	status, ok := r.statuses[name]
+	if !ok {
+		status = &model.CollectorStatus{Name: name}
+		r.statuses[name] = status
+	}
+	if err != nil {
+		status.Healthy = false
+		status.LastError = err.Error()
+		status.LastFailureAt = now
+		return
+	}
+	status.Healthy = true
+	status.LastSuccessAt = now
+}
+
+func (r *collectorStatusRecorder) list() []model.CollectorStatus {
+	r.mutex.Lock()
+	result := make([]model.CollectorStatus, 0, len(r.statuses))
+	for _, status := range r.statuses {
+		result = append(result, *status)
+	}
+	r.mutex.Unlock()
+	return result
+}
+
+// Status 返回 ebpf 程序的加载和挂载状态 , 挂载状态直接从内核查询 ,
+// 这样网卡抖动后 tc 过滤器被悄悄删掉也能发现
+func (svc *EbpfNetTrafficService) Status() model.EbpfStatus {
+	svc.mutex.RLock()
+	link := svc.link
+	svc.mutex.RUnlock()
+
+	result := model.EbpfStatus{
+		Loaded:      svc.objs != nil,
+		Interface:   svc.captureInterface,
+		DnsSnooping: svc.dnsSnoopEnable,
+		SniCapture:  svc.sniEnable,
+	}
+	if !result.Loaded {
+		return result
+	}
+	result.IsCapturing = isCapturing(svc.objs)
+	if result.IsCapturing {
+		result.CaptureStartAt = time.Unix(0, atomic.LoadInt64(&svc.captureStartAt))
+	}
+
+	// 网卡重建后 index 会变 , 按名字重新查
+	current, err := netlink.LinkByName(svc.captureInterface)
+	if err != nil {
+		return result
+	}
+	if link != nil && link.Attrs().Index != current.Attrs().Index {
+		return result
+	}
+	result.InterfaceIndex = current.Attrs().Index
+	programId := 0
+	if info, err := svc.objs.CountFlow.Info(); err == nil {
+		if id, ok := info.ID(); ok {
+			programId = int(id)
+		}
+	}
+	result.AttachedIngress = hasBpfFilter(current, netlink.HANDLE_MIN_INGRESS, programId)
+	result.AttachedEgress = hasBpfFilter(current, netlink.HANDLE_MIN_EGRESS, programId)
+	result.Attached = result.AttachedIngress && result.AttachedEgress
+	return result
+}
+
+// programId 为 0 时只要是 direct-action 的 bpf 过滤器就算
+func hasBpfFilter(link netlink.Link, parent uint32, programId int) bool {
+	filters, err := netlink.FilterList(link, parent)
+	if err != nil {
+		return false
+	}
+	for _, filter := range filters {
+		bpfFilter, ok := filter.(*netlink.BpfFilter)
+		if !ok || !bpfFilter.DirectAction {
+			continue
+		}
+		if programId == 0 || bpfFilter.Id == programId {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BackgroundService) EbpfStatus() model.EbpfStatus {
+	svc := b.ebpfService.Load()
+	if svc == nil {
+		return model.EbpfStatus{
+			Starting:    true,
+			Interface:   b.TrafficCaptureInterfaceName,
+			DnsSnooping: b.DnsSnoopingEnable,
+			SniCapture:  b.SniCaptureEnable,
+		}
+	}
+	return svc.Status()
+}
+
+func (b *BackgroundService) JsonCacheStatus() []model.JsonCacheStatus {
+	now := time.Now().UTC()
+	var result []model.JsonCacheStatus
+	b.jsonCache.Range(func(rawKey, rawCache any) bool {
+		key, _ := rawKey.(string)
+		cache, ok := rawCache.(model.CacheValue)
+		if !ok {
+			return true
+		}
+		_, updating := b.updatingStatusMap.Load(key)
+		result = append(result, model.JsonCacheStatus{
+			Key:        key,
+			UpdateAt:   cache.UpdateAt,
+			ExpireAt:   cache.ExpireAt,
+			AgeSeconds: now.Sub(cache.UpdateAt).Seconds(),
+			Expired:    now.After(cache.ExpireAt),
+			Updating:   updating,
+		})
+		return true
+	})
+	slices.SortFunc(result, func(a, b model.JsonCacheStatus) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return result
+}
+
+func (b *BackgroundService) CollectorStatus() []model.CollectorStatus {
+	result := b.collectorStatus.list()
+	if svc := b.ebpfService.Load(); svc != nil {
+		result = append(result, svc.collectorStatus.list()...)
+	}
+	slices.SortFunc(result, func(a, b model.CollectorStatus) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return result
+}
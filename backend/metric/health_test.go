@@ -0,0 +1,96 @@
+//go:build linux
+
+package metric
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectorStatusRecorder(t *testing.T) {
+	b := &BackgroundService{}
+	b.collectorStatus.record(CollectorStaticMetric, nil)
+	b.collectorStatus.record(CollectorNetworkConnection, errors.New("open /proc/net/nf_conntrack: no such file or directory"))
+
+	statuses := b.CollectorStatus()
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, CollectorNetworkConnection, statuses[0].Name)
+	assert.False(t, statuses[0].Healthy)
+	assert.Equal(t, "open /proc/net/nf_conntrack: no such file or directory", statuses[0].LastError)
+	assert.True(t, statuses[0].LastSuccessAt.IsZero())
+	assert.True(t, statuses[1].Healthy)
+
+	// 恢复后保留最后一次错误方便排查
+	b.collectorStatus.record(CollectorNetworkConnection, nil)
+	status := b.CollectorStatus()[0]
+	assert.True(t, status.Healthy)
+	assert.NotEmpty(t, status.LastError)
+	assert.False(t, status.LastSuccessAt.Before(status.LastFailureAt))
+}
+
+func TestJsonCacheStatus(t *testing.T) {
+	b := &BackgroundService{}
+	b.setJsonBytes(model.JsonCacheKeyStaticMetric, time.Minute, []byte("{}"))
+	b.setJsonBytes(model.JsonCacheKeyDynamicMetric, -time.Second, []byte("{}"))
+	b.updatingStatusMap.Store(model.JsonCacheKeyDynamicMetric, true)
+
+	statuses := b.JsonCacheStatus()
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, model.JsonCacheKeyDynamicMetric, statuses[0].Key)
+	assert.True(t, statuses[0].Expired)
+	assert.True(t, statuses[0].Updating)
+	assert.Equal(t, model.JsonCacheKeyStaticMetric, statuses[1].Key)
+	assert.False(t, statuses[1].Expired)
+	assert.GreaterOrEqual(t, statuses[1].AgeSeconds, 0.0)
+}
+
+func TestEbpfStatusNotLoaded(t *testing.T) {
+	b := &BackgroundService{TrafficCaptureInterfaceName: "br-lan"}
+	status := b.EbpfStatus()
+	assert.Equal(t, model.EbpfStatus{Starting: true, Interface: "br-lan"}, status)
+
+	// 刚启动时还在加载 , 不算不健康
+	startedAt := time.Now()
+	serviceStatus := model.ServiceStatus{
+		Ebpf:        status,
+		StartedAt:   startedAt,
+		GeneratedAt: startedAt.Add(10 * time.Second),
+		Neighbor:    model.NeighborServiceStatus{Healthy: true},
+		Dns:         model.DnsResolverStatus{Healthy: true},
+	}
+	serviceStatus.CheckProblems()
+	assert.True(t, serviceStatus.Healthy)
+	assert.True(t, serviceStatus.Starting)
+
+	serviceStatus.GeneratedAt = startedAt.Add(model.EbpfStartupGracePeriod)
+	serviceStatus.CheckProblems()
+	assert.False(t, serviceStatus.Healthy)
+	assert.False(t, serviceStatus.Starting)
+	assert.Contains(t, serviceStatus.Problems, "ebpf program is still not loaded after 2m0s")
+
+	serviceStatus.Ebpf = model.EbpfStatus{Interface: "br-lan"}
+	serviceStatus.CheckProblems()
+	assert.Equal(t, []string{"ebpf program is not loaded"}, serviceStatus.Problems)
+}
+
+func TestCollectorFailure(t *testing.T) {
+	b := &BackgroundService{}
+	_, failed := b.CollectorFailure(model.JsonCacheKeyNetworkConnectionMetric)
+	assert.False(t, failed)
+
+	b.collectorStatus.record(CollectorNetworkConnection, errors.New("permission denied"))
+	b.collectorStatus.record(CollectorStaticMetric, nil)
+	failure, failed := b.CollectorFailure(model.JsonCacheKeyNetworkConnectionMetric)
+	assert.True(t, failed)
+	assert.Equal(t, "permission denied", failure.LastError)
+	_, failed = b.CollectorFailure(model.JsonCacheKeyStaticMetric)
+	assert.False(t, failed)
+
+	_, ok := b.GetJsonCache(model.JsonCacheKeyNetworkConnectionMetric)
+	assert.False(t, ok)
+}
@@ -0,0 +1,184 @@
+//go:build linux
+
+package metric
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/utils"
+)
+
+// parseLoadAverage 解析 /proc/loadavg :
+// 0.08 0.03 0.01 1/123 4567
+func parseLoadAverage(raw string) model.LoadAverage {
+	result := model.LoadAverage{}
+	fields := strings.Fields(raw)
+	if len(fields) < 4 {
+		return result
+	}
+	result.Load1 = utils.TryFloat64(fields[0])
+	result.Load5 = utils.TryFloat64(fields[1])
+	result.Load15 = utils.TryFloat64(fields[2])
+	running, total, _ := strings.Cut(fields[3], "/")
+	result.RunningTasks = utils.TryInt(running)
+	result.TotalTasks = utils.TryInt(total)
+	return result
+}
+
+// parseProcStatCounter 读取 /proc/stat 里 ctxt 和 processes 两行 , processes 是开机以来 fork 的次数
+func parseProcStatCounter(raw string) (contextSwitches uint64, forks uint64) {
+	for _, line := range strings.Split(raw, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "ctxt":
+			contextSwitches, _ = strconv.ParseUint(fields[1], 10, 64)
+		case "processes":
+			forks, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return contextSwitches, forks
+}
+
+type irqCounter struct {
+	name        string
+	description string
+	counts      []uint64 // 下标是核心编号
+}
+
+// parseIrqTable 解析 /proc/interrupts 和 /proc/softirqs , 第一行是核心列表 , 之后每行是名字和每个核心的计数 ,
+// /proc/interrupts 在计数之后还有中断控制器和设备的描述 , ERR 和 MIS 这样的行只有一个计数 :
+//
+//	           CPU0       CPU1
+//	 16:      12345      23456     GICv2  30 Level     arch_timer
+//	ERR:          0
+func parseIrqTable(raw string) []irqCounter {
+	lines := strings.Split(raw, "\n")
+	if len(lines) == 0 {
+		return nil
+	}
+	coreCount := len(strings.Fields(lines[0]))
+	var result []irqCounter
+	for _, line := range lines[1:] {
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		counter := irqCounter{name: strings.TrimSpace(name)}
+		index := 0
+		for ; index < len(fields) && index < coreCount; index++ {
+			count, err := strconv.ParseUint(fields[index], 10, 64)
+			if err != nil {
+				break
+			}
+			counter.counts = append(counter.counts, count)
+		}
+		counter.description = strings.Join(fields[index:], " ")
+		result = append(result, counter)
+	}
+	return result
+}
+
+// irqRates 计算每个中断总的和每个核心的速率 , 同时把这次的计数记录到 snap 里 .
+// 每个核心的计数是 32 位的 , 要分别处理溢出 , 所以总的速率是各核心速率的和
+func irqRates(counters []irqCounter, snap map[string][]uint64, hasLastSample bool, elapsed time.Duration) (rates []float64, coresRates [][]float64) {
+	for _, counter := range counters {
+		last, hasLast := snap[counter.name]
+		hasLast = hasLast && hasLastSample && len(last) == len(counter.counts)
+		rate := 0.0
+		coresRate := make([]float64, len(counter.counts))
+		for index, count := range counter.counts {
+			if hasLast {
+				coresRate[index] = counterRate(count, last[index], true, elapsed)
+				rate += coresRate[index]
+			}
+		}
+		rates = append(rates, rate)
+		coresRates = append(coresRates, coresRate)
+		snap[counter.name] = counter.counts
+	}
+	return rates, coresRates
+}
+
+func countersTotal(counts []uint64) (total uint64) {
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+// ReadKernelMetric 读取负载 , 上下文切换 , fork , 硬中断和软中断 , 速率是和上一次采样的差值除以实际经过的时间
+func ReadKernelMetric(reader FsReaderInterface, snap *model.KernelSnap, now time.Time) model.KernelMetric {
+	result := model.KernelMetric{
+		Interrupts: []model.InterruptMetric{},
+		Softirqs:   []model.SoftirqMetric{},
+	}
+	hasLastSample := !snap.SampleAt.IsZero()
+	elapsed := now.Sub(snap.SampleAt)
+	snap.SampleAt = now
+	if raw, err := reader.ReadFile(procPaths.LoadAverage()); err == nil {
+		result.LoadAverage = parseLoadAverage(raw)
+	}
+
+	if raw, err := reader.ReadFile(procPaths.CpuUsage()); err == nil {
+		contextSwitches, forks := parseProcStatCounter(raw)
+		result.ContextSwitches = model.MetricUnit{
+			Value: counterRate(contextSwitches, snap.ContextSwitches, hasLastSample, elapsed),
+			Unit:  model.PerSec,
+		}
+		result.Forks = model.MetricUnit{
+			Value: counterRate(forks, snap.Forks, hasLastSample, elapsed),
+			Unit:  model.PerSec,
+		}
+		snap.ContextSwitches, snap.Forks = contextSwitches, forks
+	}
+
+	if snap.Interrupts == nil {
+		snap.Interrupts = map[string][]uint64{}
+	}
+	if raw, err := reader.ReadFile(procPaths.Interrupts()); err == nil {
+		counters := parseIrqTable(raw)
+		rates, coresRates := irqRates(counters, snap.Interrupts, hasLastSample, elapsed)
+		for index, counter := range counters {
+			if countersTotal(counter.counts) == 0 {
+				continue
+			}
+			result.Interrupts = append(result.Interrupts, model.InterruptMetric{
+				Name:        counter.name,
+				Description: counter.description,
+				Rate:        model.MetricUnit{Value: rates[index], Unit: model.PerSec},
+				CoresRate:   coresRates[index],
+			})
+		}
+		slices.SortStableFunc(result.Interrupts, func(a, b model.InterruptMetric) int {
+			return cmp.Compare(b.Rate.Value, a.Rate.Value)
+		})
+	}
+
+	if snap.Softirqs == nil {
+		snap.Softirqs = map[string][]uint64{}
+	}
+	if raw, err := reader.ReadFile(procPaths.Softirqs()); err == nil {
+		counters := parseIrqTable(raw)
+		rates, coresRates := irqRates(counters, snap.Softirqs, hasLastSample, elapsed)
+		for index, counter := range counters {
+			result.Softirqs = append(result.Softirqs, model.SoftirqMetric{
+				Name:      counter.name,
+				Rate:      model.MetricUnit{Value: rates[index], Unit: model.PerSec},
+				CoresRate: coresRates[index],
+			})
+		}
+		slices.SortStableFunc(result.Softirqs, func(a, b model.SoftirqMetric) int {
+			return cmp.Compare(b.Rate.Value, a.Rate.Value)
+		})
+	}
+	return result
+}
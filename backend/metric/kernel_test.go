@@ -0,0 +1,63 @@
+//go:build linux
+
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIrqTable(t *testing.T) {
+	counters := parseIrqTable(`           CPU0       CPU1
+ 16:        100        200     GICv2  30 Level     arch_timer
+ NMI:          0          0   Non-maskable interrupts
+ERR:          3
+`)
+	assert.Equal(t, []irqCounter{
+		{name: "16", description: "GICv2 30 Level arch_timer", counts: []uint64{100, 200}},
+		{name: "NMI", description: "Non-maskable interrupts", counts: []uint64{0, 0}},
+		{name: "ERR", counts: []uint64{3}},
+	}, counters)
+}
+
+func writeFakeKernel(t *testing.T, fs afero.Fs, ctxt string, timer string, netRx string) {
+	assert.NoError(t, afero.WriteFile(fs, "/proc/loadavg", []byte("0.50 0.25 0.10 2/140 4567\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/proc/stat", []byte("cpu  1 0 0 0 0 0 0 0 0 0\nctxt "+ctxt+"\nprocesses 1000\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/proc/interrupts", []byte("           CPU0       CPU1\n 16:  "+timer+"  "+timer+"  GICv2  30 Level  arch_timer\n 17:  0  0  GICv2  31 Level  unused\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/proc/softirqs", []byte("                    CPU0       CPU1\n          HI:          0          0\n      NET_RX:  "+netRx+"  0\n"), 0644))
+}
+
+func TestReadKernelMetric(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+	snap := model.KernelSnap{}
+	start := time.Now()
+
+	writeFakeKernel(t, fs, "1000", "100", "50")
+	first := ReadKernelMetric(reader, &snap, start)
+	assert.Equal(t, model.LoadAverage{Load1: 0.5, Load5: 0.25, Load15: 0.1, RunningTasks: 2, TotalTasks: 140}, first.LoadAverage)
+	assert.Equal(t, 0.0, first.ContextSwitches.Value)
+	// 从来没有触发过的 17 号中断不返回
+	assert.Len(t, first.Interrupts, 1)
+	assert.Equal(t, 0.0, first.Interrupts[0].Rate.Value)
+
+	writeFakeKernel(t, fs, "1400", "300", "250")
+	second := ReadKernelMetric(reader, &snap, start.Add(2*time.Second))
+	assert.Equal(t, model.MetricUnit{Value: 200, Unit: model.PerSec}, second.ContextSwitches)
+	assert.Equal(t, 0.0, second.Forks.Value)
+	assert.Equal(t, "GICv2 30 Level arch_timer", second.Interrupts[0].Description)
+	assert.Equal(t, 200.0, second.Interrupts[0].Rate.Value)
+	assert.Equal(t, []float64{100, 100}, second.Interrupts[0].CoresRate)
+	assert.Equal(t, "NET_RX", second.Softirqs[0].Name)
+	assert.Equal(t, 100.0, second.Softirqs[0].Rate.Value)
+
+	// 计数器变小时不算速率
+	writeFakeKernel(t, fs, "10", "300", "250")
+	third := ReadKernelMetric(reader, &snap, start.Add(4*time.Second))
+	assert.Equal(t, 0.0, third.ContextSwitches.Value)
+}
@@ -0,0 +1,128 @@
+//go:build linux
+
+package metric
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+const CollectorNetworkLink = "network_link"
+
+// linkTracker 记录每个网卡最近一次连通状态变化的时间 , netlink 不提供这个时间 , 只能在收到事件时自己记
+type linkTracker struct {
+	mutex     sync.Mutex
+	carrier   map[string]bool
+	changedAt map[string]time.Time
+}
+
+// observe 记录网卡当前的连通状态 , 和上一次不同时返回 true . 第一次见到的网卡不知道之前的状态 , 不算变化
+func (t *linkTracker) observe(name string, carrier bool, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.carrier == nil {
+		t.carrier = map[string]bool{}
+		t.changedAt = map[string]time.Time{}
+	}
+	last, exists := t.carrier[name]
+	t.carrier[name] = carrier
+	if !exists || last == carrier {
+		return false
+	}
+	t.changedAt[name] = now
+	return true
+}
+
+func (t *linkTracker) lastChange(name string) time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.changedAt[name]
+}
+
+// forget 网卡被删除后清掉记录 , 同名的网卡重新创建时重新开始
+func (t *linkTracker) forget(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.carrier, name)
+	delete(t.changedAt, name)
+}
+
+func linkCarrier(attrs *netlink.LinkAttrs) bool {
+	return attrs.RawFlags&unix.IFF_LOWER_UP != 0
+}
+
+// readLinkAttribute 读取 /sys/class/net/<name>/ 下的属性 , 链路断开时 speed 这类属性读取会报错
+func readLinkAttribute(reader FsReaderInterface, name string, attribute string) (string, bool) {
+	raw, err := reader.ReadFile(procPaths.NetworkInterfaceAttribute(name, attribute))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(raw), true
+}
+
+// newNetworkLink names 是网卡编号到名字 , 用来找 master 和 parent
+func newNetworkLink(reader FsReaderInterface, tracker *linkTracker, link netlink.Link, names map[int]string) model.NetworkLink {
+	attrs := link.Attrs()
+	result := model.NetworkLink{
+		Index:             attrs.Index,
+		Kind:              link.Type(),
+		OperState:         strings.ReplaceAll(attrs.OperState.String(), "-", ""), // 和 /sys/class/net/<name>/operstate 的写法保持一致
+		Carrier:           linkCarrier(attrs),
+		CarrierChanges:    -1,
+		LastCarrierChange: tracker.lastChange(attrs.Name),
+		Mtu:               attrs.MTU,
+		Mac:               attrs.HardwareAddr.String(),
+		SpeedMbps:         -1,
+		Duplex:            "unknown",
+		Master:            names[attrs.MasterIndex],
+		Parent:            names[attrs.ParentIndex],
+	}
+	if vlan, ok := link.(*netlink.Vlan); ok {
+		result.VlanId = vlan.VlanId
+	}
+
+	if raw, ok := readLinkAttribute(reader, attrs.Name, "carrier_changes"); ok {
+		if changes, err := strconv.Atoi(raw); err == nil {
+			result.CarrierChanges = changes
+		}
+	}
+	// 虚拟网卡的 speed 是 -1 或者读取报错
+	if raw, ok := readLinkAttribute(reader, attrs.Name, "speed"); ok {
+		if speed, err := strconv.Atoi(raw); err == nil && speed > 0 {
+			result.SpeedMbps = speed
+		}
+	}
+	if raw, ok := readLinkAttribute(reader, attrs.Name, "duplex"); ok && raw != "" {
+		result.Duplex = raw
+	}
+	return result
+}
+
+// ReadNetworkLinks 读取所有网卡的链路属性 , 不包括 lo
+func ReadNetworkLinks(reader FsReaderInterface, tracker *linkTracker) (map[string]model.NetworkLink, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(links))
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	result := make(map[string]model.NetworkLink, len(links))
+	for _, link := range links {
+		attrs := link.Attrs()
+		if attrs.Name == "lo" {
+			continue
+		}
+		tracker.observe(attrs.Name, linkCarrier(attrs), time.Now())
+		result[attrs.Name] = newNetworkLink(reader, tracker, link, names)
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package metric
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestLinkTrackerObserve(t *testing.T) {
+	tracker := linkTracker{}
+	now := time.Unix(1700000000, 0)
+	assert.False(t, tracker.observe("eth0", true, now))
+	assert.False(t, tracker.observe("eth0", true, now.Add(time.Second)))
+	assert.True(t, tracker.observe("eth0", false, now.Add(2*time.Second)))
+	assert.Equal(t, now.Add(2*time.Second), tracker.lastChange("eth0"))
+
+	tracker.forget("eth0")
+	assert.True(t, tracker.lastChange("eth0").IsZero())
+	assert.False(t, tracker.observe("eth0", true, now))
+}
+
+func TestNewNetworkLink(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+	_ = afero.WriteFile(fs, testProcPaths.NetworkInterfaceAttribute("eth0.2", "carrier_changes"), []byte("4\n"), 0o644)
+	_ = afero.WriteFile(fs, testProcPaths.NetworkInterfaceAttribute("eth0.2", "speed"), []byte("-1\n"), 0o644)
+	_ = afero.WriteFile(fs, testProcPaths.NetworkInterfaceAttribute("lan1", "speed"), []byte("2500\n"), 0o644)
+	_ = afero.WriteFile(fs, testProcPaths.NetworkInterfaceAttribute("lan1", "duplex"), []byte("full\n"), 0o644)
+
+	mac, _ := net.ParseMAC("02:11:22:33:44:55")
+	names := map[int]string{2: "eth0", 5: "eth0.2", 6: "lan1", 9: "br-lan"}
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Index: 5, Name: "eth0.2", MTU: 1500, HardwareAddr: mac, ParentIndex: 2,
+			OperState: netlink.OperUp, RawFlags: unix.IFF_UP | unix.IFF_LOWER_UP,
+		},
+		VlanId: 2,
+	}
+	assert.Equal(t, model.NetworkLink{
+		Index:          5,
+		Kind:           "vlan",
+		OperState:      "up",
+		Carrier:        true,
+		CarrierChanges: 4,
+		Mtu:            1500,
+		Mac:            "02:11:22:33:44:55",
+		SpeedMbps:      -1,
+		Duplex:         "unknown",
+		Parent:         "eth0",
+		VlanId:         2,
+	}, newNetworkLink(reader, &linkTracker{}, vlan, names))
+
+	port := &netlink.Device{LinkAttrs: netlink.LinkAttrs{
+		Index: 6, Name: "lan1", MTU: 1500, MasterIndex: 9, OperState: netlink.OperLowerLayerDown,
+	}}
+	link := newNetworkLink(reader, &linkTracker{}, port, names)
+	assert.Equal(t, "br-lan", link.Master)
+	assert.False(t, link.Carrier)
+	assert.Equal(t, "lowerlayerdown", link.OperState)
+	assert.Equal(t, 2500, link.SpeedMbps)
+	assert.Equal(t, "full", link.Duplex)
+	assert.Equal(t, -1, link.CarrierChanges)
+}
@@ -10,6 +10,7 @@ import (
 	"net/netip"
 	"strconv"
 	"strings"
+	"time"
 
 	"openwrt-diskio-api/backend/model"
 	"openwrt-diskio-api/backend/utils"
@@ -26,7 +27,8 @@ type rawConn struct {
 	kv       map[string]string
 }
 
-// TODO 暂时只实现了读取cpu总温度,arm设备一般cpu簇共用一个温度传感器,后面再说
+// readCpuTemperature 整个 cpu 的温度 , 用的是 thermal_zone0 .
+// 每个核心的温度见 readCoresTemperature , 所有传感器的读数见 ReadSensorsMetric
 func readCpuTemperature(reader FsReaderInterface) (float64, string) {
 	raw, err := reader.ReadFile(procPaths.CpuTemp())
 	if err != nil {
@@ -41,6 +43,10 @@ func readCpuIdle(reader FsReaderInterface) (allCoreCycles uint64, allCoreIdle ui
 	if err != nil {
 		return 0, 0, coresIdle, err
 	}
+	return parseCpuIdle(raw)
+}
+
+func parseCpuIdle(raw string) (allCoreCycles uint64, allCoreIdle uint64, coresIdle []model.CpuSnapUnit, err error) {
 	lines := strings.Split(raw, "\n")
 
 	for _, line := range lines {
@@ -80,8 +86,63 @@ func readCpuIdle(reader FsReaderInterface) (allCoreCycles uint64, allCoreIdle ui
 	return allCoreCycles, allCoreIdle, coresIdle, nil
 }
 
+// parseCpuTimes 解析 /proc/stat 里 cpu 行的各项时间片 , 列的顺序 :
+// user nice system idle iowait irq softirq steal guest guest_nice
+func parseCpuTimes(raw string) (allTimes model.CpuTimes, coresTimes []model.CpuTimes) {
+	for _, line := range strings.Split(raw, "\n") {
+		if !strings.HasPrefix(line, "cpu") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		values := make([]uint64, 8)
+		for index := range values {
+			if index+1 < len(fields) {
+				values[index], _ = strconv.ParseUint(fields[index+1], 10, 64)
+			}
+		}
+		times := model.CpuTimes{
+			User:    values[0],
+			Nice:    values[1],
+			System:  values[2],
+			Idle:    values[3],
+			Iowait:  values[4],
+			Irq:     values[5],
+			Softirq: values[6],
+			Steal:   values[7],
+		}
+		if fields[0] == "cpu" {
+			allTimes = times
+		} else {
+			coresTimes = append(coresTimes, times)
+		}
+	}
+	return allTimes, coresTimes
+}
+
+// cpuTimesPercent 返回 iowait , irq , softirq , steal 在两次采样之间的占比
+func cpuTimesPercent(now model.CpuTimes, last model.CpuTimes) (iowait, irq, softirq, steal float64) {
+	if now.Total() <= last.Total() {
+		return 0, 0, 0, 0
+	}
+	total := float64(now.Total() - last.Total())
+	percent := func(now uint64, last uint64) float64 {
+		if now <= last {
+			return 0
+		}
+		return float64(now-last) / total * 100
+	}
+	return percent(now.Iowait, last.Iowait),
+		percent(now.Irq, last.Irq),
+		percent(now.Softirq, last.Softirq),
+		percent(now.Steal, last.Steal)
+}
+
 func readTotalCpuUsage(reader FsReaderInterface, lastSnap *model.CpuSnap) (allCoresUsage float64, coresUsage []float64) {
-	nowAllCoreCycles, nowAllCoreIdle, nowCoresStatus, _ := readCpuIdle(reader)
+	raw, _ := reader.ReadFile(procPaths.CpuUsage())
+	nowAllCoreCycles, nowAllCoreIdle, nowCoresStatus, _ := parseCpuIdle(raw)
 
 	// 总占用
 	allCoresUsage = utils.CalculateCpuUsage(
@@ -109,6 +170,7 @@ func readTotalCpuUsage(reader FsReaderInterface, lastSnap *model.CpuSnap) (allCo
 	lastSnap.AllCycles = nowAllCoreCycles
 	lastSnap.AllCoreIdle = nowAllCoreIdle
 	lastSnap.Cores = nowCoresStatus
+	lastSnap.AllTimes, lastSnap.CoresTimes = parseCpuTimes(raw)
 	return allCoresUsage, coresUsage
 }
 
@@ -366,6 +428,7 @@ func readDefaultGateway(reader FsReaderInterface) string {
 	return model.StringDefault
 }
 
+// readDiskUsage 只统计 /dev 下的设备 , 按设备名和读写速率放在一起 . 按挂载点统计所有文件系统的见 ReadFilesystemMetric
 func readDiskUsage(reader FsReaderInterface, metric model.StorageMetric) {
 	raw, err := reader.ReadFile(procPaths.StorageDeviceMounts())
 	if err != nil {
@@ -451,38 +514,109 @@ func readDiskUsage(reader FsReaderInterface, metric model.StorageMetric) {
 	}
 }
 
-func readDiskIoStats(reader FsReaderInterface, metric model.StorageMetric, lastSnap model.DiskSnap, updateInterval uint) {
+// parseDiskStats 按 /proc/diskstats 的列顺序解析 , 前三列是主设备号 , 次设备号和设备名 :
+// 179 0 mmcblk0 5234 1203 312830 2310 8021 9120 402316 40213 0 21020 42523 ...
+func parseDiskStats(fields []string) model.DiskStatCounters {
+	values := make([]uint64, 11)
+	for index := range values {
+		if index+3 < len(fields) {
+			values[index], _ = strconv.ParseUint(fields[index+3], 10, 64)
+		}
+	}
+	return model.DiskStatCounters{
+		Reads:        values[0],
+		ReadMerges:   values[1],
+		ReadSectors:  values[2],
+		ReadTicks:    values[3],
+		Writes:       values[4],
+		WriteMerges:  values[5],
+		WriteSectors: values[6],
+		WriteTicks:   values[7],
+		InFlight:     values[8],
+		IoTicks:      values[9],
+		TimeInQueue:  values[10],
+	}
+}
+
+// counterDeltaOrZero 计数器被重置时当作没有变化
+func counterDeltaOrZero(now uint64, last uint64) uint64 {
+	delta, _ := utils.CounterDelta(now, last)
+	return delta
+}
+
+// diskAwait 间隔里请求的平均耗时 , 没有请求时为 0
+func diskAwait(ticks uint64, ios uint64) float64 {
+	if ios == 0 {
+		return 0
+	}
+	return float64(ticks) / float64(ios)
+}
+
+func diskIoMetric(now model.DiskStatCounters, last model.DiskStatCounters, hasLast bool, elapsed time.Duration) model.DiskIoMetric {
+	rate := func(now uint64, last uint64) model.MetricUnit {
+		return model.MetricUnit{Value: counterRate(now, last, hasLast, elapsed), Unit: model.PerSec}
+	}
+	// 时间类的计数单位是毫秒 , 除以经过的毫秒数得到占比
+	timeShare := func(now uint64, last uint64) float64 {
+		return counterRate(now, last, hasLast, elapsed) / 1000
+	}
+	result := model.DiskIoMetric{
+		ReadIops:    rate(now.Reads, last.Reads),
+		WriteIops:   rate(now.Writes, last.Writes),
+		ReadMerges:  rate(now.ReadMerges, last.ReadMerges),
+		WriteMerges: rate(now.WriteMerges, last.WriteMerges),
+		ReadAwait:   model.MetricUnit{Unit: model.Ms},
+		WriteAwait:  model.MetricUnit{Unit: model.Ms},
+		Await:       model.MetricUnit{Unit: model.Ms},
+		QueueDepth:  timeShare(now.TimeInQueue, last.TimeInQueue),
+		InFlight:    now.InFlight,
+		Util:        model.MetricUnit{Value: min(timeShare(now.IoTicks, last.IoTicks)*100, 100), Unit: model.Percent},
+		ReadBytes:   now.ReadSectors * model.SectorSize,
+		WriteBytes:  now.WriteSectors * model.SectorSize,
+	}
+	if hasLast {
+		readTicks, reads := counterDeltaOrZero(now.ReadTicks, last.ReadTicks), counterDeltaOrZero(now.Reads, last.Reads)
+		writeTicks, writes := counterDeltaOrZero(now.WriteTicks, last.WriteTicks), counterDeltaOrZero(now.Writes, last.Writes)
+		result.ReadAwait.Value = diskAwait(readTicks, reads)
+		result.WriteAwait.Value = diskAwait(writeTicks, writes)
+		result.Await.Value = diskAwait(readTicks+writeTicks, reads+writes)
+	}
+	return result
+}
+
+// readDiskIoStats 读取 /proc/diskstats 里的所有设备 , 没有挂载的设备也会加到 metric 里 ,
+// 从来没有过读写的设备 (ram0 , 没用到的 loop) 跳过
+func readDiskIoStats(reader FsReaderInterface, metric model.StorageMetric, lastSnap *model.DiskSnap, now time.Time) {
 	raw, err := reader.ReadFile(procPaths.StorageDeviceIo())
 	if err != nil {
 		return
 	}
+	hasLastSample := !lastSnap.SampleAt.IsZero()
+	elapsed := now.Sub(lastSnap.SampleAt)
+	devices := make(map[string]model.DiskStatCounters, len(lastSnap.Devices))
 	for _, line := range strings.Split(string(raw), "\n") {
 		fields := strings.Fields(line)
 		if len(fields) < 14 {
 			continue
 		}
 		deviceName := fields[2]
-		if strings.HasPrefix(deviceName, "loop") ||
-			strings.HasPrefix(deviceName, "ram") ||
-			strings.HasPrefix(deviceName, "nbd") ||
-			strings.HasPrefix(deviceName, "zram") {
+		counters := parseDiskStats(fields)
+		if counters.Reads == 0 && counters.Writes == 0 {
 			continue
 		}
+		devices[deviceName] = counters
 
-		readBytesNow, _ := strconv.ParseFloat(fields[5], 64)
-		writeBytesNow, _ := strconv.ParseFloat(fields[9], 64)
-
-		readRate := utils.CalculateRate(readBytesNow, lastSnap[deviceName].ReadBytes, updateInterval)
-		writeRate := utils.CalculateRate(writeBytesNow, lastSnap[deviceName].WriteBytes, updateInterval)
+		last, hasLast := lastSnap.Devices[deviceName]
+		hasLast = hasLast && hasLastSample
+		// 扇区数在 32 位内核上也是 32 位的 , 先算增量再换算成字节
+		readRate := counterRate(counters.ReadSectors, last.ReadSectors, hasLast, elapsed) * model.SectorSize
+		writeRate := counterRate(counters.WriteSectors, last.WriteSectors, hasLast, elapsed) * model.SectorSize
 
 		readRate, readDeltaUnit := utils.ConvertBytes(readRate, model.BSecond)
 		writeRate, WriteDeltaUnit := utils.ConvertBytes(writeRate, model.BSecond)
+		io := diskIoMetric(counters, last, hasLast, elapsed)
 
-		deviceMetric, exist := metric[deviceName]
-		if !exist {
-			continue
-		}
-
+		deviceMetric := metric[deviceName]
 		deviceMetric.Read = model.MetricUnit{
 			Value: readRate,
 			Unit:  readDeltaUnit,
@@ -491,16 +625,83 @@ func readDiskIoStats(reader FsReaderInterface, metric model.StorageMetric, lastS
 			Value: writeRate,
 			Unit:  WriteDeltaUnit,
 		}
+		deviceMetric.Io = &io
 		metric[deviceName] = deviceMetric
+	}
+	lastSnap.SampleAt = now
+	lastSnap.Devices = devices
+}
 
-		lastSnap[deviceName] = model.DiskSnapUnit{
-			ReadBytes:  readBytesNow,
-			WriteBytes: writeBytesNow,
+// counterRate 用实际经过的时间计算计数器的速率 . 没有上一次的读数 , 或者计数器被重置时没法算出速率 , 返回 0
+func counterRate(now uint64, last uint64, hasLast bool, elapsed time.Duration) float64 {
+	if !hasLast {
+		return 0
+	}
+	delta, ok := utils.CounterDelta(now, last)
+	if !ok {
+		return 0
+	}
+	return utils.RatePerSecond(delta, elapsed)
+}
+
+// parseNetDevCounters 按 /proc/net/dev 的列顺序解析 , 收和发各 8 列
+func parseNetDevCounters(fields []string) model.NetDevCounters {
+	values := make([]uint64, 16)
+	for index := range values {
+		if index < len(fields) {
+			values[index], _ = strconv.ParseUint(fields[index], 10, 64)
 		}
 	}
+	return model.NetDevCounters{
+		RxBytes:      values[0],
+		RxPackets:    values[1],
+		RxErrors:     values[2],
+		RxDropped:    values[3],
+		RxFifo:       values[4],
+		RxFrame:      values[5],
+		RxCompressed: values[6],
+		RxMulticast:  values[7],
+		TxBytes:      values[8],
+		TxPackets:    values[9],
+		TxErrors:     values[10],
+		TxDropped:    values[11],
+		TxFifo:       values[12],
+		TxCollisions: values[13],
+		TxCarrier:    values[14],
+		TxCompressed: values[15],
+	}
+}
+
+func netDevRates(now model.NetDevCounters, last model.NetDevCounters, hasLast bool, elapsed time.Duration) model.NetDevRates {
+	rate := func(now uint64, last uint64) model.MetricUnit {
+		return model.MetricUnit{Value: counterRate(now, last, hasLast, elapsed), Unit: model.PerSec}
+	}
+	return model.NetDevRates{
+		RxPackets: rate(now.RxPackets, last.RxPackets),
+		TxPackets: rate(now.TxPackets, last.TxPackets),
+		RxErrors:  rate(now.RxErrors, last.RxErrors),
+		TxErrors:  rate(now.TxErrors, last.TxErrors),
+		RxDropped: rate(now.RxDropped, last.RxDropped),
+		TxDropped: rate(now.TxDropped, last.TxDropped),
+	}
 }
 
-func ReadNetworkMetric(reader FsReaderInterface, lastSnap *model.NetSnap, updateInterval uint) model.NetworkMetric {
+func addNetDevRates(total model.NetDevRates, rates model.NetDevRates) model.NetDevRates {
+	add := func(total model.MetricUnit, rate model.MetricUnit) model.MetricUnit {
+		return model.MetricUnit{Value: total.Value + rate.Value, Unit: model.PerSec}
+	}
+	return model.NetDevRates{
+		RxPackets: add(total.RxPackets, rates.RxPackets),
+		TxPackets: add(total.TxPackets, rates.TxPackets),
+		RxErrors:  add(total.RxErrors, rates.RxErrors),
+		TxErrors:  add(total.TxErrors, rates.TxErrors),
+		RxDropped: add(total.RxDropped, rates.RxDropped),
+		TxDropped: add(total.TxDropped, rates.TxDropped),
+	}
+}
+
+// ReadNetworkMetric 速率是和上一次采样的差值除以实际经过的时间 , 第一次采样和新出现的网卡速率为 0
+func ReadNetworkMetric(reader FsReaderInterface, lastSnap *model.NetSnap, now time.Time) model.NetworkMetric {
 	if lastSnap == nil {
 		panic("ReadNetworkMetric lastSnap is nil")
 	}
@@ -509,37 +710,37 @@ func ReadNetworkMetric(reader FsReaderInterface, lastSnap *model.NetSnap, update
 
 	data, _ := reader.ReadFile(procPaths.NetworkDeviceIo())
 
+	hasLastSample := !lastSnap.SampleAt.IsZero()
+	elapsed := now.Sub(lastSnap.SampleAt)
+	interfaces := make(map[string]model.NetDevCounters, len(lastSnap.Interfaces))
 	totalRxRateNow := 0.0
 	totalTxRateNow := 0.0
+	totalRates := addNetDevRates(model.NetDevRates{}, model.NetDevRates{}) // 没有网卡时也带上单位
 
 	for _, line := range strings.Split(string(data), "\n") {
-		if !strings.Contains(line, ":") {
+		// 计数很大时网卡名和第一列之间没有空格 , 例如 eth0:123456
+		name, rest, found := strings.Cut(line, ":")
+		if !found {
 			continue
 		}
-		parts := strings.Fields(line)
-		interfaceName := strings.TrimSuffix(parts[0], ":")
+		interfaceName := strings.TrimSpace(name)
 		if interfaceName == "lo" ||
 			strings.HasPrefix(interfaceName, "loopback") {
 			continue
 		}
 
-		rxNow, _ := strconv.ParseFloat(parts[1], 64)
-		txNow, _ := strconv.ParseFloat(parts[9], 64)
-
-		if _, exist := lastSnap.Interfaces[interfaceName]; !exist {
-			lastSnap.Interfaces[interfaceName] = model.NetSnapUnit{}
-		}
-		lastUnit := lastSnap.Interfaces[interfaceName]
+		counters := parseNetDevCounters(strings.Fields(rest))
+		last, hasLast := lastSnap.Interfaces[interfaceName]
+		hasLast = hasLast && hasLastSample
 
-		rxRate := utils.CalculateRate(rxNow, lastUnit.RxBytes, updateInterval)
-		txRate := utils.CalculateRate(txNow, lastUnit.TxBytes, updateInterval)
+		rxRate := counterRate(counters.RxBytes, last.RxBytes, hasLast, elapsed)
+		txRate := counterRate(counters.TxBytes, last.TxBytes, hasLast, elapsed)
 		totalRxRateNow += rxRate
 		totalTxRateNow += txRate
+		rates := netDevRates(counters, last, hasLast, elapsed)
+		totalRates = addNetDevRates(totalRates, rates)
 
-		lastSnap.Interfaces[interfaceName] = model.NetSnapUnit{
-			RxBytes: rxNow,
-			TxBytes: txNow,
-		}
+		interfaces[interfaceName] = counters
 
 		rxRate, rxUnit := utils.ConvertBytes(rxRate, model.BSecond)
 		txRate, txUnit := utils.ConvertBytes(txRate, model.BSecond)
@@ -547,8 +748,13 @@ func ReadNetworkMetric(reader FsReaderInterface, lastSnap *model.NetSnap, update
 		result[interfaceName] = model.NetworkIoMetric{
 			Incoming: model.MetricUnit{Value: rxRate, Unit: rxUnit},
 			Outgoing: model.MetricUnit{Value: txRate, Unit: txUnit},
+			Rates:    rates,
+			Counters: &counters,
 		}
 	}
+	// 消失的网卡不再保留 , 同名的网卡重新创建后从新的读数开始
+	lastSnap.SampleAt = now
+	lastSnap.Interfaces = interfaces
 
 	totalRxRate, totalRxUnit := utils.ConvertBytes(totalRxRateNow, model.BSecond)
 	totalTxRate, totalTxUnit := utils.ConvertBytes(totalTxRateNow, model.BSecond)
@@ -557,6 +763,9 @@ func ReadNetworkMetric(reader FsReaderInterface, lastSnap *model.NetSnap, update
 		totalRxRate, totalRxUnit,
 		totalTxRate, totalTxUnit,
 	)
+	total := result["total"]
+	total.Rates = totalRates
+	result["total"] = total
 
 	return result
 }
@@ -567,27 +776,63 @@ func ReadCpuMetric(reader FsReaderInterface, lastSnap *model.CpuSnap) model.CpuM
 	}
 	nowMetric := model.CpuMetric{}
 
+	lastTimes, lastCoresTimes, lastCoresFreq := lastSnap.AllTimes, lastSnap.CoresTimes, lastSnap.CoresFreq
+	// 第一次采样时 AllCycles 还是 0 , 和每个核心一样没有上一次的采样
+	hasLastTotal := lastSnap.AllCycles > 0
 	totalUsage, coresUsage := readTotalCpuUsage(reader, lastSnap)
+	coresFreq := make([]model.CpuFreqSnap, len(coresUsage))
 	temperature, temperatureUnit := readCpuTemperature(reader)
+	coresTemperature := readCoresTemperature(reader, len(coresUsage))
 	nowMetric.SetTotal(
 		totalUsage, model.Percent, temperature, temperatureUnit,
 	)
+	nowMetric["total"] = withCpuTimesPercent(nowMetric["total"], lastSnap.AllTimes, lastTimes, hasLastTotal)
 
 	for index, usage := range coresUsage {
-		nowMetric["cpu"+strconv.Itoa(index)] = model.CpuUsageMetric{
+		core := model.CpuUsageMetric{
 			Usage: model.MetricUnit{
 				Value: usage,
 				Unit:  model.Percent,
 			},
 			Temperature: model.MetricUnit{
-				Value: temperature,
-				Unit:  temperatureUnit,
+				Value: coresTemperature[index],
+				Unit:  model.Celsius,
 			},
 		}
+		var nowTimes, lastTimes model.CpuTimes
+		if index < len(lastSnap.CoresTimes) {
+			nowTimes = lastSnap.CoresTimes[index]
+		}
+		hasLast := index < len(lastCoresTimes)
+		if hasLast {
+			lastTimes = lastCoresTimes[index]
+		}
+		core = withCpuTimesPercent(core, nowTimes, lastTimes, hasLast)
+
+		var lastFreq *model.CpuFreqSnap
+		if index < len(lastCoresFreq) {
+			lastFreq = &lastCoresFreq[index]
+		}
+		core.Frequency, coresFreq[index] = readCpuFrequency(reader, index, lastFreq)
+		nowMetric["cpu"+strconv.Itoa(index)] = core
 	}
+	lastSnap.CoresFreq = coresFreq
 	return nowMetric
 }
 
+// withCpuTimesPercent 没有上一次的采样时各项都填 -1 , 和 usage 一致
+func withCpuTimesPercent(metric model.CpuUsageMetric, now model.CpuTimes, last model.CpuTimes, hasLast bool) model.CpuUsageMetric {
+	iowait, irq, softirq, steal := -1.0, -1.0, -1.0, -1.0
+	if hasLast {
+		iowait, irq, softirq, steal = cpuTimesPercent(now, last)
+	}
+	metric.Iowait = model.MetricUnit{Value: iowait, Unit: model.Percent}
+	metric.Irq = model.MetricUnit{Value: irq, Unit: model.Percent}
+	metric.Softirq = model.MetricUnit{Value: softirq, Unit: model.Percent}
+	metric.Steal = model.MetricUnit{Value: steal, Unit: model.Percent}
+	return metric
+}
+
 func ReadMemoryMetric(reader FsReaderInterface) model.MemoryMetric {
 	result := model.MemoryMetric{}
 
@@ -674,15 +919,21 @@ func selectPrivateAddress(
 	return originConnectionSrcAddr, originConnectionSrcPort, originConnectionDstAddr, originConnectionDstPort
 }
 
-func ReadConnectionMetric(reader FsReaderInterface, metric *model.NetworkConnectionMetric, privateCidr []string) {
-	var lines []string
+// 所有 conntrack 文件都读不到时返回最后一个错误
+func ReadConnectionMetric(reader FsReaderInterface, metric *model.NetworkConnectionMetric, privateCidr []string) error {
+	var (
+		lines   []string
+		readErr error
+	)
 
 	for _, path := range procPaths.NetworkConnection() {
 		b, err := reader.ReadFile(path)
 		if err != nil {
+			readErr = err
 			continue
 		}
 		lines = strings.Split(string(b), "\n")
+		readErr = nil
 		break
 	}
 
@@ -787,6 +1038,7 @@ func ReadConnectionMetric(reader FsReaderInterface, metric *model.NetworkConnect
 		)
 	}
 	metric.Details = append(metric.Details, result...)
+	return readErr
 }
 
 func ReadStaticSystemMetric(reader FsReaderInterface, runner CommandRunnerInterface) model.StaticSystemMetric {
@@ -845,10 +1097,11 @@ func ReadStaticNetworkMetric(reader FsReaderInterface, runner CommandRunnerInter
 	return result
 }
 
-func ReadStorageMetric(reader FsReaderInterface, lastSnap model.DiskSnap, updateInterval uint) model.StorageMetric {
+// ReadStorageMetric 速率的计算同 ReadNetworkMetric
+func ReadStorageMetric(reader FsReaderInterface, lastSnap *model.DiskSnap, now time.Time) model.StorageMetric {
 	metric := model.StorageMetric{}
 	// only show have storage usage device
 	readDiskUsage(reader, metric)
-	readDiskIoStats(reader, metric, lastSnap, updateInterval)
+	readDiskIoStats(reader, metric, lastSnap, now)
 	return metric
 }
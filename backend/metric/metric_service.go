@@ -14,6 +14,7 @@ type DynamicMetricService struct {
 	lastRequestTimeUnix int64
 	reader              FsReaderInterface
 	dynamicMetric       *model.DynamicMetric
+	timing              *collectorTimingRecorder
 }
 
 func NewDynamicMetricService(reader FsReaderInterface, updateInterval uint) *DynamicMetricService {
@@ -34,20 +35,49 @@ func (dms *DynamicMetricService) ActiveSignal() {
 	}
 }
 
-func (dms *DynamicMetricService) Run(ctx context.Context) {
-	diskSnap := model.DiskSnap{}
-	cpuSnap := model.CpuSnap{}
-	netSnap := model.NetSnap{
-		Interfaces: map[string]model.NetSnapUnit{},
-	}
-	updateIntervalSecond := dms.UpdateInterval
-	tickDuration := time.Duration(updateIntervalSecond) * time.Second
+// SampleGapTicks 两次采样的间隔超过这么多个周期时 (服务刚恢复 , 或者系统卡住了) , 上一次的快照已经过时 ,
+// 这次采样只作为新的基准 , 不更新数据
+const SampleGapTicks = 3
+
+// dynamicSnaps 计算速率需要的上一次采样 , 每个快照自己记录采样时间
+type dynamicSnaps struct {
+	disk   model.DiskSnap
+	cpu    model.CpuSnap
+	kernel model.KernelSnap
+	net    model.NetSnap
+}
+
+func (dms *DynamicMetricService) sample(snaps *dynamicSnaps, now time.Time) *model.DynamicMetric {
 	reader := dms.reader
+	defer dms.timing.observe(CollectorDynamicMetric, time.Now())
+	return &model.DynamicMetric{
+		Network: ReadNetworkMetric(reader, &snaps.net, now),
+		Cpu:     ReadCpuMetric(reader, &snaps.cpu),
+		Storage: ReadStorageMetric(reader, &snaps.disk, now),
+		Memory:  ReadMemoryMetric(reader),
+		System:  ReadSystemMetric(reader),
+		Kernel:  ReadKernelMetric(reader, &snaps.kernel, now),
+		Sensors: ReadSensorsMetric(reader),
+	}
+}
+
+// resetBaseline 丢掉过时的快照 , 重新采样作为基准
+func (dms *DynamicMetricService) resetBaseline(snaps *dynamicSnaps, now time.Time) {
+	*snaps = dynamicSnaps{}
+	dms.sample(snaps, now)
+}
+
+func (dms *DynamicMetricService) Run(ctx context.Context) {
+	snaps := &dynamicSnaps{}
+	tickDuration := time.Duration(dms.UpdateInterval) * time.Second
 	isRunning := true
 	ticker := time.NewTicker(tickDuration)
 	defer ticker.Stop()
 
+	// time.Now() 带有单调时钟的读数 , 相减不受系统时间调整的影响
 	prevTime := time.Now()
+	// 先取一次基准 , 第一个周期就能算出速率
+	dms.resetBaseline(snaps, prevTime)
 
 	for {
 		select {
@@ -58,6 +88,7 @@ func (dms *DynamicMetricService) Run(ctx context.Context) {
 				log.Println("Enable dynamic system metric service")
 				isRunning = true
 				prevTime = time.Now()
+				dms.resetBaseline(snaps, prevTime)
 			}
 		case <-ticker.C:
 			if !isRunning {
@@ -73,24 +104,17 @@ func (dms *DynamicMetricService) Run(ctx context.Context) {
 			}
 
 			currTime := time.Now()
-			elapsed := currTime.Sub(prevTime).Seconds()
+			elapsed := currTime.Sub(prevTime)
 			if elapsed <= 0 {
 				continue
 			}
-			networkMetric := ReadNetworkMetric(reader, &netSnap, updateIntervalSecond)
-			cpuMetric := ReadCpuMetric(reader, &cpuSnap)
-			storageMetric := ReadStorageMetric(reader, diskSnap, updateIntervalSecond)
-			memoryMetric := ReadMemoryMetric(reader)
-			systemMetric := ReadSystemMetric(reader)
-
-			dms.dynamicMetric = &model.DynamicMetric{
-				Cpu:     cpuMetric,
-				Memory:  memoryMetric,
-				Network: networkMetric,
-				Storage: storageMetric,
-				System:  systemMetric,
-			}
 			prevTime = currTime
+			if elapsed > SampleGapTicks*tickDuration {
+				log.Printf("Dynamic metric sample gap %s , discard this sample", elapsed)
+				dms.resetBaseline(snaps, currTime)
+				continue
+			}
+			dms.dynamicMetric = dms.sample(snaps, currTime)
 		}
 	}
 }
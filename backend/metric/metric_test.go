@@ -7,7 +7,9 @@ import (
 	"errors"
 	"io"
 	"openwrt-diskio-api/backend/model"
+	"openwrt-diskio-api/backend/utils"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -30,6 +32,9 @@ func (c *TestReader) Exists(path string) bool {
 func (c *TestReader) Open(path string) (io.ReadCloser, error) {
 	return nil, nil
 }
+func (c *TestReader) ReadDir(path string) ([]string, error) {
+	return nil, nil
+}
 
 func NewMockReader(readData string, mockError error, path string) *TestReader {
 	reader := &TestReader{}
@@ -205,6 +210,108 @@ softirq 709050685 1337984 167961916 259144 400626814 18849 0 2523414 104303354 1
 	}
 }
 
+func TestReadCpuMetricTimesBreakdown(t *testing.T) {
+	reader := &TestReader{}
+	reader.On("ReadFile", testProcPaths.CpuUsage()).Return("cpu  100 0 100 700 50 20 30 0 0 0\ncpu0 100 0 100 700 50 20 30 0 0 0\n", nil).Once()
+	reader.On("ReadFile", testProcPaths.CpuUsage()).Return("cpu  150 0 150 1450 150 40 60 0 0 0\ncpu0 150 0 150 1450 150 40 60 0 0 0\n", nil).Once()
+	reader.On("ReadFile", mock.Anything).Return("", errors.New("Test error"))
+
+	snap := &model.CpuSnap{}
+	first := ReadCpuMetric(reader, snap)
+	assert.Equal(t, -1.0, first["cpu0"].Iowait.Value)
+	// 第一次采样时 total 也没有上一次的采样 , 不能用开机以来的平均值
+	assert.Equal(t, model.MetricUnit{Value: -1, Unit: model.Percent}, first["total"].Iowait)
+	assert.Equal(t, -1.0, first["total"].Irq.Value)
+	assert.Equal(t, -1.0, first["total"].Softirq.Value)
+	assert.Equal(t, -1.0, first["total"].Steal.Value)
+
+	second := ReadCpuMetric(reader, snap)
+	assert.Equal(t, model.MetricUnit{Value: 10, Unit: model.Percent}, second["cpu0"].Iowait)
+	assert.Equal(t, 2.0, second["cpu0"].Irq.Value)
+	assert.Equal(t, 3.0, second["cpu0"].Softirq.Value)
+	assert.Equal(t, 0.0, second["cpu0"].Steal.Value)
+	assert.Equal(t, 10.0, second["total"].Iowait.Value)
+}
+
+func TestReadNetworkMetricCounters(t *testing.T) {
+	header := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:  1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+`
+	reader := &TestReader{}
+	reader.On("ReadFile", testProcPaths.NetworkDeviceIo()).Return(header+
+		"  eth0: 2000 100 0 5 0 0 0 3 4000 200 0 0 0 0 0 0\n"+
+		"phy0-ap0:3000 300 1 0 0 0 0 0 6000 600 0 2 0 0 0 0\n", nil).Once()
+	reader.On("ReadFile", testProcPaths.NetworkDeviceIo()).Return(header+
+		"  eth0: 4000 300 0 25 0 0 0 3 8000 400 0 0 0 0 0 0\n"+
+		"phy0-ap0:3000 300 1 0 0 0 0 0 6000 600 0 12 0 0 0 0\n", nil).Once()
+
+	snap := &model.NetSnap{}
+	start := time.Now()
+	first := ReadNetworkMetric(reader, snap, start)
+	assert.NotContains(t, first, "lo")
+	assert.Equal(t, uint64(3), first["eth0"].Counters.RxMulticast)
+	assert.Equal(t, uint64(2), first["phy0-ap0"].Counters.TxDropped)
+	assert.Equal(t, 0.0, first["eth0"].Rates.RxPackets.Value)
+
+	second := ReadNetworkMetric(reader, snap, start.Add(2*time.Second))
+	assert.Equal(t, model.MetricUnit{Value: 100, Unit: model.PerSec}, second["eth0"].Rates.RxPackets)
+	assert.Equal(t, 10.0, second["eth0"].Rates.RxDropped.Value)
+	assert.Equal(t, 5.0, second["phy0-ap0"].Rates.TxDropped.Value)
+	assert.Equal(t, 15.0, second["total"].Rates.RxDropped.Value+second["total"].Rates.TxDropped.Value)
+	assert.Nil(t, second["total"].Counters)
+}
+
+func TestReadNetworkMetricElapsedAndWrap(t *testing.T) {
+	reader := &TestReader{}
+	reader.On("ReadFile", testProcPaths.NetworkDeviceIo()).Return("  eth0: 4294967000 0 0 0 0 0 0 0 1000000 0 0 0 0 0 0 0\n", nil).Once()
+	reader.On("ReadFile", testProcPaths.NetworkDeviceIo()).Return("  eth0: 704 0 0 0 0 0 0 0 10 0 0 0 0 0 0 0\n", nil).Once()
+
+	snap := &model.NetSnap{}
+	start := time.Now()
+	ReadNetworkMetric(reader, snap, start)
+	// 采样晚了 , 按实际的 4 秒计算
+	second := ReadNetworkMetric(reader, snap, start.Add(4*time.Second))
+	// 32 位计数溢出 : (2^32 - 4294967000) + 704 = 1000 , 除以 4 秒
+	assert.Equal(t, model.MetricUnit{Value: 250, Unit: model.BSecond}, second["eth0"].Incoming)
+	// 网卡重置 , 不算速率
+	assert.Equal(t, 0.0, second["eth0"].Outgoing.Value)
+}
+
+func TestReadDiskIoStats(t *testing.T) {
+	reader := &TestReader{}
+	reader.On("ReadFile", testProcPaths.StorageDeviceIo()).Return(
+		"   1       0 ram0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n"+
+			"   8       0 sda 100 10 1000 500 50 5 800 1000 0 1000 1500 0 0 0 0 0 0\n", nil).Once()
+	reader.On("ReadFile", testProcPaths.StorageDeviceIo()).Return(
+		"   1       0 ram0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n"+
+			"   8       0 sda 300 30 5000 1500 150 5 800 3000 2 2000 3500 0 0 0 0 0 0\n", nil).Once()
+
+	snap := &model.DiskSnap{}
+	start := time.Now()
+	first := model.StorageMetric{}
+	readDiskIoStats(reader, first, snap, start)
+	// 没有挂载的设备也有 , 从来没有读写过的设备没有
+	assert.NotContains(t, first, "ram0")
+	assert.Equal(t, 0.0, first["sda"].Io.ReadIops.Value)
+	assert.Equal(t, uint64(1000*512), first["sda"].Io.ReadBytes)
+
+	second := model.StorageMetric{}
+	readDiskIoStats(reader, second, snap, start.Add(2*time.Second))
+	io := second["sda"].Io
+	assert.Equal(t, model.MetricUnit{Value: 100, Unit: model.PerSec}, io.ReadIops)
+	assert.Equal(t, 10.0, io.ReadMerges.Value)
+	assert.Equal(t, model.MetricUnit{Value: 5, Unit: model.Ms}, io.ReadAwait)
+	assert.Equal(t, 20.0, io.WriteAwait.Value)
+	assert.Equal(t, 10.0, io.Await.Value)
+	assert.Equal(t, 1.0, io.QueueDepth)
+	assert.Equal(t, model.MetricUnit{Value: 50, Unit: model.Percent}, io.Util)
+	assert.Equal(t, uint64(2), io.InFlight)
+	assert.Equal(t, 0.0, second["sda"].Write.Value)
+	readRate, readUnit := utils.ConvertBytes(4000*512/2, model.BSecond)
+	assert.Equal(t, model.MetricUnit{Value: readRate, Unit: readUnit}, second["sda"].Read)
+}
+
 func TestReadLocalTimeZone(t *testing.T) {
 	testCases := []struct {
 		testName        string
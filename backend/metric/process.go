@@ -0,0 +1,192 @@
+//go:build linux
+
+package metric
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+const (
+	CollectorProcessMetric = "process_metric"
+	ProcessTopCount        = 10 // cpu 和内存各返回前多少个进程
+)
+
+type processSample struct {
+	pid       int
+	name      string
+	state     string
+	cycles    uint64 // utime+stime , 单位是时间片
+	startTime uint64
+	threads   int
+	rssBytes  uint64
+}
+
+func (p *processSample) key() string {
+	return strconv.Itoa(p.pid) + "/" + strconv.FormatUint(p.startTime, 10)
+}
+
+// parseProcessStat 解析 /proc/[pid]/stat , 进程名在括号里 , 可能包含空格和括号 , 所以从最后一个 ')' 开始切分 :
+// 1234 (dnsmasq) S 1 1234 1234 0 -1 4194560 ...
+func parseProcessStat(raw string) (processSample, error) {
+	result := processSample{}
+	start := strings.IndexByte(raw, '(')
+	end := strings.LastIndexByte(raw, ')')
+	if start < 0 || end < start {
+		return result, fmt.Errorf("invalid process stat %q", raw)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(raw[:start]))
+	if err != nil {
+		return result, err
+	}
+	// 从 state (第3列) 开始 , 下标 0 对应第3列
+	fields := strings.Fields(raw[end+1:])
+	if len(fields) < 20 {
+		return result, fmt.Errorf("invalid process stat %q", raw)
+	}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	threads, _ := strconv.Atoi(fields[17])
+	startTime, _ := strconv.ParseUint(fields[19], 10, 64)
+
+	result.pid = pid
+	result.name = raw[start+1 : end]
+	result.state = fields[0]
+	result.cycles = utime + stime
+	result.threads = threads
+	result.startTime = startTime
+	return result, nil
+}
+
+// readProcessStatus 从 /proc/[pid]/status 补充完整的进程名和内存 , 内核线程没有 VmRSS
+func readProcessStatus(reader FsReaderInterface, sample *processSample) {
+	raw, err := reader.ReadFile(procPaths.ProcessStatus(sample.pid))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Name":
+			sample.name = value
+		case "VmRSS":
+			kB, _ := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+			sample.rssBytes = kB * 1024
+		case "Threads":
+			if threads, err := strconv.Atoi(value); err == nil {
+				sample.threads = threads
+			}
+		}
+	}
+}
+
+func readProcessCmdline(reader FsReaderInterface, pid int) string {
+	raw, err := reader.ReadFile(procPaths.ProcessCmdline(pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(strings.TrimRight(raw, "\x00"), "\x00", " "))
+}
+
+func readProcessOpenFds(reader FsReaderInterface, pid int) int {
+	names, err := reader.ReadDir(procPaths.ProcessFdDir(pid))
+	if err != nil {
+		return -1
+	}
+	return len(names)
+}
+
+// ReadProcessMetric 读取所有进程 , cpu 占用是和上一次采样的差值 , 命令行和打开的文件数只读取排在前面的进程
+func ReadProcessMetric(reader FsReaderInterface, snap *model.ProcessSnap) (model.ProcessesMetric, error) {
+	result := model.ProcessesMetric{}
+	names, err := reader.ReadDir(procPaths.ProcessDir())
+	if err != nil {
+		return result, err
+	}
+	allCycles, _, _, err := readCpuIdle(reader)
+	if err != nil {
+		return result, err
+	}
+
+	var samples []processSample
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		// 进程随时可能退出 , 读不到就跳过
+		raw, err := reader.ReadFile(procPaths.ProcessStat(pid))
+		if err != nil {
+			continue
+		}
+		sample, err := parseProcessStat(raw)
+		if err != nil {
+			continue
+		}
+		readProcessStatus(reader, &sample)
+		samples = append(samples, sample)
+	}
+	if len(samples) == 0 {
+		return result, errors.New("no process found in " + procPaths.ProcessDir())
+	}
+
+	now := time.Now()
+	firstSample := snap.Cycles == nil || allCycles <= snap.AllCycles
+	if !firstSample {
+		result.CpuSampleSeconds = now.Sub(snap.SampleAt).Seconds()
+	}
+	cycles := make(map[string]uint64, len(samples))
+	processes := make([]model.ProcessMetric, 0, len(samples))
+	for _, sample := range samples {
+		key := sample.key()
+		cycles[key] = sample.cycles
+		cpuPercent := 0.0
+		// 上一次没有的进程是之后才启动的 , 所有时间片都在这次的采样间隔里
+		if lastCycles := snap.Cycles[key]; !firstSample && sample.cycles >= lastCycles {
+			cpuPercent = float64(sample.cycles-lastCycles) / float64(allCycles-snap.AllCycles) * 100
+		}
+		result.TotalThreads += sample.threads
+		processes = append(processes, model.ProcessMetric{
+			Pid:        sample.pid,
+			Name:       sample.name,
+			State:      sample.state,
+			RssBytes:   sample.rssBytes,
+			CpuPercent: cpuPercent,
+			Threads:    sample.threads,
+		})
+	}
+	snap.SampleAt = now
+	snap.AllCycles = allCycles
+	snap.Cycles = cycles
+	result.TotalProcesses = len(processes)
+
+	result.TopCpu = topProcesses(reader, processes, func(a, b model.ProcessMetric) int {
+		return cmp.Or(cmp.Compare(b.CpuPercent, a.CpuPercent), cmp.Compare(b.RssBytes, a.RssBytes), cmp.Compare(a.Pid, b.Pid))
+	})
+	result.TopMemory = topProcesses(reader, processes, func(a, b model.ProcessMetric) int {
+		return cmp.Or(cmp.Compare(b.RssBytes, a.RssBytes), cmp.Compare(b.CpuPercent, a.CpuPercent), cmp.Compare(a.Pid, b.Pid))
+	})
+	return result, nil
+}
+
+func topProcesses(reader FsReaderInterface, processes []model.ProcessMetric, compare func(a, b model.ProcessMetric) int) []model.ProcessMetric {
+	sorted := slices.Clone(processes)
+	slices.SortFunc(sorted, compare)
+	top := sorted[:min(len(sorted), ProcessTopCount)]
+	for index := range top {
+		top[index].Cmdline = readProcessCmdline(reader, top[index].Pid)
+		top[index].OpenFds = readProcessOpenFds(reader, top[index].Pid)
+	}
+	return top
+}
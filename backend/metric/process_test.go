@@ -0,0 +1,104 @@
+//go:build linux
+
+package metric
+
+import (
+	"testing"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcessStat(t *testing.T) {
+	sample, err := parseProcessStat("1234 (my (odd) name) S 1 1234 1234 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 3 0 8812 5177344 300 18446744073709551615")
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, sample.pid)
+	assert.Equal(t, "my (odd) name", sample.name)
+	assert.Equal(t, "S", sample.state)
+	assert.Equal(t, uint64(300), sample.cycles)
+	assert.Equal(t, 3, sample.threads)
+	assert.Equal(t, uint64(8812), sample.startTime)
+
+	_, err = parseProcessStat("1234 (short) S 1")
+	assert.Error(t, err)
+}
+
+type fakeProcess struct {
+	pid       string
+	name      string
+	utime     string
+	startTime string
+	rssKb     string
+	cmdline   string
+	fds       int
+}
+
+func writeFakeProcs(t *testing.T, fs afero.Fs, cpuTotal string, processes []fakeProcess) {
+	assert.NoError(t, fs.RemoveAll("/proc"))
+	assert.NoError(t, afero.WriteFile(fs, "/proc/stat", []byte("cpu  "+cpuTotal+" 0 0 0 0 0 0 0 0 0\ncpu0 1 0 0 0 0 0 0 0 0 0\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/proc/self", nil, 0644))
+	for _, process := range processes {
+		dir := "/proc/" + process.pid
+		stat := process.pid + " (" + process.name + ") S 1 1 1 0 -1 0 0 0 0 0 " + process.utime + " 0 0 0 20 0 1 0 " + process.startTime + " 0 0"
+		assert.NoError(t, afero.WriteFile(fs, dir+"/stat", []byte(stat), 0644))
+		status := "Name:\t" + process.name + "\nState:\tS (sleeping)\nThreads:\t1\n"
+		if process.rssKb != "" {
+			status += "VmRSS:\t " + process.rssKb + " kB\n"
+		}
+		assert.NoError(t, afero.WriteFile(fs, dir+"/status", []byte(status), 0644))
+		assert.NoError(t, afero.WriteFile(fs, dir+"/cmdline", []byte(process.cmdline), 0644))
+		for index := range process.fds {
+			assert.NoError(t, afero.WriteFile(fs, dir+"/fd/"+string(rune('0'+index)), nil, 0644))
+		}
+	}
+}
+
+func TestReadProcessMetric(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+	snap := model.ProcessSnap{}
+
+	writeFakeProcs(t, fs, "1000", []fakeProcess{
+		{pid: "1", name: "procd", utime: "10", startTime: "1", rssKb: "1024", cmdline: "/sbin/procd\x00", fds: 3},
+		{pid: "200", name: "dnsmasq", utime: "100", startTime: "50", rssKb: "4096", cmdline: "/usr/sbin/dnsmasq\x00-C\x00/var/etc/dnsmasq.conf\x00", fds: 2},
+		{pid: "300", name: "kworker/0:1", utime: "5", startTime: "60"},
+	})
+	first, err := ReadProcessMetric(reader, &snap)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, first.TotalProcesses)
+	assert.Equal(t, 3, first.TotalThreads)
+	assert.Zero(t, first.CpuSampleSeconds)
+	for _, process := range first.TopCpu {
+		assert.Zero(t, process.CpuPercent)
+	}
+	assert.Equal(t, "dnsmasq", first.TopMemory[0].Name)
+	assert.Equal(t, uint64(4096*1024), first.TopMemory[0].RssBytes)
+	assert.Equal(t, "/usr/sbin/dnsmasq -C /var/etc/dnsmasq.conf", first.TopMemory[0].Cmdline)
+	assert.Equal(t, 2, first.TopMemory[0].OpenFds)
+
+	// dnsmasq 用了 50 个时间片 , pid 300 被复用成新的进程 , 它的时间片都在这次的采样间隔里
+	writeFakeProcs(t, fs, "1200", []fakeProcess{
+		{pid: "1", name: "procd", utime: "10", startTime: "1", rssKb: "1024", cmdline: "/sbin/procd\x00", fds: 3},
+		{pid: "200", name: "dnsmasq", utime: "150", startTime: "50", rssKb: "4096", cmdline: "/usr/sbin/dnsmasq\x00", fds: 2},
+		{pid: "300", name: "adblock", utime: "20", startTime: "900", rssKb: "2048", cmdline: "/bin/sh\x00/etc/init.d/adblock\x00"},
+	})
+	second, err := ReadProcessMetric(reader, &snap)
+	assert.NoError(t, err)
+	assert.Greater(t, second.CpuSampleSeconds, 0.0)
+	assert.Equal(t, "dnsmasq", second.TopCpu[0].Name)
+	assert.InDelta(t, 25.0, second.TopCpu[0].CpuPercent, 0.001)
+	assert.Equal(t, "adblock", second.TopCpu[1].Name)
+	assert.InDelta(t, 10.0, second.TopCpu[1].CpuPercent, 0.001)
+	assert.Equal(t, "procd", second.TopCpu[2].Name)
+	assert.Zero(t, second.TopCpu[2].CpuPercent)
+	// 没有 fd 目录时为 -1
+	assert.Equal(t, -1, second.TopCpu[1].OpenFds)
+}
+
+func TestReadProcessMetricNoProc(t *testing.T) {
+	snap := model.ProcessSnap{}
+	_, err := ReadProcessMetric(FsReader{Fs: afero.NewMemMapFs()}, &snap)
+	assert.Error(t, err)
+}
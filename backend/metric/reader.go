@@ -11,6 +11,7 @@ type FsReaderInterface interface {
 	ReadFile(path string) (string, error)
 	Exists(path string) bool
 	Open(path string) (io.ReadCloser, error)
+	ReadDir(path string) ([]string, error)
 }
 
 // type FsReader struct {
@@ -36,3 +37,13 @@ func (r FsReader) Exists(path string) bool {
 func (r FsReader) Open(path string) (io.ReadCloser, error) {
 	return r.Fs.Open(path)
 }
+
+// ReadDir 只返回文件名 , 不 stat 每个文件 , /proc/[pid]/fd 这种目录会快很多
+func (r FsReader) ReadDir(path string) ([]string, error) {
+	dir, err := r.Fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
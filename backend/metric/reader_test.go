@@ -83,3 +83,17 @@ func TestReaderExists(t *testing.T) {
 	exists = reader.Exists("/path/notExist")
 	assert.Equal(t, false, exists)
 }
+
+func TestReaderReadDir(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/proc/1/fd/0", nil, 0666))
+	assert.NoError(t, afero.WriteFile(fs, "/proc/1/fd/1", nil, 0666))
+	reader := FsReader{Fs: fs}
+
+	names, err := reader.ReadDir("/proc/1/fd")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0", "1"}, names)
+
+	_, err = reader.ReadDir("/proc/2/fd")
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+//go:build linux
+
+package metric
+
+import (
+	"cmp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	CollectorEbpfFrame        = "ebpf_frame"
+	CollectorEbpfCleanupFlows = "ebpf_cleanup_flows"
+	EbpfFlowMapMaxEntries     = 32768 // 与 monitor.c 里 flow_map 的 max_entries 一致 , 读不到 map 信息时使用
+)
+
+type collectorTiming struct {
+	runs      uint64
+	last      time.Duration
+	max       time.Duration
+	total     time.Duration
+	lastRunAt time.Time
+}
+
+// collectorTimingRecorder 记录每个采集器的耗时 , 零值可用 , nil 时什么都不做
+type collectorTimingRecorder struct {
+	mutex   sync.Mutex
+	timings map[string]*collectorTiming
+}
+
+// observe 用法 : defer recorder.observe(name, time.Now())
+func (r *collectorTimingRecorder) observe(name string, start time.Time) {
+	if r == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.timings == nil {
+		r.timings = make(map[string]*collectorTiming)
+	}
+	timing, ok := r.timings[name]
+	if !ok {
+		timing = &collectorTiming{}
+		r.timings[name] = timing
+	}
+	timing.runs++
+	timing.last = elapsed
+	timing.max = max(timing.max, elapsed)
+	timing.total += elapsed
+	timing.lastRunAt = start
+}
+
+func (r *collectorTimingRecorder) list() []model.CollectorTiming {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := make([]model.CollectorTiming, 0, len(r.timings))
+	for name, timing := range r.timings {
+		result = append(result, model.CollectorTiming{
+			Name:         name,
+			Runs:         timing.runs,
+			LastSeconds:  timing.last.Seconds(),
+			AvgSeconds:   timing.total.Seconds() / float64(timing.runs),
+			MaxSeconds:   timing.max.Seconds(),
+			TotalSeconds: timing.total.Seconds(),
+			LastRunAt:    timing.lastRunAt,
+		})
+	}
+	return result
+}
+
+type jsonCacheCounter struct {
+	hits      atomic.Uint64
+	stale     atomic.Uint64
+	misses    atomic.Uint64
+	refreshes atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// jsonCacheCounters 按缓存 key 统计 GetJsonBytes 的命中情况 , 零值可用
+type jsonCacheCounters struct {
+	counters sync.Map // key -> *jsonCacheCounter
+}
+
+func (c *jsonCacheCounters) get(key string) *jsonCacheCounter {
+	if counter, ok := c.counters.Load(key); ok {
+		return counter.(*jsonCacheCounter)
+	}
+	counter, _ := c.counters.LoadOrStore(key, &jsonCacheCounter{})
+	return counter.(*jsonCacheCounter)
+}
+
+func (c *jsonCacheCounters) list() (result []model.JsonCacheCounter, dropped uint64) {
+	c.counters.Range(func(rawKey, rawCounter any) bool {
+		counter := rawCounter.(*jsonCacheCounter)
+		item := model.JsonCacheCounter{
+			Key:       rawKey.(string),
+			Hits:      counter.hits.Load(),
+			Stale:     counter.stale.Load(),
+			Misses:    counter.misses.Load(),
+			Refreshes: counter.refreshes.Load(),
+			Dropped:   counter.dropped.Load(),
+		}
+		dropped += item.Dropped
+		result = append(result, item)
+		return true
+	})
+	slices.SortFunc(result, func(a, b model.JsonCacheCounter) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return result, dropped
+}
+
+// ReadSelfProcessMetric 读取 /proc/self/status , 比 runtime.MemStats 更接近 OOM killer 看到的内存
+func ReadSelfProcessMetric(reader FsReaderInterface) model.SelfProcessMetric {
+	result := model.SelfProcessMetric{}
+	raw, err := reader.ReadFile(procPaths.SelfStatus())
+	if err == nil {
+		for _, line := range strings.Split(raw, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			switch fields[0] {
+			case "VmRSS:":
+				result.RssBytes = value * 1024
+			case "VmHWM:":
+				result.PeakRssBytes = value * 1024
+			case "VmSize:":
+				result.VirtualBytes = value * 1024
+			case "Threads:":
+				result.Threads = int(value)
+			}
+		}
+	}
+
+	var usage unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &usage); err == nil {
+		result.CpuUserSeconds = time.Duration(usage.Utime.Nano()).Seconds()
+		result.CpuSystemSeconds = time.Duration(usage.Stime.Nano()).Seconds()
+	}
+	return result
+}
+
+func readSelfRuntimeMetric() model.SelfRuntimeMetric {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	result := model.SelfRuntimeMetric{
+		Goroutines:          runtime.NumGoroutine(),
+		HeapAllocBytes:      stats.HeapAlloc,
+		HeapSysBytes:        stats.HeapSys,
+		SysBytes:            stats.Sys,
+		NumGc:               stats.NumGC,
+		GcPauseTotalSeconds: time.Duration(stats.PauseTotalNs).Seconds(),
+		GcCpuFraction:       stats.GCCPUFraction,
+	}
+	if stats.NumGC == 0 {
+		return result
+	}
+	// PauseNs 是环形缓冲区 , 最近一次在 (NumGC+255)%256
+	result.GcPauseLastSeconds = time.Duration(stats.PauseNs[(stats.NumGC+255)%256]).Seconds()
+	for _, pause := range stats.PauseNs[:min(stats.NumGC, uint32(len(stats.PauseNs)))] {
+		result.GcPauseMaxSeconds = max(result.GcPauseMaxSeconds, time.Duration(pause).Seconds())
+	}
+	result.LastGcAt = time.Unix(0, int64(stats.LastGC))
+	return result
+}
+
+// FlowMapUsage flow_map 的条目数在每一帧遍历时顺便统计 , 不额外遍历内核 map
+func (svc *EbpfNetTrafficService) FlowMapUsage() model.EbpfMapUsage {
+	maxEntries := EbpfFlowMapMaxEntries
+	if svc.objs != nil && svc.objs.FlowMap != nil {
+		maxEntries = int(svc.objs.FlowMap.MaxEntries())
+	}
+	entries := int(atomic.LoadInt64(&svc.flowMapEntries))
+	return model.EbpfMapUsage{
+		Entries:     entries,
+		MaxEntries:  maxEntries,
+		UsedPercent: float64(entries) / float64(maxEntries) * 100,
+	}
+}
+
+func (b *BackgroundService) SelfMetric() model.SelfMetric {
+	jsonCache, dropped := b.jsonCacheCounters.list()
+	collectors := b.collectorTiming.list()
+	flowMap := model.EbpfMapUsage{MaxEntries: EbpfFlowMapMaxEntries}
+	if svc := b.ebpfService.Load(); svc != nil {
+		collectors = append(collectors, svc.collectorTiming.list()...)
+		flowMap = svc.FlowMapUsage()
+	}
+	slices.SortFunc(collectors, func(a, b model.CollectorTiming) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return model.SelfMetric{
+		GeneratedAt:         time.Now().UTC(),
+		Process:             ReadSelfProcessMetric(b.Reader),
+		Runtime:             readSelfRuntimeMetric(),
+		Collectors:          collectors,
+		JsonCache:           jsonCache,
+		DroppedUpdateEvents: dropped,
+		FlowMap:             flowMap,
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetJsonBytesCounters(t *testing.T) {
+	b := &BackgroundService{Reader: FsReader{Fs: afero.NewMemMapFs()}, UpdateEventChan: make(chan string, 1)}
+	b.GetJsonBytes(model.JsonCacheKeyStaticMetric)
+
+	b.setJsonBytes(model.JsonCacheKeyStaticMetric, time.Minute, []byte("{}"))
+	b.GetJsonBytes(model.JsonCacheKeyStaticMetric)
+	b.GetJsonBytes(model.JsonCacheKeyStaticMetric)
+
+	// 过期后第一次触发刷新 , 队列满时刷新事件被丢弃
+	b.setJsonBytes(model.JsonCacheKeyDynamicMetric, -time.Second, []byte("{}"))
+	b.setJsonBytes(model.JsonCacheKeyAggregationTraffic, -time.Second, []byte("{}"))
+	b.GetJsonBytes(model.JsonCacheKeyDynamicMetric)
+	b.GetJsonBytes(model.JsonCacheKeyAggregationTraffic)
+	assert.Equal(t, model.JsonCacheKeyDynamicMetric, <-b.UpdateEventChan)
+
+	metric := b.SelfMetric()
+	assert.Equal(t, []model.JsonCacheCounter{
+		{Key: model.JsonCacheKeyAggregationTraffic, Stale: 1, Refreshes: 1, Dropped: 1},
+		{Key: model.JsonCacheKeyDynamicMetric, Stale: 1, Refreshes: 1},
+		{Key: model.JsonCacheKeyStaticMetric, Hits: 2, Misses: 1, Refreshes: 1},
+	}, metric.JsonCache)
+	assert.Equal(t, uint64(1), metric.DroppedUpdateEvents)
+	assert.Equal(t, EbpfFlowMapMaxEntries, metric.FlowMap.MaxEntries)
+	assert.Positive(t, metric.Runtime.Goroutines)
+}
+
+func TestCollectorTimingRecorder(t *testing.T) {
+	var recorder collectorTimingRecorder
+	start := time.Now()
+	recorder.observe(CollectorNetworkConnection, start.Add(-3*time.Second))
+	recorder.observe(CollectorNetworkConnection, start.Add(-1*time.Second))
+
+	timings := recorder.list()
+	assert.Len(t, timings, 1)
+	assert.Equal(t, uint64(2), timings[0].Runs)
+	assert.InDelta(t, 3, timings[0].MaxSeconds, 0.5)
+	assert.InDelta(t, 1, timings[0].LastSeconds, 0.5)
+	assert.InDelta(t, 2, timings[0].AvgSeconds, 0.5)
+
+	// nil 时不记录
+	var nilRecorder *collectorTimingRecorder
+	nilRecorder.observe(CollectorDynamicMetric, start)
+}
+
+func TestReadSelfProcessMetric(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, testProcPaths.SelfStatus(), []byte(
+		"Name:\tdiskio-api\nVmHWM:\t   24576 kB\nVmRSS:\t   20480 kB\nVmSize:\t  716800 kB\nThreads:\t7\n",
+	), 0o644)
+
+	metric := ReadSelfProcessMetric(FsReader{Fs: fs})
+	assert.Equal(t, uint64(20480*1024), metric.RssBytes)
+	assert.Equal(t, uint64(24576*1024), metric.PeakRssBytes)
+	assert.Equal(t, uint64(716800*1024), metric.VirtualBytes)
+	assert.Equal(t, 7, metric.Threads)
+}
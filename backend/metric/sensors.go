@@ -0,0 +1,277 @@
+//go:build linux
+
+package metric
+
+import (
+	"cmp"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"openwrt-diskio-api/backend/model"
+)
+
+var (
+	hwmonInputPattern  = regexp.MustCompile(`^(temp|fan|in)(\d+)_input$`)
+	tripPointPattern   = regexp.MustCompile(`^trip_point_(\d+)_type$`)
+	hwmonTripPointList = []string{"lcrit", "min", "max", "crit", "emergency"}
+
+	cpuThermalZonePattern  = regexp.MustCompile(`^cpu(\d+)[-_]thermal$`)
+	coretempCorePattern    = regexp.MustCompile(`^Core (\d+)$`)
+	coretempPackagePattern = regexp.MustCompile(`^Package id (\d+)$`)
+)
+
+type hwmonKind struct {
+	kind    model.HwmonSensorKind
+	unit    string
+	divisor float64 // sysfs 里温度是毫摄氏度 , 电压是毫伏 , 风扇就是转速
+}
+
+var hwmonKinds = map[string]hwmonKind{
+	"temp": {model.HwmonSensorTemperature, model.Celsius, 1000},
+	"fan":  {model.HwmonSensorFan, model.Rpm, 1},
+	"in":   {model.HwmonSensorVoltage, model.Volt, 1000},
+}
+
+// compareNumbered 按名字末尾的编号排序 , 这样 thermal_zone10 排在 thermal_zone9 后面
+func compareNumbered(a string, b string) int {
+	prefixA, numberA := splitNumberSuffix(a)
+	prefixB, numberB := splitNumberSuffix(b)
+	return cmp.Or(cmp.Compare(prefixA, prefixB), cmp.Compare(numberA, numberB))
+}
+
+func splitNumberSuffix(name string) (string, int) {
+	prefix := strings.TrimRightFunc(name, func(r rune) bool { return r >= '0' && r <= '9' })
+	number, _ := strconv.Atoi(name[len(prefix):])
+	return prefix, number
+}
+
+// readSysfsValue 读取 sysfs 里的一个整数再除以 divisor , 读不到时返回 false
+func readSysfsValue(reader FsReaderInterface, file string, divisor float64) (float64, bool) {
+	raw, err := reader.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value / divisor, true
+}
+
+func readSysfsString(reader FsReaderInterface, file string) string {
+	raw, _ := reader.ReadFile(file)
+	return strings.TrimSpace(raw)
+}
+
+func readThermalZone(reader FsReaderInterface, dir string) model.ThermalZoneMetric {
+	result := model.ThermalZoneMetric{
+		Name:        path.Base(dir),
+		Type:        readSysfsString(reader, path.Join(dir, "type")),
+		Temperature: model.MetricUnit{Value: -1, Unit: model.Celsius},
+		TripPoints:  []model.TripPoint{},
+	}
+	if temperature, ok := readSysfsValue(reader, path.Join(dir, "temp"), 1000); ok {
+		result.Temperature.Value = temperature
+	}
+
+	files, _ := reader.ReadDir(dir)
+	var trips []string
+	for _, file := range files {
+		if match := tripPointPattern.FindStringSubmatch(file); match != nil {
+			trips = append(trips, match[1])
+		}
+	}
+	slices.SortFunc(trips, compareNumbered)
+	for _, trip := range trips {
+		prefix := path.Join(dir, "trip_point_"+trip)
+		temperature, ok := readSysfsValue(reader, prefix+"_temp", 1000)
+		if !ok {
+			continue
+		}
+		hysteresis, _ := readSysfsValue(reader, prefix+"_hyst", 1000)
+		result.TripPoints = append(result.TripPoints, model.TripPoint{
+			Type:       readSysfsString(reader, prefix+"_type"),
+			Value:      model.MetricUnit{Value: temperature, Unit: model.Celsius},
+			Hysteresis: hysteresis,
+		})
+	}
+	return result
+}
+
+// readHwmonSensors 读取一个目录下的 tempN , fanN , inN 传感器
+func readHwmonSensors(reader FsReaderInterface, dir string) []model.HwmonSensor {
+	files, _ := reader.ReadDir(dir)
+	var names []string
+	for _, file := range files {
+		if match := hwmonInputPattern.FindStringSubmatch(file); match != nil {
+			names = append(names, match[1]+match[2])
+		}
+	}
+	slices.SortFunc(names, compareNumbered)
+
+	sensors := []model.HwmonSensor{}
+	for _, name := range names {
+		prefix, _ := splitNumberSuffix(name)
+		kind := hwmonKinds[prefix]
+		sensor := model.HwmonSensor{
+			Name:       name,
+			Kind:       kind.kind,
+			Label:      readSysfsString(reader, path.Join(dir, name+"_label")),
+			Value:      model.MetricUnit{Value: -1, Unit: kind.unit},
+			TripPoints: []model.TripPoint{},
+		}
+		if sensor.Label == "" {
+			sensor.Label = name
+		}
+		if value, ok := readSysfsValue(reader, path.Join(dir, name+"_input"), kind.divisor); ok {
+			sensor.Value.Value = value
+		}
+		for _, trip := range hwmonTripPointList {
+			value, ok := readSysfsValue(reader, path.Join(dir, name+"_"+trip), kind.divisor)
+			if !ok {
+				continue
+			}
+			sensor.TripPoints = append(sensor.TripPoints, model.TripPoint{
+				Type:  trip,
+				Value: model.MetricUnit{Value: value, Unit: kind.unit},
+			})
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors
+}
+
+func readHwmon(reader FsReaderInterface, dir string) model.HwmonMetric {
+	result := model.HwmonMetric{
+		Name: path.Base(dir),
+		Chip: readSysfsString(reader, path.Join(dir, "name")),
+	}
+	result.Sensors = readHwmonSensors(reader, dir)
+	// 旧的驱动把传感器放在 device 目录下
+	if len(result.Sensors) == 0 {
+		result.Sensors = readHwmonSensors(reader, path.Join(dir, "device"))
+	}
+	// 无线网卡的 hwmon 的 device 是网卡本身 , 下面有 ieee80211/phyN
+	if phys, err := reader.ReadDir(path.Join(dir, "device", "ieee80211")); err == nil && len(phys) > 0 {
+		slices.SortFunc(phys, compareNumbered)
+		result.Phy = phys[0]
+	}
+	return result
+}
+
+// ReadSensorsMetric 读取所有的 thermal zone 和 hwmon 传感器 , 没有这两个目录的设备返回空列表
+func ReadSensorsMetric(reader FsReaderInterface) model.SensorsMetric {
+	result := model.SensorsMetric{
+		ThermalZones: []model.ThermalZoneMetric{},
+		Hwmon:        []model.HwmonMetric{},
+	}
+
+	zones, _ := reader.ReadDir(procPaths.ThermalDir())
+	slices.SortFunc(zones, compareNumbered)
+	for _, zone := range zones {
+		// 同一个目录下还有 cooling_deviceN
+		if !strings.HasPrefix(zone, "thermal_zone") {
+			continue
+		}
+		result.ThermalZones = append(result.ThermalZones, readThermalZone(reader, path.Join(procPaths.ThermalDir(), zone)))
+	}
+
+	devices, _ := reader.ReadDir(procPaths.HwmonDir())
+	slices.SortFunc(devices, compareNumbered)
+	for _, device := range devices {
+		if !strings.HasPrefix(device, "hwmon") {
+			continue
+		}
+		result.Hwmon = append(result.Hwmon, readHwmon(reader, path.Join(procPaths.HwmonDir(), device)))
+	}
+	return result
+}
+
+// readCoresTemperature 找每个核心自己的温度传感器 , 找不到的为 -1 :
+//   - 类型是 cpu0-thermal 这样的 thermal zone . 多数 arm 设备只有整个 cpu 簇共用的 zone , 这时都是 -1
+//   - x86 的 coretemp , 标签 Core N 里的 N 是物理核心编号 , 按 topology 找到对应的逻辑核心 , 超线程的两个核心读数相同
+func readCoresTemperature(reader FsReaderInterface, coreCount int) []float64 {
+	result := make([]float64, coreCount)
+	for index := range result {
+		result[index] = -1
+	}
+
+	zones, _ := reader.ReadDir(procPaths.ThermalDir())
+	for _, zone := range zones {
+		if !strings.HasPrefix(zone, "thermal_zone") {
+			continue
+		}
+		dir := path.Join(procPaths.ThermalDir(), zone)
+		match := cpuThermalZonePattern.FindStringSubmatch(readSysfsString(reader, path.Join(dir, "type")))
+		if match == nil {
+			continue
+		}
+		core, _ := strconv.Atoi(match[1])
+		if core >= coreCount {
+			continue
+		}
+		if temperature, ok := readSysfsValue(reader, path.Join(dir, "temp"), 1000); ok {
+			result[core] = temperature
+		}
+	}
+
+	physicalCores := readCoretemp(reader)
+	if len(physicalCores) == 0 {
+		return result
+	}
+	for core := range result {
+		if result[core] != -1 {
+			continue
+		}
+		coreId, ok := readSysfsValue(reader, procPaths.CpuTopology(core, "core_id"), 1)
+		if !ok {
+			continue
+		}
+		packageId, _ := readSysfsValue(reader, procPaths.CpuTopology(core, "physical_package_id"), 1)
+		if temperature, ok := physicalCores[[2]int{int(packageId), int(coreId)}]; ok {
+			result[core] = temperature
+		}
+	}
+	return result
+}
+
+// readCoretemp 每个 cpu 插槽有一个 coretemp 的 hwmon , key 是插槽编号和物理核心编号
+func readCoretemp(reader FsReaderInterface) map[[2]int]float64 {
+	result := map[[2]int]float64{}
+	devices, _ := reader.ReadDir(procPaths.HwmonDir())
+	for _, device := range devices {
+		dir := path.Join(procPaths.HwmonDir(), device)
+		if readSysfsString(reader, path.Join(dir, "name")) != "coretemp" {
+			continue
+		}
+		files, _ := reader.ReadDir(dir)
+		packageId := 0
+		cores := map[int]float64{}
+		for _, file := range files {
+			name, found := strings.CutSuffix(file, "_label")
+			if !found || !strings.HasPrefix(name, "temp") {
+				continue
+			}
+			label := readSysfsString(reader, path.Join(dir, file))
+			if match := coretempPackagePattern.FindStringSubmatch(label); match != nil {
+				packageId, _ = strconv.Atoi(match[1])
+				continue
+			}
+			match := coretempCorePattern.FindStringSubmatch(label)
+			if match == nil {
+				continue
+			}
+			if temperature, ok := readSysfsValue(reader, path.Join(dir, name+"_input"), 1000); ok {
+				core, _ := strconv.Atoi(match[1])
+				cores[core] = temperature
+			}
+		}
+		for core, temperature := range cores {
+			result[[2]int{packageId, core}] = temperature
+		}
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package metric
+
+import (
+	"testing"
+
+	"openwrt-diskio-api/backend/model"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSensorsMetric(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+	files := map[string]string{
+		"/sys/class/thermal/thermal_zone0/type":              "cpu-thermal\n",
+		"/sys/class/thermal/thermal_zone0/temp":              "45500\n",
+		"/sys/class/thermal/thermal_zone0/trip_point_0_type": "passive\n",
+		"/sys/class/thermal/thermal_zone0/trip_point_0_temp": "85000\n",
+		"/sys/class/thermal/thermal_zone0/trip_point_0_hyst": "2000\n",
+		"/sys/class/thermal/thermal_zone10/type":             "wifi\n",
+		"/sys/class/thermal/cooling_device0/type":            "fan\n",
+		"/sys/class/hwmon/hwmon0/name":                       "aqr107\n",
+		"/sys/class/hwmon/hwmon0/temp1_input":                "92000\n",
+		"/sys/class/hwmon/hwmon0/temp1_crit":                 "108000\n",
+		"/sys/class/hwmon/hwmon0/temp1_label":                "PHY Temperature\n",
+		"/sys/class/hwmon/hwmon0/in0_input":                  "3300\n",
+		"/sys/class/hwmon/hwmon1/name":                       "mt7915_phy0\n",
+		"/sys/class/hwmon/hwmon1/temp1_input":                "51000\n",
+		"/sys/class/hwmon/hwmon1/device/ieee80211/phy0/name": "phy0\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, afero.WriteFile(fs, name, []byte(content), 0644))
+	}
+
+	result := ReadSensorsMetric(reader)
+	assert.Equal(t, []model.ThermalZoneMetric{
+		{
+			Name:        "thermal_zone0",
+			Type:        "cpu-thermal",
+			Temperature: model.MetricUnit{Value: 45.5, Unit: model.Celsius},
+			TripPoints: []model.TripPoint{
+				{Type: "passive", Value: model.MetricUnit{Value: 85, Unit: model.Celsius}, Hysteresis: 2},
+			},
+		},
+		{
+			Name:        "thermal_zone10",
+			Type:        "wifi",
+			Temperature: model.MetricUnit{Value: -1, Unit: model.Celsius},
+			TripPoints:  []model.TripPoint{},
+		},
+	}, result.ThermalZones)
+
+	assert.Len(t, result.Hwmon, 2)
+	phy := result.Hwmon[0]
+	assert.Equal(t, "aqr107", phy.Chip)
+	assert.Equal(t, []model.HwmonSensor{
+		{
+			Name: "in0", Kind: model.HwmonSensorVoltage, Label: "in0",
+			Value: model.MetricUnit{Value: 3.3, Unit: model.Volt}, TripPoints: []model.TripPoint{},
+		},
+		{
+			Name: "temp1", Kind: model.HwmonSensorTemperature, Label: "PHY Temperature",
+			Value: model.MetricUnit{Value: 92, Unit: model.Celsius},
+			TripPoints: []model.TripPoint{
+				{Type: "crit", Value: model.MetricUnit{Value: 108, Unit: model.Celsius}},
+			},
+		},
+	}, phy.Sensors)
+
+	wifi := result.Hwmon[1]
+	assert.Equal(t, "mt7915_phy0", wifi.Chip)
+	assert.Equal(t, "phy0", wifi.Phy)
+	assert.Equal(t, 51.0, wifi.Sensors[0].Value.Value)
+}
+
+func TestReadCoresTemperature(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader := FsReader{Fs: fs}
+	files := map[string]string{
+		"/sys/class/thermal/thermal_zone0/type":                     "cpu0-thermal\n",
+		"/sys/class/thermal/thermal_zone0/temp":                     "41000\n",
+		"/sys/class/thermal/thermal_zone1/type":                     "cpu1-thermal\n",
+		"/sys/class/thermal/thermal_zone1/temp":                     "43000\n",
+		"/sys/class/thermal/thermal_zone2/type":                     "cpu9-thermal\n",
+		"/sys/class/thermal/thermal_zone2/temp":                     "99000\n",
+		"/sys/class/thermal/thermal_zone3/type":                     "soc-thermal\n",
+		"/sys/class/thermal/thermal_zone3/temp":                     "50000\n",
+		"/sys/class/hwmon/hwmon0/name":                              "coretemp\n",
+		"/sys/class/hwmon/hwmon0/temp1_label":                       "Package id 0\n",
+		"/sys/class/hwmon/hwmon0/temp1_input":                       "60000\n",
+		"/sys/class/hwmon/hwmon0/temp2_label":                       "Core 0\n",
+		"/sys/class/hwmon/hwmon0/temp2_input":                       "55000\n",
+		"/sys/class/hwmon/hwmon0/temp3_label":                       "Core 4\n",
+		"/sys/class/hwmon/hwmon0/temp3_input":                       "57000\n",
+		"/sys/devices/system/cpu/cpu2/topology/core_id":             "4\n",
+		"/sys/devices/system/cpu/cpu2/topology/physical_package_id": "0\n",
+		"/sys/devices/system/cpu/cpu3/topology/core_id":             "0\n",
+		"/sys/devices/system/cpu/cpu3/topology/physical_package_id": "0\n",
+		"/sys/devices/system/cpu/cpu4/topology/core_id":             "1\n",
+		"/sys/devices/system/cpu/cpu4/topology/physical_package_id": "0\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, afero.WriteFile(fs, name, []byte(content), 0644))
+	}
+
+	assert.Equal(t, []float64{41, 43, 57, 55, -1}, readCoresTemperature(reader, 5))
+	assert.Equal(t, []float64{-1, -1}, readCoresTemperature(FsReader{Fs: afero.NewMemMapFs()}, 2))
+}
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 const (
 	MinServiceRunDuration       = 35 * time.Second
@@ -9,6 +12,7 @@ const (
 	HttpServerReadTimeout       = 15 * time.Second // 完整请求体读取
 	HttpServerWriteTimeout      = 20 * time.Second // 响应写入
 	HttpServerIdleTimeout       = 60 * time.Second // Keep-Alive 空闲
+	EbpfStartupGracePeriod      = 2 * time.Minute  // 启动后这么久 ebpf 程序还没加载完才算不健康
 )
 
 const (
@@ -60,9 +64,17 @@ const (
 	TeraByte = "TB"
 	PetaByte = "PB"
 	Percent  = "%"
+	MHz      = "MHz"
 	Celsius  = "°C"
+	PerSec   = "/S" // 次数每秒
+	Ms       = "ms"
+	Rpm      = "RPM"
+	Volt     = "V"
 )
 
+// SectorSize /proc/diskstats 里的扇区数总是按 512 字节计算 , 和设备实际的扇区大小无关
+const SectorSize = 512
+
 var (
 	RateUnitList                        = []string{BSecond, KbSecond, MbSecond, GbSecond, TbSecond, PbSecond}
 	DataUnitList                        = []string{Byte, KiloByte, MegaByte, GigaByte, TeraByte, PetaByte}
@@ -71,13 +83,14 @@ var (
 	JsonCacheKeyDynamicMetric           = "DynamicMetric"
 	JsonCacheKeyNetworkConnectionMetric = "NetworkConnectionMetric"
 	JsonCacheKeyAggregationTraffic      = "AggregationTraffic"
+	JsonCacheKeyProcessMetric           = "ProcessMetric"
 )
 
 type CacheValue struct {
 	UpdateAt time.Time
 	ExpireAt time.Time
-	Data     []byte
-	IsGzip   bool
+	Data     []byte // 未压缩的 json
+	Gzip     []byte // Data 的 gzip 版本 , 只有超过 GzipThreshold 时才有
 }
 
 type CacheStringValue struct {
@@ -90,26 +103,65 @@ type CacheDnsValue struct {
 	Data     []string
 }
 
-type NetSnapUnit struct {
-	RxBytes float64
-	TxBytes float64
-}
+// NetSnap SampleAt 来自 time.Now() , 带有单调时钟的读数 , 两次采样相减得到的是实际经过的时间 , 为零表示还没有采样
 type NetSnap struct {
-	Interfaces map[string]NetSnapUnit
+	SampleAt   time.Time
+	Interfaces map[string]NetDevCounters
 }
 
 type CpuSnap struct {
 	AllCycles   uint64        // 所有核心时间片总和("cpu"一行)
 	AllCoreIdle uint64        // 所有核心idle总和("cpu"一行)
 	Cores       []CpuSnapUnit // 各核心时间片总和和idle("cpu0","cpu1"等行)
+	AllTimes    CpuTimes      // "cpu"一行的各项时间片
+	CoresTimes  []CpuTimes    // "cpu0","cpu1"等行的各项时间片
+	CoresFreq   []CpuFreqSnap // 各核心 cpufreq 的计数 , 下标是核心编号
+}
+
+type CpuFreqSnap struct {
+	TimeInState   map[uint64]uint64 // 频率 kHz -> 累计时间 , 单位 10ms
+	ThrottleCount uint64
 }
+
+// CpuTimes /proc/stat 里一行 cpu 的各项时间片 , guest 已经算在 user 里
+type CpuTimes struct {
+	User, Nice, System, Idle, Iowait, Irq, Softirq, Steal uint64
+}
+
+func (c CpuTimes) Total() uint64 {
+	return c.User + c.Nice + c.System + c.Idle + c.Iowait + c.Irq + c.Softirq + c.Steal
+}
+
 type CpuSnapUnit struct {
 	Cycles uint64
 	Idle   uint64
 }
 
-type DiskSnap map[string]DiskSnapUnit
-type DiskSnapUnit struct{ ReadBytes, WriteBytes float64 }
+type DiskSnap struct {
+	SampleAt time.Time // 同 NetSnap
+	Devices  map[string]DiskStatCounters
+}
+
+// DiskStatCounters /proc/diskstats 里一个设备的计数 , 时间的单位都是毫秒 , 扇区固定是 512 字节
+type DiskStatCounters struct {
+	Reads        uint64 // 完成的读请求
+	ReadMerges   uint64
+	ReadSectors  uint64
+	ReadTicks    uint64
+	Writes       uint64
+	WriteMerges  uint64
+	WriteSectors uint64
+	WriteTicks   uint64
+	InFlight     uint64 // 正在处理的请求 , 不是累计值
+	IoTicks      uint64 // 有请求在处理的时间
+	TimeInQueue  uint64 // 所有请求等待和处理时间的和
+}
+
+type ProcessSnap struct {
+	SampleAt  time.Time
+	AllCycles uint64            // 所有核心时间片总和("cpu"一行)
+	Cycles    map[string]uint64 // "pid/starttime" -> utime+stime , 带上启动时间防止 pid 复用
+}
 
 type DynamicMetric struct {
 	Storage StorageMetric `json:"storage"`
@@ -117,6 +169,37 @@ type DynamicMetric struct {
 	Network NetworkMetric `json:"network"`
 	Memory  MemoryMetric  `json:"memory"`
 	System  SystemMetric  `json:"system"`
+	Kernel  KernelMetric  `json:"kernel"`
+	Sensors SensorsMetric `json:"sensors"`
+}
+
+// FilesystemMetric key 是挂载点 , 例如 / , /overlay , /tmp . 同一个挂载点挂载了多次时只有最上面的一个 .
+// 容量变化得慢 , 跟随静态指标刷新 . 不包括网络文件系统和 fuse , 它们的服务端没有响应时 statfs 会一直阻塞
+type FilesystemMetric map[string]FilesystemUsage
+
+type FilesystemUsage struct {
+	Source      string     `json:"source"`    // /proc/mounts 的第一列 , 例如 /dev/sda1 , ubi0:rootfs_data , overlayfs:/overlay , tmpfs
+	FsType      string     `json:"fs_type"`   // ext4 , ubifs , jffs2 , overlay , tmpfs , squashfs ...
+	ReadOnly    bool       `json:"read_only"` // 文件系统出错后可能被内核重新挂载成只读
+	Total       MetricUnit `json:"total"`     // 同 df , 包括保留给 root 的空间
+	Used        MetricUnit `json:"used"`
+	Available   MetricUnit `json:"available"`    // 普通用户可用的空间
+	UsedPercent MetricUnit `json:"used_percent"` // 和 df 一样按 used / (used + available) 计算
+	Inodes      InodeUsage `json:"inodes"`
+	UbiVolume   *UbiVolume `json:"ubi_volume,omitempty"` // 只有能找到对应的 ubi 卷时才有 , overlay 是 upperdir 所在的卷
+}
+
+// InodeUsage ubifs , jffs2 和 squashfs 不统计 inode , 都是 0
+type InodeUsage struct {
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type UbiVolume struct {
+	Volume string `json:"volume"`        // ubi0_1
+	Name   string `json:"name"`          // 卷名 , 例如 rootfs_data
+	Mtd    string `json:"mtd,omitempty"` // ubi 设备所在的 mtd 分区名 , 例如 ubi
 }
 
 type NetworkConnectionMetric struct {
@@ -157,28 +240,53 @@ func (n *NetworkConnectionCounts) DivideAllCounts() {
 }
 
 type NetworkConnection struct {
-	IpFamily        string     `json:"ip_family"`
-	SourceIp        string     `json:"source_ip"`
-	SourcePort      int        `json:"source_port"`
-	DestinationIp   string     `json:"destination_ip"`
-	DestinationPort int        `json:"destination_port"`
-	Protocol        string     `json:"protocol"`
-	State           string     `json:"state"`
-	Traffic         MetricUnit `json:"traffic"`
-	Packets         int64      `json:"packets"`
+	IpFamily          string     `json:"ip_family"`
+	SourceIp          string     `json:"source_ip"`
+	SourcePort        int        `json:"source_port"`
+	DestinationIp     string     `json:"destination_ip"`
+	DestinationPort   int        `json:"destination_port"`
+	Protocol          string     `json:"protocol"`
+	State             string     `json:"state"`
+	Traffic           MetricUnit `json:"traffic"`
+	Packets           int64      `json:"packets"`
+	DestinationDomain string     `json:"destination_domain,omitempty"` // 客户端查询该 ip 时使用的域名 , 来自 passive dns
+	ServerName        string     `json:"server_name,omitempty"`        // TLS SNI 或 QUIC Initial 中的域名
+	DestinationGeo    *GeoIpInfo `json:"destination_geo,omitempty"`    // 目标地址的国家和 ASN , 需要配置 geoip 数据库
 }
 
+// StorageMetric key 是块设备名 , total 是所有设备读写速率的合计
 type StorageMetric map[string]StorageIoMetric
 
+// StorageIoMetric 块设备有读写速率 , 挂载的分区还有容量
 type StorageIoMetric struct {
-	Read  MetricUnit `json:"read"`
+	// 不在 /proc/diskstats 里的挂载分区没有读写速率 , value 为 -1 , unit 为空
+	Read MetricUnit `json:"read"`
+	// 不在 /proc/diskstats 里的挂载分区没有读写速率 , value 为 -1 , unit 为空
 	Write MetricUnit `json:"write"`
-	// if not read storage device usage , fill -1
+	// 没有读取到容量的块设备和 total 条目 , value 为 0 , unit 为空
 	Total MetricUnit `json:"total,omitempty"`
-	// if not read storage device usage , fill -1
+	// 没有读取到容量的块设备和 total 条目 , value 为 0 , unit 为空
 	Used MetricUnit `json:"used,omitempty"`
-	// if not read storage device usage , fill -1
+	// 没有读取到容量的块设备和 total 条目 , value 为 0 , unit 为空
 	UsedPercent MetricUnit `json:"used_percent,omitempty"`
+	// 只有 /proc/diskstats 里有的设备才有 , 包括没有挂载的磁盘 , 分区 , md 和 dm 设备
+	Io *DiskIoMetric `json:"io,omitempty"`
+}
+
+// DiskIoMetric 速率和耗时都是采样间隔里的平均值 , 第一次采样时都是 0
+type DiskIoMetric struct {
+	ReadIops    MetricUnit `json:"read_iops"`    // 每秒完成的读请求
+	WriteIops   MetricUnit `json:"write_iops"`   // 每秒完成的写请求
+	ReadMerges  MetricUnit `json:"read_merges"`  // 每秒合并到其他请求里的读请求
+	WriteMerges MetricUnit `json:"write_merges"` // 每秒合并到其他请求里的写请求
+	ReadAwait   MetricUnit `json:"read_await"`   // 读请求从排队到完成的平均耗时 , 间隔里没有请求时为 0
+	WriteAwait  MetricUnit `json:"write_await"`  // 写请求从排队到完成的平均耗时 , 间隔里没有请求时为 0
+	Await       MetricUnit `json:"await"`        // 读写请求合计的平均耗时
+	QueueDepth  float64    `json:"queue_depth"`  // 平均队列长度 , 同 iostat 的 aqu-sz
+	InFlight    uint64     `json:"in_flight"`    // 采样时正在处理的请求
+	Util        MetricUnit `json:"util"`         // 有请求在处理的时间占比 , 能并行处理请求的设备到 100% 也不一定饱和
+	ReadBytes   uint64     `json:"read_bytes"`   // 开机以来累计读取的字节
+	WriteBytes  uint64     `json:"write_bytes"`  // 开机以来累计写入的字节
 }
 
 func (s StorageMetric) SetTotal(read float64, readUnit string, write float64, writeUnit string) {
@@ -188,11 +296,38 @@ func (s StorageMetric) SetTotal(read float64, readUnit string, write float64, wr
 	}
 }
 
+// CpuMetric key 是 cpu0 , cpu1 这样的核心名 , total 是所有核心
 type CpuMetric map[string]CpuUsageMetric
 
+// CpuUsageMetric iowait , irq , softirq , steal 是占这个核心时间的百分比 , 已经包含在 usage 里
 type CpuUsageMetric struct {
-	Usage       MetricUnit `json:"usage"`
-	Temperature MetricUnit `json:"temperature"`
+	Usage       MetricUnit `json:"usage"`       // 新出现的核心没有上一次的采样 , value 为 -1
+	Temperature MetricUnit `json:"temperature"` // total 是 thermal_zone0 , 核心只有找到自己的传感器 (cpuN-thermal , coretemp) 时才有 , 否则 value 为 -1 , 所有传感器见 sensors
+	Iowait      MetricUnit `json:"iowait"`      // 新出现的核心没有上一次的采样 , value 为 -1
+	Irq         MetricUnit `json:"irq"`         // 硬中断
+	Softirq     MetricUnit `json:"softirq"`     // 软中断 , 单个核心很高一般是 NET_RX 没有分散到多个核心
+	Steal       MetricUnit `json:"steal"`       // 虚拟机被宿主机占用的时间
+
+	// 没有 cpufreq 的核心和 total 没有
+	Frequency *CpuFrequencyMetric `json:"frequency,omitempty"`
+}
+
+// CpuFrequencyMetric 来自 /sys/devices/system/cpu/cpuN/cpufreq , 同一个 policy 的核心读数相同
+type CpuFrequencyMetric struct {
+	Current        MetricUnit         `json:"current"`
+	Min            MetricUnit         `json:"min"`             // 当前策略允许的最低频率
+	Max            MetricUnit         `json:"max"`             // 当前策略允许的最高频率 , 温控降频时会被调低
+	HardwareMin    MetricUnit         `json:"hardware_min"`    // cpuinfo_min_freq
+	HardwareMax    MetricUnit         `json:"hardware_max"`    // cpuinfo_max_freq
+	Governor       string             `json:"governor"`        // schedutil , ondemand , performance ...
+	TimeInState    []FrequencyInState `json:"time_in_state"`   // 内核没有开 CONFIG_CPU_FREQ_STAT 时为空
+	Throttled      bool               `json:"throttled"`       // 策略的最高频率低于硬件最高频率
+	ThrottleEvents int64              `json:"throttle_events"` // 采样间隔里温控降频的次数 , 只有 x86 提供 , 没有或者第一次采样时为 -1
+}
+
+type FrequencyInState struct {
+	Frequency MetricUnit `json:"frequency"`
+	Seconds   float64    `json:"seconds"` // 采样间隔里在这个频率的秒数 , 第一次采样时为 -1
 }
 
 func (c CpuMetric) SetTotal(usage float64, usageUnit string, temperature float64, temperatureUnit string) {
@@ -202,11 +337,44 @@ func (c CpuMetric) SetTotal(usage float64, usageUnit string, temperature float64
 	}
 }
 
+// NetworkMetric key 是网卡名 , total 是所有网卡的合计
 type NetworkMetric map[string]NetworkIoMetric
 
 type NetworkIoMetric struct {
-	Incoming MetricUnit `json:"incoming"`
-	Outgoing MetricUnit `json:"outgoing"`
+	Incoming MetricUnit      `json:"incoming"`
+	Outgoing MetricUnit      `json:"outgoing"`
+	Rates    NetDevRates     `json:"rates"`              // total 是所有网卡的合计
+	Counters *NetDevCounters `json:"counters,omitempty"` // 开机以来的累计值 , total 没有
+}
+
+// NetDevCounters /proc/net/dev 里一个网卡的所有列 , 网卡重建后会从 0 开始
+type NetDevCounters struct {
+	RxBytes      uint64 `json:"rx_bytes"`
+	RxPackets    uint64 `json:"rx_packets"`
+	RxErrors     uint64 `json:"rx_errors"`
+	RxDropped    uint64 `json:"rx_dropped"`
+	RxFifo       uint64 `json:"rx_fifo"`
+	RxFrame      uint64 `json:"rx_frame"`
+	RxCompressed uint64 `json:"rx_compressed"`
+	RxMulticast  uint64 `json:"rx_multicast"`
+	TxBytes      uint64 `json:"tx_bytes"`
+	TxPackets    uint64 `json:"tx_packets"`
+	TxErrors     uint64 `json:"tx_errors"`
+	TxDropped    uint64 `json:"tx_dropped"`
+	TxFifo       uint64 `json:"tx_fifo"`
+	TxCollisions uint64 `json:"tx_collisions"`
+	TxCarrier    uint64 `json:"tx_carrier"`
+	TxCompressed uint64 `json:"tx_compressed"`
+}
+
+// NetDevRates 单位都是每秒次数 , 第一次采样或者计数器变小时为 0
+type NetDevRates struct {
+	RxPackets MetricUnit `json:"rx_packets"`
+	TxPackets MetricUnit `json:"tx_packets"`
+	RxErrors  MetricUnit `json:"rx_errors"`
+	TxErrors  MetricUnit `json:"tx_errors"`
+	RxDropped MetricUnit `json:"rx_dropped"`
+	TxDropped MetricUnit `json:"tx_dropped"`
 }
 
 func (c NetworkMetric) SetTotal(incoming float64, incomingUnit string, outgoing float64, outgoingUnit string) {
@@ -226,18 +394,139 @@ type SystemMetric struct {
 	Uptime string `json:"uptime"`
 }
 
+type LoadAverage struct {
+	Load1        float64 `json:"load1"`
+	Load5        float64 `json:"load5"`
+	Load15       float64 `json:"load15"`
+	RunningTasks int     `json:"running_tasks"` // 可运行的任务数 , 包括线程
+	TotalTasks   int     `json:"total_tasks"`
+}
+
+type InterruptMetric struct {
+	Name        string     `json:"name"`                  // irq 号 , 或者 NMI , LOC 这样的名字
+	Description string     `json:"description,omitempty"` // 中断控制器和设备 , 例如 GICv2 30 Level arch_timer
+	Rate        MetricUnit `json:"rate"`                  // 所有核心每秒的次数
+	CoresRate   []float64  `json:"cores_rate"`            // 每个核心每秒的次数 , 下标是核心编号
+}
+
+type SoftirqMetric struct {
+	Name      string     `json:"name"` // HI , TIMER , NET_TX , NET_RX , BLOCK , IRQ_POLL , TASKLET , SCHED , HRTIMER , RCU
+	Rate      MetricUnit `json:"rate"`
+	CoresRate []float64  `json:"cores_rate"`
+}
+
+// KernelMetric 第一次采样没有上一次的计数 , 所有速率都是 0 , 计数器变小时那一项的速率也是 0
+type KernelMetric struct {
+	LoadAverage     LoadAverage       `json:"load_average"`
+	ContextSwitches MetricUnit        `json:"context_switches"` // 每秒上下文切换次数
+	Forks           MetricUnit        `json:"forks"`            // 每秒创建的进程和线程数
+	Interrupts      []InterruptMetric `json:"interrupts"`       // 按每秒次数从高到低 , 不包含从来没有触发过的
+	Softirqs        []SoftirqMetric   `json:"softirqs"`         // 按每秒次数从高到低
+}
+
+type KernelSnap struct {
+	SampleAt        time.Time // 同 NetSnap
+	ContextSwitches uint64
+	Forks           uint64
+	Interrupts      map[string][]uint64 // 名字 -> 每个核心的计数
+	Softirqs        map[string][]uint64
+}
+
+// SensorsMetric 所有的 thermal zone 和 hwmon 传感器 , 按名字里的编号排序
+type SensorsMetric struct {
+	ThermalZones []ThermalZoneMetric `json:"thermal_zones"`
+	Hwmon        []HwmonMetric       `json:"hwmon"`
+}
+
+type ThermalZoneMetric struct {
+	Name        string      `json:"name"`        // thermal_zone0
+	Type        string      `json:"type"`        // 驱动给的名字 , 例如 cpu-thermal , soc_thermal
+	Temperature MetricUnit  `json:"temperature"` // 关闭的 zone 读不到温度 , value 为 -1
+	TripPoints  []TripPoint `json:"trip_points"`
+}
+
+// TripPoint 传感器的阈值 , 单位和传感器的读数一样
+type TripPoint struct {
+	Type       string     `json:"type"` // thermal zone 是 active , passive , hot , critical ; hwmon 是 min , max , crit , emergency
+	Value      MetricUnit `json:"value"`
+	Hysteresis float64    `json:"hysteresis,omitempty"` // 回差 , 只有 thermal zone 有
+}
+
+type HwmonMetric struct {
+	Name    string        `json:"name"`          // hwmon0
+	Chip    string        `json:"chip"`          // 驱动给的名字 , 例如 mt7915_phy0 , aqr107
+	Phy     string        `json:"phy,omitempty"` // 无线网卡的传感器对应的 phy , 例如 phy0
+	Sensors []HwmonSensor `json:"sensors"`
+}
+
+type HwmonSensorKind string
+
+const (
+	HwmonSensorTemperature HwmonSensorKind = "temperature" // tempN , °C
+	HwmonSensorFan         HwmonSensorKind = "fan"         // fanN , RPM
+	HwmonSensorVoltage     HwmonSensorKind = "voltage"     // inN , V
+)
+
+type HwmonSensor struct {
+	Name       string          `json:"name"` // temp1 , fan1 , in0
+	Kind       HwmonSensorKind `json:"kind"`
+	Label      string          `json:"label"` // 驱动没有提供 label 时和 name 一样
+	Value      MetricUnit      `json:"value"` // 读不到时 value 为 -1
+	TripPoints []TripPoint     `json:"trip_points"`
+}
+
+type ProcessMetric struct {
+	Pid        int     `json:"pid"`
+	Name       string  `json:"name"`
+	Cmdline    string  `json:"cmdline,omitempty"` // 内核线程没有命令行
+	State      string  `json:"state"`             // R 运行 , S 睡眠 , D 不可中断 , Z 僵尸 , T 停止 , I 空闲
+	RssBytes   uint64  `json:"rss_bytes"`
+	CpuPercent float64 `json:"cpu_percent"` // 占所有核心的百分比 , 和 cpu.total 可以直接比较
+	Threads    int     `json:"threads"`
+	OpenFds    int     `json:"open_fds"` // 没有权限读取时为 -1
+}
+
+type ProcessesMetric struct {
+	// 计算 cpu 占用的采样间隔 , 第一次采样时为 0 , 这时 cpu_percent 都是 0
+	CpuSampleSeconds float64         `json:"cpu_sample_seconds"`
+	TotalProcesses   int             `json:"total_processes"`
+	TotalThreads     int             `json:"total_threads"`
+	TopCpu           []ProcessMetric `json:"top_cpu"`
+	TopMemory        []ProcessMetric `json:"top_memory"`
+}
+
 type StaticMetric struct {
-	Network StaticNetworkMetric `json:"network"`
-	System  StaticSystemMetric  `json:"system"`
+	Network     StaticNetworkMetric `json:"network"`
+	System      StaticSystemMetric  `json:"system"`
+	Filesystems FilesystemMetric    `json:"filesystems"`
 }
 
+// StaticNetworkMetric key 是网卡名 , global 是 wan 地址 , dns 和默认网关
 type StaticNetworkMetric map[string]StaticNetworkInterfaceMetric
 
 type StaticNetworkInterfaceMetric struct {
-	Ipv4    []string `json:"ipv4"`
-	Ipv6    []string `json:"ipv6"`
-	Dns     []string `json:"dns,omitempty"`
-	Gateway string   `json:"gateway,omitempty"`
+	Ipv4    []string     `json:"ipv4"`
+	Ipv6    []string     `json:"ipv6"`
+	Dns     []string     `json:"dns,omitempty"`
+	Gateway string       `json:"gateway,omitempty"`
+	Link    *NetworkLink `json:"link,omitempty"` // global 没有
+}
+
+// NetworkLink 网卡的链路属性 , 来自 netlink 和 /sys/class/net , 网卡状态变化时立即刷新
+type NetworkLink struct {
+	Index             int       `json:"index"`
+	Kind              string    `json:"kind"`                         // bridge , vlan , wireguard , ppp , dsa ... 物理网卡是 device
+	OperState         string    `json:"oper_state"`                   // up , down , lowerlayerdown , dormant , unknown ...
+	Carrier           bool      `json:"carrier"`                      // 物理层是否连通
+	CarrierChanges    int       `json:"carrier_changes"`              // 开机以来连通状态变化的次数 , 读不到时为 -1
+	LastCarrierChange time.Time `json:"last_carrier_change,omitzero"` // 服务启动之后没有变化过时为空
+	Mtu               int       `json:"mtu"`
+	Mac               string    `json:"mac,omitempty"`
+	SpeedMbps         int       `json:"speed_mbps"`       // 链路断开或者虚拟网卡为 -1
+	Duplex            string    `json:"duplex"`           // full , half , unknown
+	Master            string    `json:"master,omitempty"` // 所属的网桥或者 bond
+	Parent            string    `json:"parent,omitempty"` // vlan 等虚拟网卡的下层网卡
+	VlanId            int       `json:"vlan_id,omitempty"`
 }
 
 func (s StaticNetworkMetric) SetGlobal(Ipv4 []string, Ipv6 []string, dns []string, gateway string) {
@@ -266,6 +555,11 @@ type AggregationTrafficMetric struct {
 
 type AggregationTrafficDetails struct {
 	Ip              string        `json:"ip"`
+	Domain          string        `json:"domain,omitempty"` // 来自 ebpf dns 应答嗅探
+	Mac             string        `json:"mac,omitempty"`    // 只有邻居表里的局域网设备才有
+	Vendor          string        `json:"vendor,omitempty"`
+	RandomizedMac   bool          `json:"randomized_mac,omitempty"`
+	Geo             *GeoIpInfo    `json:"geo,omitempty"` // 只有公网地址且配置了 geoip 数据库时才有 , 和 NetworkConnection.DestinationGeo 结构相同
 	IpType          IpAddressType `json:"ip_type"`
 	IpFamily        IpFamilyType  `json:"ip_family"`
 	Incoming        MetricUnit    `json:"incoming"`
@@ -277,6 +571,16 @@ type AggregationTrafficDetails struct {
 	Tcp             int32         `json:"tcp"`
 	Udp             int32         `json:"udp"`
 	Other           int32         `json:"other"` // 指的是"当前时刻此ip的非tcp/udp连接数"
+	// 按 TLS SNI / QUIC 域名拆分的流量 , 需要开启 sni 采样
+	Services []AggregationServiceTraffic `json:"services,omitempty"`
+}
+
+type AggregationServiceTraffic struct {
+	Name          string     `json:"name"`
+	Incoming      MetricUnit `json:"incoming"`
+	Outgoing      MetricUnit `json:"outgoing"`
+	TotalIncoming MetricUnit `json:"total_incoming"`
+	TotalOutgoing MetricUnit `json:"total_outgoing"`
 }
 
 type MetricUnit struct {
@@ -285,3 +589,352 @@ type MetricUnit struct {
 }
 
 type DnsResult map[string][]string
+
+type PassiveDnsRecord struct {
+	Domain    string    `json:"domain"`
+	Clients   []string  `json:"clients"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+// key 是应答的 ip 地址
+type PassiveDnsResult map[string][]PassiveDnsRecord
+
+type DnsTransportType string
+
+const (
+	DnsTransportUdp DnsTransportType = "udp"
+	DnsTransportTcp DnsTransportType = "tcp"
+	DnsTransportTls DnsTransportType = "tls"
+)
+
+type DnsUpstreamStatus struct {
+	Address             string           `json:"address"`
+	Transport           DnsTransportType `json:"transport"`
+	ServerName          string           `json:"server_name,omitempty"`
+	Healthy             bool             `json:"healthy"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	TotalQueries        uint64           `json:"total_queries"`
+	TotalFailures       uint64           `json:"total_failures"`
+	LastError           string           `json:"last_error,omitempty"`
+	LastSuccessAt       time.Time        `json:"last_success_at"`
+	LastFailureAt       time.Time        `json:"last_failure_at"`
+}
+
+type NeighborState string
+
+const (
+	NeighborStateNone       NeighborState = "none"
+	NeighborStateIncomplete NeighborState = "incomplete"
+	NeighborStateReachable  NeighborState = "reachable"
+	NeighborStateStale      NeighborState = "stale"
+	NeighborStateDelay      NeighborState = "delay"
+	NeighborStateProbe      NeighborState = "probe"
+	NeighborStateFailed     NeighborState = "failed"
+	NeighborStateNoArp      NeighborState = "noarp"
+	NeighborStatePermanent  NeighborState = "permanent"
+)
+
+type NeighborEntry struct {
+	Ip            string        `json:"ip"`
+	Mac           string        `json:"mac"`
+	State         NeighborState `json:"state"`
+	Interface     string        `json:"interface"`
+	IsRouter      bool          `json:"is_router"`
+	Vendor        string        `json:"vendor,omitempty"`
+	RandomizedMac bool          `json:"randomized_mac"` // 本地管理地址 , 一般是手机等设备的随机 mac , 这种 mac 查不到厂商
+	FirstSeen     time.Time     `json:"first_seen"`     // 本进程第一次见到这个 ip/mac 组合的时间
+	LastSeen      time.Time     `json:"last_seen"`      // 内核最后一次确认可达的时间
+}
+
+type DeviceSourceType string
+
+const (
+	DeviceSourceNeighbor DeviceSourceType = "neighbor"
+	DeviceSourceDhcp     DeviceSourceType = "dhcp"
+	DeviceSourceEbpf     DeviceSourceType = "ebpf"
+)
+
+type InventoryDeviceIp struct {
+	Ip        string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type InventoryDevice struct {
+	Mac           string              `json:"mac"`
+	Hostname      string              `json:"hostname,omitempty"` // 来自 dhcp 租约
+	Vendor        string              `json:"vendor,omitempty"`
+	RandomizedMac bool                `json:"randomized_mac"`
+	Ips           []InventoryDeviceIp `json:"ips"`
+	Sources       []DeviceSourceType  `json:"sources"`
+	FirstSeen     time.Time           `json:"first_seen"`
+	LastSeen      time.Time           `json:"last_seen"`
+}
+
+const NewDeviceWebhookEvent = "new_device"
+
+type NewDeviceWebhookPayload struct {
+	Event  string          `json:"event"`
+	Router string          `json:"router,omitempty"` // 路由器的主机名
+	Device InventoryDevice `json:"device"`
+}
+
+type GeoIpInfo struct {
+	CountryCode    string `json:"country_code,omitempty"`
+	Asn            uint32 `json:"asn,omitempty"`
+	AsOrganization string `json:"as_organization,omitempty"`
+}
+
+type AlertMetricName string
+
+const (
+	AlertMetricCpuTemperature        AlertMetricName = "cpu_temperature"         // °C
+	AlertMetricCpuUsage              AlertMetricName = "cpu_usage"               // %
+	AlertMetricMemoryUsedPercent     AlertMetricName = "memory_used_percent"     // %
+	AlertMetricFilesystemUsedPercent AlertMetricName = "filesystem_used_percent" // % , 标签 mount 必填
+	AlertMetricConntrackUsedPercent  AlertMetricName = "conntrack_used_percent"  // %
+	AlertMetricLinkUp                AlertMetricName = "link_up"                 // 1 或 0 , 标签 interface 必填
+	AlertMetricHostRate              AlertMetricName = "host_rate"               // B/S , 标签 ip , ip_type
+)
+
+// AlertSampleRequest 告诉采集器需要哪些指标 , 部分指标需要标签才能采集
+type AlertSampleRequest struct {
+	Metric AlertMetricName
+	Labels map[string]string
+}
+
+type AlertSample struct {
+	Metric AlertMetricName
+	Labels map[string]string
+	Value  float64
+}
+
+type AlertState string
+
+const (
+	AlertStatePending  AlertState = "pending"
+	AlertStateFiring   AlertState = "firing"
+	AlertStateResolved AlertState = "resolved"
+)
+
+type Alert struct {
+	Rule       string            `json:"rule"`
+	Metric     AlertMetricName   `json:"metric"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Severity   string            `json:"severity,omitempty"`
+	Summary    string            `json:"summary,omitempty"`
+	State      AlertState        `json:"state"`
+	Value      float64           `json:"value"`
+	Operator   string            `json:"operator"`
+	Threshold  float64           `json:"threshold"`
+	ActiveAt   time.Time         `json:"active_at"`
+	FiredAt    time.Time         `json:"fired_at,omitzero"`
+	ResolvedAt time.Time         `json:"resolved_at,omitzero"`
+}
+
+type AlertNotification struct {
+	Router string    `json:"router,omitempty"` // 路由器的主机名
+	SentAt time.Time `json:"sent_at"`
+	Alert  Alert     `json:"alert"`
+}
+
+// CollectorStatus 记录一个采集器最近一次成功和失败 , 用于 /status 排查问题
+type CollectorStatus struct {
+	Name          string    `json:"name"`
+	Healthy       bool      `json:"healthy"` // 最近一次是成功的
+	LastError     string    `json:"last_error,omitempty"`
+	LastSuccessAt time.Time `json:"last_success_at,omitzero"`
+	LastFailureAt time.Time `json:"last_failure_at,omitzero"`
+}
+
+type EbpfStatus struct {
+	Starting        bool      `json:"starting"` // 启动后还在加载 ebpf 程序 , 加载失败时程序会直接退出
+	Loaded          bool      `json:"loaded"`
+	Attached        bool      `json:"attached"` // ingress 和 egress 的 tc 过滤器都还在
+	AttachedIngress bool      `json:"attached_ingress"`
+	AttachedEgress  bool      `json:"attached_egress"`
+	Interface       string    `json:"interface"`
+	InterfaceIndex  int       `json:"interface_index"`
+	IsCapturing     bool      `json:"is_capturing"` // 没有请求时会自动停止抓包 , 这是正常的
+	CaptureStartAt  time.Time `json:"capture_start_at,omitzero"`
+	DnsSnooping     bool      `json:"dns_snooping"`
+	SniCapture      bool      `json:"sni_capture"`
+}
+
+type JsonCacheStatus struct {
+	Key        string    `json:"key"`
+	UpdateAt   time.Time `json:"update_at"`
+	ExpireAt   time.Time `json:"expire_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+	Expired    bool      `json:"expired"`
+	Updating   bool      `json:"updating"`
+}
+
+type NeighborServiceStatus struct {
+	Healthy       bool      `json:"healthy"`
+	Subscribed    bool      `json:"subscribed"`
+	Entries       int       `json:"entries"`
+	LastEventAt   time.Time `json:"last_event_at,omitzero"`
+	LastResyncAt  time.Time `json:"last_resync_at,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastFailureAt time.Time `json:"last_failure_at,omitzero"`
+}
+
+type DnsResolverStatus struct {
+	Healthy   bool                `json:"healthy"` // 至少有一个上游可用
+	Upstreams []DnsUpstreamStatus `json:"upstreams"`
+}
+
+type ServiceStatus struct {
+	Healthy     bool                  `json:"healthy"`
+	Starting    bool                  `json:"starting"` // 还在启动中 , 这时不算不健康
+	Problems    []string              `json:"problems,omitempty"`
+	GeneratedAt time.Time             `json:"generated_at"`
+	StartedAt   time.Time             `json:"started_at"`
+	Ebpf        EbpfStatus            `json:"ebpf"`
+	JsonCache   []JsonCacheStatus     `json:"json_cache"`
+	Neighbor    NeighborServiceStatus `json:"neighbor"`
+	Dns         DnsResolverStatus     `json:"dns"`
+	Collectors  []CollectorStatus     `json:"collectors"`
+}
+
+type HealthzResponse struct {
+	Healthy  bool     `json:"healthy"`
+	Starting bool     `json:"starting,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// CheckProblems 找出会导致数据不可信的问题 , 抓包因为空闲而暂停不算问题 ,
+// 启动后 EbpfStartupGracePeriod 之内 ebpf 程序还在加载也不算问题 , 避免开机时 procd 反复重启
+func (s *ServiceStatus) CheckProblems() {
+	s.Problems = nil
+	s.Starting = s.Ebpf.Starting && s.GeneratedAt.Sub(s.StartedAt) < EbpfStartupGracePeriod
+	switch {
+	case s.Starting:
+		// 还在加载 , 加载失败时程序直接退出 , 不会一直停在这里
+	case s.Ebpf.Starting:
+		s.Problems = append(s.Problems, "ebpf program is still not loaded after "+EbpfStartupGracePeriod.String())
+	case !s.Ebpf.Loaded:
+		s.Problems = append(s.Problems, "ebpf program is not loaded")
+	case !s.Ebpf.Attached:
+		s.Problems = append(s.Problems, "ebpf program is not attached to "+s.Ebpf.Interface)
+	}
+	if !s.Neighbor.Healthy {
+		s.Problems = append(s.Problems, "neighbor table is not being updated")
+	}
+	if !s.Dns.Healthy {
+		s.Problems = append(s.Problems, "no healthy dns upstream")
+	}
+	for _, collector := range s.Collectors {
+		if !collector.Healthy {
+			s.Problems = append(s.Problems, collector.Name+" : "+collector.LastError)
+		}
+	}
+	s.Healthy = len(s.Problems) == 0
+}
+
+// SelfMetric 程序自身的资源占用 , 用来确认监控本身不是负载来源
+type SelfMetric struct {
+	GeneratedAt         time.Time          `json:"generated_at"`
+	Process             SelfProcessMetric  `json:"process"`
+	Runtime             SelfRuntimeMetric  `json:"runtime"`
+	Collectors          []CollectorTiming  `json:"collectors"`
+	JsonCache           []JsonCacheCounter `json:"json_cache"`
+	DroppedUpdateEvents uint64             `json:"dropped_update_events"` // UpdateEventChan 满了被丢弃的刷新事件
+	FlowMap             EbpfMapUsage       `json:"flow_map"`
+}
+
+type SelfProcessMetric struct {
+	RssBytes         uint64  `json:"rss_bytes"`
+	PeakRssBytes     uint64  `json:"peak_rss_bytes"`
+	VirtualBytes     uint64  `json:"virtual_bytes"`
+	Threads          int     `json:"threads"`
+	CpuUserSeconds   float64 `json:"cpu_user_seconds"`
+	CpuSystemSeconds float64 `json:"cpu_system_seconds"`
+}
+
+type SelfRuntimeMetric struct {
+	Goroutines          int       `json:"goroutines"`
+	HeapAllocBytes      uint64    `json:"heap_alloc_bytes"`
+	HeapSysBytes        uint64    `json:"heap_sys_bytes"`
+	SysBytes            uint64    `json:"sys_bytes"` // 从系统申请的全部内存
+	NumGc               uint32    `json:"num_gc"`
+	GcPauseTotalSeconds float64   `json:"gc_pause_total_seconds"`
+	GcPauseLastSeconds  float64   `json:"gc_pause_last_seconds"`
+	GcPauseMaxSeconds   float64   `json:"gc_pause_max_seconds"` // 最近 256 次里最长的
+	GcCpuFraction       float64   `json:"gc_cpu_fraction"`
+	LastGcAt            time.Time `json:"last_gc_at,omitzero"`
+}
+
+type CollectorTiming struct {
+	Name         string    `json:"name"`
+	Runs         uint64    `json:"runs"`
+	LastSeconds  float64   `json:"last_seconds"`
+	AvgSeconds   float64   `json:"avg_seconds"`
+	MaxSeconds   float64   `json:"max_seconds"`
+	TotalSeconds float64   `json:"total_seconds"`
+	LastRunAt    time.Time `json:"last_run_at"`
+}
+
+type JsonCacheCounter struct {
+	Key       string `json:"key"`
+	Hits      uint64 `json:"hits"`      // 缓存未过期
+	Stale     uint64 `json:"stale"`     // 缓存已过期 , 返回旧数据并触发刷新
+	Misses    uint64 `json:"misses"`    // 缓存还不存在
+	Refreshes uint64 `json:"refreshes"` // 缓存被重新生成的次数
+	Dropped   uint64 `json:"dropped"`   // 刷新事件因为队列满被丢弃
+}
+
+type EbpfMapUsage struct {
+	Entries     int     `json:"entries"`
+	MaxEntries  int     `json:"max_entries"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type ApiErrorCode string
+
+const (
+	ApiErrorNoData           ApiErrorCode = "no_data"          // 采集器还没有产生过数据
+	ApiErrorCollectorFailed  ApiErrorCode = "collector_failed" // 最近一次采集失败 , data 可能是上一次成功的结果
+	ApiErrorBadRequest       ApiErrorCode = "bad_request"
+	ApiErrorMethodNotAllowed ApiErrorCode = "method_not_allowed"
+	ApiErrorNotFound         ApiErrorCode = "not_found"
+	ApiErrorDisabled         ApiErrorCode = "disabled" // 功能没有开启
+	ApiErrorUpstreamFailed   ApiErrorCode = "upstream_failed"
+	ApiErrorInternal         ApiErrorCode = "internal_error"
+)
+
+// ApiEnvelope /api/v1 下所有接口的统一返回格式
+type ApiEnvelope struct {
+	Data             json.RawMessage `json:"data"`
+	Error            ApiErrorCode    `json:"error,omitempty"`
+	Message          string          `json:"message,omitempty"`
+	GeneratedAt      time.Time       `json:"generated_at"`      // 数据生成的时间 , 缓存的数据是缓存更新的时间
+	StalenessSeconds float64         `json:"staleness_seconds"` // 数据已经生成了多久
+	Stale            bool            `json:"stale"`             // 缓存已过期 , 正在后台刷新
+}
+
+type QueryGroup string
+
+const (
+	QueryGroupStatic      QueryGroup = "static"      // 同 /metric/static
+	QueryGroupDynamic     QueryGroup = "dynamic"     // 同 /metric/dynamic
+	QueryGroupConnections QueryGroup = "connections" // 同 /metric/network_connection
+	QueryGroupAggregation QueryGroup = "aggregation" // 同 /metric/aggregation_traffic
+	QueryGroupDns         QueryGroup = "dns"         // 同 /dns/query , 需要 ip 参数
+)
+
+// QueryResult /query 一次返回多个分组 , 只有请求了的分组才有 , 选择了字段时分组里只保留选中的字段和它们的上层
+type QueryResult struct {
+	Static      json.RawMessage          `json:"static,omitempty"`      // 结构同 StaticMetric
+	Dynamic     json.RawMessage          `json:"dynamic,omitempty"`     // 结构同 DynamicMetric
+	Connections json.RawMessage          `json:"connections,omitempty"` // 结构同 NetworkConnectionMetric
+	Aggregation json.RawMessage          `json:"aggregation,omitempty"` // 结构同 AggregationTrafficMetric
+	Dns         json.RawMessage          `json:"dns,omitempty"`         // 结构同 DnsResult
+	UpdateAt    map[QueryGroup]time.Time `json:"update_at,omitempty"`   // 每个分组缓存更新的时间
+	Missing     []string                 `json:"missing,omitempty"`     // 没有找到的字段
+	// 没有数据或者最近一次采集失败的分组 , 有旧数据时分组里仍然是旧数据
+	Errors map[QueryGroup]string `json:"errors,omitempty"`
+}
@@ -1,5 +1,7 @@
 package model
 
+import "strconv"
+
 // ProcfsPathsInterface 接口：所有可能访问的路径都走这里
 type ProcfsPathsInterface interface {
 	CpuTemp() string
@@ -16,6 +18,26 @@ type ProcfsPathsInterface interface {
 	HardwareName() string
 	SystemHostname() string
 	SystemConfig() string
+	ConntrackCount() string
+	ConntrackMax() string
+	NetworkInterfaceOperState(name string) string
+	NetworkInterfaceAttribute(name string, attribute string) string
+	SelfStatus() string
+	LoadAverage() string
+	Interrupts() string
+	Softirqs() string
+	ThermalDir() string
+	HwmonDir() string
+	UbiDir() string
+	MtdDir() string
+	CpuFreqDir(core int) string
+	CpuThrottleCount(core int) string
+	CpuTopology(core int, attribute string) string
+	ProcessDir() string
+	ProcessStat(pid int) string
+	ProcessStatus(pid int) string
+	ProcessCmdline(pid int) string
+	ProcessFdDir(pid int) string
 }
 
 // ProcfsPaths 生产环境路径
@@ -37,3 +59,41 @@ func (p ProcfsPaths) SystemVersion() string  { return "/proc/version" }
 func (p ProcfsPaths) HardwareName() string   { return "/proc/device-tree/model" }
 func (p ProcfsPaths) SystemHostname() string { return "/proc/sys/kernel/hostname" }
 func (p ProcfsPaths) SystemConfig() string   { return "/etc/config/system" }
+func (p ProcfsPaths) ConntrackCount() string { return "/proc/sys/net/netfilter/nf_conntrack_count" }
+func (p ProcfsPaths) ConntrackMax() string   { return "/proc/sys/net/netfilter/nf_conntrack_max" }
+func (p ProcfsPaths) SelfStatus() string     { return "/proc/self/status" }
+func (p ProcfsPaths) ProcessDir() string     { return "/proc" }
+func (p ProcfsPaths) LoadAverage() string    { return "/proc/loadavg" }
+func (p ProcfsPaths) Interrupts() string     { return "/proc/interrupts" }
+func (p ProcfsPaths) Softirqs() string       { return "/proc/softirqs" }
+func (p ProcfsPaths) ThermalDir() string     { return "/sys/class/thermal" }
+func (p ProcfsPaths) HwmonDir() string       { return "/sys/class/hwmon" }
+func (p ProcfsPaths) UbiDir() string         { return "/sys/class/ubi" }
+func (p ProcfsPaths) MtdDir() string         { return "/sys/class/mtd" }
+func (p ProcfsPaths) CpuFreqDir(core int) string {
+	return "/sys/devices/system/cpu/cpu" + strconv.Itoa(core) + "/cpufreq"
+}
+func (p ProcfsPaths) CpuThrottleCount(core int) string {
+	return "/sys/devices/system/cpu/cpu" + strconv.Itoa(core) + "/thermal_throttle/core_throttle_count"
+}
+func (p ProcfsPaths) CpuTopology(core int, attribute string) string {
+	return "/sys/devices/system/cpu/cpu" + strconv.Itoa(core) + "/topology/" + attribute
+}
+func (p ProcfsPaths) ProcessStat(pid int) string {
+	return "/proc/" + strconv.Itoa(pid) + "/stat"
+}
+func (p ProcfsPaths) ProcessStatus(pid int) string {
+	return "/proc/" + strconv.Itoa(pid) + "/status"
+}
+func (p ProcfsPaths) ProcessCmdline(pid int) string {
+	return "/proc/" + strconv.Itoa(pid) + "/cmdline"
+}
+func (p ProcfsPaths) ProcessFdDir(pid int) string {
+	return "/proc/" + strconv.Itoa(pid) + "/fd"
+}
+func (p ProcfsPaths) NetworkInterfaceOperState(name string) string {
+	return "/sys/class/net/" + name + "/operstate"
+}
+func (p ProcfsPaths) NetworkInterfaceAttribute(name string, attribute string) string {
+	return "/sys/class/net/" + name + "/" + attribute
+}
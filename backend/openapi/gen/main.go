@@ -0,0 +1,25 @@
+// 根据 model 包生成 openapi.json , 用法 : go generate ./openapi
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"openwrt-diskio-api/backend/openapi"
+)
+
+func main() {
+	output := flag.String("o", "openapi.json", "output file")
+	modelDir := flag.String("model", "../model", "source directory of the model package")
+	flag.Parse()
+
+	data, err := openapi.Generate(*modelDir)
+	if err != nil {
+		log.Fatalf("Generate openapi document failed: %s", err)
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		log.Fatalf("Write %s failed: %s", *output, err)
+	}
+	log.Printf("Write %s , %d bytes", *output, len(data))
+}
@@ -0,0 +1,411 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"openwrt-diskio-api/backend/api"
+	"openwrt-diskio-api/backend/model"
+)
+
+const (
+	Version         = "3.0.3"
+	schemaRefPrefix = "#/components/schemas/"
+)
+
+var (
+	modelPkgPath   = reflect.TypeFor[model.ApiEnvelope]().PkgPath()
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+	envelopeType   = reflect.TypeFor[model.ApiEnvelope]()
+	healthzType    = reflect.TypeFor[model.HealthzResponse]()
+	errorCodeType  = reflect.TypeFor[model.ApiErrorCode]()
+)
+
+type enumValue struct {
+	value   string
+	comment string
+}
+
+// modelDocs 是从 model 包源码里读出来的注释 , reflect 拿不到注释
+type modelDocs struct {
+	types  map[string]string // 类型名 -> 注释
+	fields map[string]string // 类型名.字段名 -> 注释
+	enums  map[string][]enumValue
+}
+
+func commentText(groups ...*ast.CommentGroup) string {
+	var parts []string
+	for _, group := range groups {
+		if text := strings.Join(strings.Fields(group.Text()), " "); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func parseModelDocs(modelDir string) (*modelDocs, error) {
+	files, err := filepath.Glob(filepath.Join(modelDir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+
+	docs := &modelDocs{
+		types:  make(map[string]string),
+		fields: make(map[string]string),
+		enums:  make(map[string][]enumValue),
+	}
+	fileSet := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fileSet, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range parsed.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					doc := spec.Doc
+					if doc == nil && len(genDecl.Specs) == 1 {
+						doc = genDecl.Doc
+					}
+					docs.types[spec.Name.Name] = commentText(doc)
+					structType, ok := spec.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, field := range structType.Fields.List {
+						names := field.Names
+						if len(names) == 0 {
+							if ident, ok := field.Type.(*ast.Ident); ok {
+								names = []*ast.Ident{ident}
+							}
+						}
+						for _, name := range names {
+							docs.fields[spec.Name.Name+"."+name.Name] = commentText(field.Doc, field.Comment)
+						}
+					}
+				case *ast.ValueSpec:
+					typeIdent, ok := spec.Type.(*ast.Ident)
+					if !ok || genDecl.Tok != token.CONST {
+						continue
+					}
+					for _, value := range spec.Values {
+						literal, ok := value.(*ast.BasicLit)
+						if !ok || literal.Kind != token.STRING {
+							continue
+						}
+						unquoted, err := strconv.Unquote(literal.Value)
+						if err != nil {
+							return nil, err
+						}
+						docs.enums[typeIdent.Name] = append(docs.enums[typeIdent.Name], enumValue{
+							value:   unquoted,
+							comment: commentText(spec.Doc, spec.Comment),
+						})
+					}
+				}
+			}
+		}
+	}
+	return docs, nil
+}
+
+type generator struct {
+	docs    *modelDocs
+	schemas map[string]any
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": schemaRefPrefix + name}
+}
+
+// withDescription $ref 旁边的字段会被忽略 , 需要用 allOf 包一层
+func withDescription(schema map[string]any, description string) map[string]any {
+	if description == "" {
+		return schema
+	}
+	if _, ok := schema["$ref"]; ok {
+		return map[string]any{"allOf": []any{schema}, "description": description}
+	}
+	result := make(map[string]any, len(schema)+1)
+	for key, value := range schema {
+		result[key] = value
+	}
+	result["description"] = description
+	return result
+}
+
+func (g *generator) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+	if t.PkgPath() == modelPkgPath && t.Name() != "" {
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			// 先占位 , 防止自引用的类型无限递归
+			g.schemas[name] = nil
+			g.schemas[name] = withDescription(g.define(t), g.typeDescription(name))
+		}
+		return ref(name)
+	}
+	return g.define(t)
+}
+
+func (g *generator) typeDescription(name string) string {
+	// go 的注释习惯以类型名开头 , 文档里不需要
+	description := strings.TrimPrefix(g.docs.types[name], name+" ")
+	var lines []string
+	for _, item := range g.docs.enums[name] {
+		line := "`" + item.value + "`"
+		if item.comment != "" {
+			line += " : " + item.comment
+		}
+		lines = append(lines, "- "+line)
+	}
+	if len(lines) == 0 {
+		return description
+	}
+	if description != "" {
+		description += "\n\n"
+	}
+	return description + strings.Join(lines, "\n")
+}
+
+func (g *generator) define(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		result := map[string]any{"type": "string"}
+		if enums := g.docs.enums[t.Name()]; t.PkgPath() == modelPkgPath && len(enums) > 0 {
+			values := make([]any, 0, len(enums))
+			for _, item := range enums {
+				values = append(values, item.value)
+			}
+			result["enum"] = values
+		}
+		return result
+	case reflect.Pointer:
+		return map[string]any{"allOf": []any{g.schema(t.Elem())}, "nullable": true}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Slice:
+		// nil 切片编码成 null
+		return map[string]any{"type": "array", "items": g.schema(t.Elem()), "nullable": true}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem()), "nullable": true}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		var required []string
+		g.addFields(t, properties, &required)
+		slices.Sort(required)
+		result := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			result["required"] = required
+		}
+		return result
+	}
+	return map[string]any{}
+}
+
+// addFields 按 encoding/json 的规则展开字段 , 匿名嵌入的结构体字段提升到外层
+func (g *generator) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for index := range t.NumField() {
+		field := t.Field(index)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		description := g.docs.fields[t.Name()+"."+field.Name]
+		properties[name] = withDescription(g.schema(field.Type), description)
+		if !omittable(field.Type, strings.Split(options, ",")) {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// omittable omitempty 不会省略结构体 , omitzero 什么都能省略
+func omittable(t reflect.Type, options []string) bool {
+	if slices.Contains(options, "omitzero") {
+		return true
+	}
+	if !slices.Contains(options, "omitempty") {
+		return false
+	}
+	return t.Kind() != reflect.Struct
+}
+
+func (g *generator) envelope(data map[string]any) map[string]any {
+	return map[string]any{
+		"allOf": []any{
+			g.schema(envelopeType),
+			map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"data": data},
+			},
+		},
+	}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func (g *generator) operation(endpoint Endpoint, v1 bool) map[string]any {
+	var parameters []any
+	for _, parameter := range endpoint.Parameters {
+		parameters = append(parameters, map[string]any{
+			"name":        parameter.Name,
+			"in":          "query",
+			"description": parameter.Description,
+			"required":    parameter.Required,
+			"explode":     true,
+			"schema":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		})
+	}
+
+	data := g.schema(endpoint.Response)
+	operation := map[string]any{
+		"summary":     endpoint.Summary,
+		"operationId": operationId(endpoint.Path, v1),
+	}
+	if endpoint.Description != "" {
+		operation["description"] = endpoint.Description
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if v1 {
+		operation["tags"] = []any{"v1"}
+		operation["responses"] = map[string]any{
+			"200": map[string]any{
+				"description": "成功 , 有数据但最近一次采集失败时 error 为 collector_failed",
+				"content":     jsonContent(g.envelope(data)),
+			},
+			"default": map[string]any{
+				"description": "失败 , data 为 null , 错误码见 error",
+				"content":     jsonContent(g.schema(envelopeType)),
+			},
+		}
+		return operation
+	}
+	operation["tags"] = []any{"legacy"}
+	operation["deprecated"] = true
+	operation["responses"] = map[string]any{
+		"200": map[string]any{
+			"description": "成功",
+			"content":     jsonContent(data),
+		},
+		"default": map[string]any{
+			"description": "失败 , 返回纯文本的错误信息",
+			"content": map[string]any{
+				"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+			},
+		},
+	}
+	return operation
+}
+
+func operationId(path string, v1 bool) string {
+	var builder strings.Builder
+	if v1 {
+		builder.WriteString("v1")
+	} else {
+		builder.WriteString("legacy")
+	}
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '_' }) {
+		builder.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return builder.String()
+}
+
+// Generate 根据 Endpoints 和 model 包生成 openapi 文档 , modelDir 是 model 包源码的目录 , 用来读取注释
+func Generate(modelDir string) ([]byte, error) {
+	docs, err := parseModelDocs(modelDir)
+	if err != nil {
+		return nil, fmt.Errorf("parse model docs: %w", err)
+	}
+	g := &generator{docs: docs, schemas: make(map[string]any)}
+	// 错误码在信封里 , 单独引用一次保证一定会生成
+	g.schema(errorCodeType)
+
+	paths := make(map[string]any)
+	for _, endpoint := range Endpoints {
+		paths[api.V1Prefix+endpoint.Path] = map[string]any{"get": g.operation(endpoint, true)}
+		paths[endpoint.Path] = map[string]any{"get": g.operation(endpoint, false)}
+	}
+	paths["/healthz"] = map[string]any{"get": map[string]any{
+		"summary":     "健康检查 , 数据不可信时返回 503",
+		"operationId": "healthz",
+		"responses": map[string]any{
+			"200": map[string]any{"description": "健康", "content": jsonContent(g.schema(healthzType))},
+			"503": map[string]any{"description": "有问题 , 见 problems", "content": jsonContent(g.schema(healthzType))},
+		},
+	}}
+	paths["/openapi.json"] = map[string]any{"get": map[string]any{
+		"summary":     "本文档",
+		"operationId": "openapi",
+		"responses": map[string]any{
+			"200": map[string]any{"description": "openapi 3 文档", "content": jsonContent(map[string]any{"type": "object"})},
+		},
+	}}
+
+	document := map[string]any{
+		"openapi": Version,
+		"info": map[string]any{
+			"title":       "openwrt-diskio-api",
+			"description": "/api/v1 下的接口返回统一的信封格式 , 没有前缀的旧路径直接返回数据 , 只为兼容保留",
+			"version":     "v1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.schemas},
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
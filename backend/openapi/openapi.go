@@ -0,0 +1,130 @@
+// Package openapi 发布 /openapi.json , 描述所有接口和 model 里的结构体
+//
+// 内置的 openapi.json 由 gen 目录下的程序根据 model 包的类型和注释生成 ,
+// 修改 model 或者接口后需要重新生成 : go generate ./openapi
+package openapi
+
+//go:generate go run ./gen -o openapi.json
+
+import (
+	"net/http"
+	"reflect"
+
+	"openwrt-diskio-api/backend/model"
+
+	_ "embed"
+)
+
+//go:embed openapi.json
+var Spec []byte
+
+type Parameter struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+type Endpoint struct {
+	Path        string
+	Summary     string
+	Parameters  []Parameter
+	Response    reflect.Type
+	Description string
+}
+
+// Endpoints 与 main.go 里注册的路由一一对应 , 每个接口同时有 /api/v1 下的路径和直接返回数据的旧路径
+var Endpoints = []Endpoint{
+	{
+		Path:        "/metric/dynamic",
+		Summary:     "磁盘 , cpu , 网卡速率和内存",
+		Response:    reflect.TypeFor[model.DynamicMetric](),
+		Description: "请求会唤醒后台采集 , 没有人请求一段时间后采集会暂停",
+	},
+	{
+		Path:     "/metric/network_connection",
+		Summary:  "conntrack 里的连接",
+		Response: reflect.TypeFor[model.NetworkConnectionMetric](),
+	},
+	{
+		Path:     "/metric/static",
+		Summary:  "网卡地址和系统信息",
+		Response: reflect.TypeFor[model.StaticMetric](),
+	},
+	{
+		Path:        "/metric/aggregation_traffic",
+		Summary:     "按 ip 聚合的流量",
+		Response:    reflect.TypeFor[model.AggregationTrafficMetric](),
+		Description: "请求会唤醒 ebpf 抓包 , 没有人请求一段时间后抓包会暂停",
+	},
+	{
+		Path:        "/metric/processes",
+		Summary:     "cpu 和内存占用最高的进程",
+		Response:    reflect.TypeFor[model.ProcessesMetric](),
+		Description: "cpu 占用是两次刷新之间的差值 , 启动后第一次采样的 cpu_percent 都是 0",
+	},
+	{
+		Path:     "/metric/self",
+		Summary:  "程序自身的资源占用",
+		Response: reflect.TypeFor[model.SelfMetric](),
+	},
+	{
+		Path:    "/dns/query",
+		Summary: "反向解析 ip",
+		Parameters: []Parameter{
+			{Name: "ip", Description: "支持 ip=a&ip=b 和 ip=a,b 两种写法", Required: true},
+		},
+		Response: reflect.TypeFor[model.DnsResult](),
+	},
+	{
+		Path:     "/dns/upstreams",
+		Summary:  "上游 dns 服务器的状态",
+		Response: reflect.TypeFor[[]model.DnsUpstreamStatus](),
+	},
+	{
+		Path:    "/dns/passive",
+		Summary: "客户端查询过的域名 , 需要开启 --passive-dns",
+		Parameters: []Parameter{
+			{Name: "ip", Description: "为空时返回所有记录 , 支持 ip=a&ip=b 和 ip=a,b 两种写法"},
+		},
+		Response: reflect.TypeFor[model.PassiveDnsResult](),
+	},
+	{
+		Path:     "/neighbors",
+		Summary:  "内核邻居表",
+		Response: reflect.TypeFor[[]model.NeighborEntry](),
+	},
+	{
+		Path:    "/devices",
+		Summary: "见过的局域网设备",
+		Parameters: []Parameter{
+			{Name: "since", Description: "RFC3339 时间或者 24h 这样的时长 (表示多久以前)"},
+		},
+		Response: reflect.TypeFor[[]model.InventoryDevice](),
+	},
+	{
+		Path:     "/alerts",
+		Summary:  "当前的告警 , 需要配置 --alert-rules-file",
+		Response: reflect.TypeFor[[]model.Alert](),
+	},
+	{
+		Path:     "/status",
+		Summary:  "各个子系统的状态",
+		Response: reflect.TypeFor[model.ServiceStatus](),
+	},
+	{
+		Path:    "/query",
+		Summary: "一次返回多个分组 , 可以只选择部分字段",
+		Parameters: []Parameter{
+			{Name: "include", Description: "返回整个分组 , 可选 static , dynamic , connections , aggregation , dns"},
+			{Name: "fields", Description: "只返回分组里的字段 , 用 . 分隔 , 例如 dynamic.cpu.total,dynamic.network.pppoe-wan , 数组会对每个元素选择 , 例如 aggregation.details.ip"},
+			{Name: "ip", Description: "dns 分组需要 , 支持 ip=a&ip=b 和 ip=a,b 两种写法"},
+		},
+		Response:    reflect.TypeFor[model.QueryResult](),
+		Description: "include 和 fields 至少要有一个 , 某个分组没有数据时记录在 errors 里 , 不影响其它分组",
+	},
+}
+
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(Spec)
+}
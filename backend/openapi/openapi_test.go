@@ -0,0 +1,55 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"openwrt-diskio-api/backend/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 修改了 model 的字段或注释而没有重新生成文档时失败
+func TestSpecUpToDate(t *testing.T) {
+	generated, err := Generate("../model")
+	assert.NoError(t, err)
+	assert.Equal(t, string(generated), string(Spec), "openapi.json is out of date , run go generate ./openapi")
+}
+
+func TestSpecDocumentsEndpointsAndSentinels(t *testing.T) {
+	var document struct {
+		Paths      map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Description string `json:"description"`
+					AllOf       []struct {
+						Ref string `json:"$ref"`
+					} `json:"allOf"`
+				} `json:"properties"`
+				Required []string `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NoError(t, json.Unmarshal(Spec, &document))
+
+	for _, endpoint := range Endpoints {
+		assert.Contains(t, document.Paths, endpoint.Path)
+		assert.Contains(t, document.Paths, api.V1Prefix+endpoint.Path)
+	}
+	assert.Contains(t, document.Paths, "/healthz")
+
+	storage := document.Components.Schemas["StorageIoMetric"]
+	assert.Contains(t, storage.Properties["read"].Description, "-1")
+	assert.Contains(t, storage.Properties["write"].Description, "-1")
+	cpu := document.Components.Schemas["CpuUsageMetric"]
+	assert.Contains(t, cpu.Properties["temperature"].Description, "-1")
+
+	// omitempty 的字段不是必填 , 两个接口的 geoip 信息是同一个结构
+	details := document.Components.Schemas["AggregationTrafficDetails"]
+	connection := document.Components.Schemas["NetworkConnection"]
+	assert.Equal(t, connection.Properties["destination_geo"].AllOf, details.Properties["geo"].AllOf)
+	assert.NotEmpty(t, details.Properties["geo"].AllOf)
+	assert.Contains(t, details.Required, "ip")
+	assert.NotContains(t, details.Required, "domain")
+}
@@ -0,0 +1,157 @@
+// 从 IEEE 的 MA-L/MA-M/MA-S 注册表生成内置的 oui 表 , 用法 : go generate ./oui ;
+// 不能联网时先下载 oui.csv 等文件 , 再用 go run ./gen -from oui.csv,mam.csv,oui36.csv
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var registries = []string{
+	"https://standards-oui.ieee.org/oui/oui.csv",
+	"https://standards-oui.ieee.org/oui28/mam.csv",
+	"https://standards-oui.ieee.org/oui36/oui36.csv",
+}
+
+// 厂商名只保留主体部分 , 前端展示 "Espressif" 比 "Espressif Inc." 更直观
+var vendorSuffixes = []string{
+	" co., ltd.", " co.,ltd.", " co.,ltd", " co., ltd", " co. ltd.", " co ltd",
+	", inc.", ", inc", " inc.", " inc", " corporation", " corp.", " corp",
+	" limited", " ltd.", " ltd", " gmbh", " llc", " s.a.", " b.v.", " ag",
+	" technology", " technologies", " electronics", " communications",
+}
+
+func shortVendorName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	for {
+		trimmed := strings.TrimRight(name, " ,.")
+		lower := strings.ToLower(trimmed)
+		for _, suffix := range vendorSuffixes {
+			if strings.HasSuffix(lower, suffix) && len(trimmed) > len(suffix) {
+				trimmed = trimmed[:len(trimmed)-len(suffix)]
+				break
+			}
+		}
+		trimmed = strings.TrimRight(trimmed, " ,.")
+		if trimmed == name {
+			return name
+		}
+		name = trimmed
+	}
+}
+
+// parseRegistry 解析 IEEE 的 oui.csv / mam.csv / oui36.csv , 输出 "前缀<tab>厂商"
+func parseRegistry(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 || record[1] == "" {
+			continue
+		}
+		vendor := shortVendorName(record[2])
+		if vendor == "" || vendor == "Private" {
+			continue
+		}
+		lines = append(lines, strings.ToUpper(record[1])+"\t"+vendor)
+	}
+}
+
+func fetchRegistry(client *http.Client, url string) ([]string, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return parseRegistry(response.Body)
+}
+
+func readRegistry(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseRegistry(file)
+}
+
+// sourceNames 本地文件只记录文件名 , 文件名里最好带上下载日期
+func sourceNames(sources []string) []string {
+	names := make([]string, len(sources))
+	for index, source := range sources {
+		names[index] = source
+		if !strings.Contains(source, "://") {
+			names[index] = filepath.Base(source)
+		}
+	}
+	return names
+}
+
+func main() {
+	output := flag.String("o", "oui.txt.gz", "output file")
+	from := flag.String("from", "", "comma separated local IEEE csv files to use instead of downloading the registries")
+	flag.Parse()
+
+	sources := registries
+	if *from != "" {
+		sources = strings.Split(*from, ",")
+	}
+	client := &http.Client{Timeout: 2 * time.Minute}
+	var lines []string
+	for _, source := range sources {
+		var registry []string
+		var err error
+		if *from != "" {
+			registry, err = readRegistry(source)
+		} else {
+			registry, err = fetchRegistry(client, source)
+		}
+		if err != nil {
+			log.Fatalf("Read %s failed: %s", source, err)
+		}
+		lines = append(lines, registry...)
+	}
+	slices.Sort(lines)
+	lines = slices.Compact(lines)
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	writer, err := gzip.NewWriterLevel(file, gzip.BestCompression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	header := "# generated by oui/gen from " + strings.Join(sourceNames(sources), ", ") + "\n"
+	if _, err := io.WriteString(writer, header+strings.Join(lines, "\n")+"\n"); err != nil {
+		log.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Write %d oui prefixes to %s", len(lines), *output)
+}
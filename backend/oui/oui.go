@@ -0,0 +1,232 @@
+// Package oui 根据 mac 地址前缀识别网卡厂商
+//
+// 内置的 oui.txt.gz 由 gen 目录下的程序从 IEEE 的注册表生成 , 第一行注释记录了数据来源 ,
+// 更新内置表 : go generate ./oui ; 不重新编译也可以用 --oui-file 指定本地文件
+package oui
+
+//go:generate go run ./gen -o oui.txt.gz
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	_ "embed"
+)
+
+//go:embed oui.txt.gz
+var embeddedTable []byte
+
+var ErrEmptyTable = errors.New("oui table is empty")
+
+// IEEE 分配的前缀长度 , 长的优先匹配
+var prefixBits = [...]int{36, 28, 24}
+
+type Table struct {
+	prefixes map[int]map[uint64]string
+	count    int
+}
+
+func newTable() *Table {
+	t := &Table{prefixes: make(map[int]map[uint64]string, len(prefixBits))}
+	for _, bits := range prefixBits {
+		t.prefixes[bits] = make(map[uint64]string)
+	}
+	return t
+}
+
+// Parse 支持以下格式 , gzip 压缩的文件会自动解压 :
+//   - 内置表格式 / wireshark manuf : "前缀<tab>厂商" , 前缀可以是 001122 , 00:11:22 或 00:1B:C5:00:00/36
+//   - IEEE oui.csv / mam.csv / oui36.csv
+//   - IEEE oui.txt
+func Parse(r io.Reader) (*Table, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("Open gzip oui table failed: %w", err)
+		}
+		defer gzipReader.Close()
+		buffered = bufio.NewReader(gzipReader)
+	}
+
+	table := newTable()
+	if header, err := buffered.Peek(len("Registry,")); err == nil && string(header) == "Registry," {
+		if err := table.parseCsv(buffered); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := table.parseText(buffered); err != nil {
+			return nil, err
+		}
+	}
+	if table.count == 0 {
+		return nil, ErrEmptyTable
+	}
+	return table, nil
+}
+
+func (t *Table) parseCsv(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		return fmt.Errorf("Read oui csv header failed: %w", err)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Read oui csv failed: %w", err)
+		}
+		if len(record) < 3 {
+			continue
+		}
+		t.add(record[1], record[2])
+	}
+}
+
+func (t *Table) parseText(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// IEEE oui.txt : "00-00-0C   (hex)\t\tCisco Systems, Inc"
+		if prefix, vendor, ok := strings.Cut(line, "(hex)"); ok {
+			t.add(strings.TrimSpace(prefix), strings.TrimSpace(vendor))
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		t.add(strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1]))
+	}
+	return scanner.Err()
+}
+
+func (t *Table) add(rawPrefix string, vendor string) {
+	bits, value, ok := parsePrefix(rawPrefix)
+	if !ok || vendor == "" {
+		return
+	}
+	if _, exist := t.prefixes[bits][value]; !exist {
+		t.count++
+	}
+	t.prefixes[bits][value] = vendor
+}
+
+func parsePrefix(raw string) (bits int, value uint64, ok bool) {
+	raw, rawBits, hasBits := strings.Cut(raw, "/")
+	hexDigits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(raw)
+	if hasBits {
+		var err error
+		if bits, err = strconv.Atoi(rawBits); err != nil {
+			return 0, 0, false
+		}
+	} else {
+		bits = len(hexDigits) * 4
+	}
+	if bits != 24 && bits != 28 && bits != 36 {
+		return 0, 0, false
+	}
+	if len(hexDigits)*4 < bits {
+		return 0, 0, false
+	}
+	value, err := strconv.ParseUint(hexDigits[:bits/4], 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return bits, value, true
+}
+
+func (t *Table) Len() int {
+	return t.count
+}
+
+// Lookup 返回 mac 对应的厂商 , 找不到返回空字符串
+func (t *Table) Lookup(mac net.HardwareAddr) string {
+	if t == nil || len(mac) != 6 {
+		return ""
+	}
+	var value uint64
+	for _, b := range mac {
+		value = value<<8 | uint64(b)
+	}
+	for _, bits := range prefixBits {
+		if vendor, ok := t.prefixes[bits][value>>(48-bits)]; ok {
+			return vendor
+		}
+	}
+	return ""
+}
+
+// IsRandomized 判断 mac 是否是本地管理地址 , 手机等设备的随机 mac 都会置这一位
+func IsRandomized(mac net.HardwareAddr) bool {
+	return len(mac) > 0 && mac[0]&0x02 != 0
+}
+
+var (
+	defaultTable     atomic.Pointer[Table]
+	loadEmbeddedOnce sync.Once
+)
+
+func Default() *Table {
+	loadEmbeddedOnce.Do(func() {
+		if defaultTable.Load() != nil {
+			return
+		}
+		table, err := Parse(bytes.NewReader(embeddedTable))
+		if err != nil {
+			log.Printf("Load embedded oui table failed: %s", err)
+			table = newTable()
+		}
+		defaultTable.CompareAndSwap(nil, table)
+	})
+	return defaultTable.Load()
+}
+
+func SetDefault(table *Table) {
+	defaultTable.Store(table)
+}
+
+// LoadFile 从本地文件加载 oui 表并替换默认表
+func LoadFile(path string) (*Table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Open oui table %q failed: %w", path, err)
+	}
+	defer file.Close()
+	table, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("Parse oui table %q failed: %w", path, err)
+	}
+	SetDefault(table)
+	return table, nil
+}
+
+// Lookup 用默认表查询 , 随机 mac 没有厂商
+func Lookup(mac string) (vendor string, randomized bool) {
+	hardwareAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", false
+	}
+	if IsRandomized(hardwareAddr) {
+		return "", true
+	}
+	return Default().Lookup(hardwareAddr), false
+}
@@ -0,0 +1,69 @@
+package oui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormats(t *testing.T) {
+	cases := map[string]string{
+		"compact":  "# comment\n240AC4\tEspressif\n0050C2A\tTiny Vendor\n",
+		"manuf":    "24:0A:C4\tEspressif\tEspressif Inc.\n00:50:C2:A0:00:00/28\tTiny Vendor\n",
+		"ieee csv": "Registry,Assignment,Organization Name,Organization Address\nMA-L,240AC4,Espressif,\"Shanghai, CN\"\nMA-M,0050C2A,Tiny Vendor,Somewhere\n",
+		"ieee txt": "OUI/MA-L\t\t\tOrganization\n24-0A-C4   (hex)\t\tEspressif\n240AC4     (base 16)\t\tEspressif\n",
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			table, err := Parse(strings.NewReader(content))
+			assert.NoError(t, err)
+			assert.Equal(t, "Espressif", table.Lookup(net.HardwareAddr{0x24, 0x0a, 0xc4, 0x01, 0x02, 0x03}))
+		})
+	}
+
+	_, err := Parse(strings.NewReader("# nothing\n"))
+	assert.ErrorIs(t, err, ErrEmptyTable)
+}
+
+func TestLookupLongestPrefix(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, _ = writer.Write([]byte("0050C2\tIEEE Registration Authority\n0050C2A\tTiny Vendor\n0050C2A12\tTinier Vendor\n"))
+	_ = writer.Close()
+
+	table, err := Parse(&buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, table.Len())
+	assert.Equal(t, "Tinier Vendor", table.Lookup(net.HardwareAddr{0x00, 0x50, 0xc2, 0xa1, 0x2f, 0xff}))
+	assert.Equal(t, "Tiny Vendor", table.Lookup(net.HardwareAddr{0x00, 0x50, 0xc2, 0xa3, 0x00, 0x00}))
+	assert.Equal(t, "IEEE Registration Authority", table.Lookup(net.HardwareAddr{0x00, 0x50, 0xc2, 0x10, 0x00, 0x00}))
+	assert.Equal(t, "", table.Lookup(net.HardwareAddr{0x00, 0x50, 0xc3, 0x10, 0x00, 0x00}))
+}
+
+func TestDefaultLookup(t *testing.T) {
+	// 内置的是完整的 IEEE 注册表 , 不是挑选的一部分
+	assert.Greater(t, Default().Len(), 20000)
+	apple := 0
+	for _, vendor := range Default().prefixes[24] {
+		if vendor == "Apple" {
+			apple++
+		}
+	}
+	assert.Greater(t, apple, 500)
+
+	vendor, randomized := Lookup("b8:27:eb:12:34:56")
+	assert.Equal(t, "Raspberry Pi Foundation", vendor)
+	assert.False(t, randomized)
+
+	vendor, randomized = Lookup("da:a1:19:12:34:56")
+	assert.Equal(t, "", vendor)
+	assert.True(t, randomized)
+
+	vendor, randomized = Lookup("not a mac")
+	assert.Equal(t, "", vendor)
+	assert.False(t, randomized)
+}
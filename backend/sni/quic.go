@@ -0,0 +1,368 @@
+package sni
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	QuicVersion1 uint32 = 0x00000001
+	QuicVersion2 uint32 = 0x6b3343cf
+
+	quicMaxConnectionIdLength = 20
+	quicSampleLength          = 16
+	quicFrameTypePadding      = 0x00
+	quicFrameTypePing         = 0x01
+	quicFrameTypeAck          = 0x02
+	quicFrameTypeAckEcn       = 0x03
+	quicFrameTypeCrypto       = 0x06
+)
+
+var (
+	ErrNotQuicInitial = errors.New("not a quic initial packet")
+
+	quicV1InitialSalt = []byte{
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	}
+	quicV2InitialSalt = []byte{
+		0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9,
+	}
+)
+
+type quicInitialKeys struct {
+	key []byte
+	iv  []byte
+	hp  []byte
+}
+
+// RFC 8446 7.1 HKDF-Expand-Label
+func hkdfExpandLabel(secret []byte, label string, length int) ([]byte, error) {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 0, 4+len(fullLabel))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// RFC 9001 5.2 / RFC 9369 3.3 客户端 Initial 密钥只由目的连接 ID 决定
+func deriveQuicClientInitialKeys(version uint32, dcid []byte) (keys quicInitialKeys, err error) {
+	salt := quicV1InitialSalt
+	labelPrefix := "quic "
+	if version == QuicVersion2 {
+		salt = quicV2InitialSalt
+		labelPrefix = "quicv2 "
+	}
+
+	initialSecret, err := hkdf.Extract(sha256.New, dcid, salt)
+	if err != nil {
+		return keys, err
+	}
+	clientSecret, err := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+	if err != nil {
+		return keys, err
+	}
+	if keys.key, err = hkdfExpandLabel(clientSecret, labelPrefix+"key", 16); err != nil {
+		return keys, err
+	}
+	if keys.iv, err = hkdfExpandLabel(clientSecret, labelPrefix+"iv", 12); err != nil {
+		return keys, err
+	}
+	if keys.hp, err = hkdfExpandLabel(clientSecret, labelPrefix+"hp", 16); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+func isQuicInitial(version uint32, firstByte byte) bool {
+	packetType := (firstByte & 0x30) >> 4
+	switch version {
+	case QuicVersion1:
+		return packetType == 0
+	case QuicVersion2:
+		return packetType == 1
+	default:
+		return false
+	}
+}
+
+// DecryptQuicInitial 去掉客户端 Initial 包的头部保护并解密 , 返回目的连接 ID 和明文帧 ,
+// 一个 udp 报文里如果合并了多个 quic 包 , 只处理第一个
+func DecryptQuicInitial(packet []byte) (dcid []byte, frames []byte, err error) {
+	reader := byteReader{data: packet}
+	firstByte, ok := reader.uint8()
+	if !ok || firstByte&0xc0 != 0xc0 {
+		return nil, nil, ErrNotQuicInitial
+	}
+	rawVersion, ok := reader.bytes(4)
+	if !ok {
+		return nil, nil, ErrNotQuicInitial
+	}
+	version := binary.BigEndian.Uint32(rawVersion)
+	if !isQuicInitial(version, firstByte) {
+		return nil, nil, ErrNotQuicInitial
+	}
+
+	dcidLength, ok := reader.uint8()
+	if !ok || dcidLength > quicMaxConnectionIdLength {
+		return nil, nil, ErrNotQuicInitial
+	}
+	if dcid, ok = reader.bytes(int(dcidLength)); !ok {
+		return nil, nil, ErrTruncated
+	}
+	if !reader.skipVector(1) { // scid
+		return nil, nil, ErrTruncated
+	}
+	tokenLength, ok := reader.varint()
+	if !ok || !reader.skip(int(tokenLength)) {
+		return nil, nil, ErrTruncated
+	}
+	length, ok := reader.varint()
+	if !ok || int(length) > len(reader.data) || length < 4+quicSampleLength {
+		return nil, nil, ErrTruncated
+	}
+	packetNumberOffset := len(packet) - len(reader.data)
+	packetEnd := packetNumberOffset + int(length)
+
+	keys, err := deriveQuicClientInitialKeys(version, dcid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 去除头部保护 , 不修改调用方的数据
+	header := slices.Clone(packet[:packetNumberOffset+4])
+	hpBlock, err := aes.NewCipher(keys.hp)
+	if err != nil {
+		return nil, nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	sampleOffset := packetNumberOffset + 4
+	hpBlock.Encrypt(mask, packet[sampleOffset:sampleOffset+quicSampleLength])
+	header[0] ^= mask[0] & 0x0f
+	packetNumberLength := int(header[0]&0x03) + 1
+	for index := range packetNumberLength {
+		header[packetNumberOffset+index] ^= mask[1+index]
+	}
+	header = header[:packetNumberOffset+packetNumberLength]
+
+	var packetNumber uint64
+	for _, value := range header[packetNumberOffset:] {
+		packetNumber = packetNumber<<8 | uint64(value)
+	}
+	nonce := slices.Clone(keys.iv)
+	for index := range 8 {
+		nonce[len(nonce)-1-index] ^= byte(packetNumber >> (8 * index))
+	}
+
+	block, err := aes.NewCipher(keys.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	frames, err = aead.Open(nil, nonce, packet[packetNumberOffset+packetNumberLength:packetEnd], header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dcid, frames, nil
+}
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// 只取出 CRYPTO 帧 , 遇到不认识的帧就停止
+func extractCryptoFrames(frames []byte) []cryptoFrame {
+	var result []cryptoFrame
+	reader := byteReader{data: frames}
+	for len(reader.data) > 0 {
+		frameType, ok := reader.varint()
+		if !ok {
+			return result
+		}
+		switch frameType {
+		case quicFrameTypePadding, quicFrameTypePing:
+		case quicFrameTypeAck, quicFrameTypeAckEcn:
+			if !skipAckFrame(&reader, frameType == quicFrameTypeAckEcn) {
+				return result
+			}
+		case quicFrameTypeCrypto:
+			offset, ok := reader.varint()
+			if !ok {
+				return result
+			}
+			length, ok := reader.varint()
+			if !ok {
+				return result
+			}
+			data, ok := reader.bytes(int(length))
+			if !ok {
+				return result
+			}
+			result = append(result, cryptoFrame{offset: offset, data: data})
+		default:
+			return result
+		}
+	}
+	return result
+}
+
+func skipAckFrame(reader *byteReader, hasEcn bool) bool {
+	// largest acknowledged / ack delay / ack range count / first ack range
+	if _, ok := reader.varint(); !ok {
+		return false
+	}
+	if _, ok := reader.varint(); !ok {
+		return false
+	}
+	rangeCount, ok := reader.varint()
+	if !ok {
+		return false
+	}
+	if _, ok := reader.varint(); !ok {
+		return false
+	}
+	for range rangeCount * 2 {
+		if _, ok := reader.varint(); !ok {
+			return false
+		}
+	}
+	if hasEcn {
+		for range 3 {
+			if _, ok := reader.varint(); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type quicAssembly struct {
+	frames    []cryptoFrame
+	size      int
+	createdAt time.Time
+}
+
+// QuicAssembler 按目的连接 ID 重组多个 Initial 包里的 CRYPTO 帧 ,
+// 现在的浏览器 ClientHello 经常超过一个包 , 而且帧的顺序是打乱的
+type QuicAssembler struct {
+	maxConnections int
+	maxBytes       int
+	timeout        time.Duration
+	mutex          sync.Mutex
+	assemblies     map[string]*quicAssembly
+}
+
+func NewQuicAssembler(maxConnections int, maxBytes int, timeout time.Duration) *QuicAssembler {
+	return &QuicAssembler{
+		maxConnections: maxConnections,
+		maxBytes:       maxBytes,
+		timeout:        timeout,
+		assemblies:     make(map[string]*quicAssembly),
+	}
+}
+
+// Add 解密一个 Initial 包 , 能拿到 SNI 时返回 ok=true
+func (qa *QuicAssembler) Add(packet []byte, now time.Time) (serverName string, ok bool) {
+	dcid, frames, err := DecryptQuicInitial(packet)
+	if err != nil {
+		return "", false
+	}
+	cryptoFrames := extractCryptoFrames(frames)
+	if len(cryptoFrames) == 0 {
+		return "", false
+	}
+
+	qa.mutex.Lock()
+	defer qa.mutex.Unlock()
+	key := string(dcid)
+	assembly, exist := qa.assemblies[key]
+	if !exist {
+		if len(qa.assemblies) >= qa.maxConnections {
+			qa.cleanupLocked(now)
+			if len(qa.assemblies) >= qa.maxConnections {
+				return "", false
+			}
+		}
+		assembly = &quicAssembly{createdAt: now}
+		qa.assemblies[key] = assembly
+	}
+	for _, frame := range cryptoFrames {
+		if assembly.size+len(frame.data) > qa.maxBytes {
+			delete(qa.assemblies, key)
+			return "", false
+		}
+		// 帧的数据引用的是解密后的新切片 , 可以直接保存
+		assembly.frames = append(assembly.frames, frame)
+		assembly.size += len(frame.data)
+	}
+
+	data := assembly.contiguous()
+	if len(data) < tlsHandshakeHeaderLength {
+		// 还没收到 offset 0 开始的数据
+		return "", false
+	}
+	serverName, err = ParseClientHello(data)
+	if err == nil {
+		delete(qa.assemblies, key)
+		return serverName, true
+	}
+	if !errors.Is(err, ErrTruncated) {
+		delete(qa.assemblies, key)
+	}
+	return "", false
+}
+
+// 从 0 开始拼出连续的数据
+func (qa *quicAssembly) contiguous() []byte {
+	slices.SortFunc(qa.frames, func(a, b cryptoFrame) int {
+		switch {
+		case a.offset < b.offset:
+			return -1
+		case a.offset > b.offset:
+			return 1
+		default:
+			return 0
+		}
+	})
+	var result []byte
+	for _, frame := range qa.frames {
+		end := frame.offset + uint64(len(frame.data))
+		current := uint64(len(result))
+		if frame.offset > current {
+			break
+		}
+		if end <= current {
+			continue
+		}
+		result = append(result, frame.data[current-frame.offset:]...)
+	}
+	return result
+}
+
+func (qa *QuicAssembler) Cleanup(now time.Time) {
+	qa.mutex.Lock()
+	defer qa.mutex.Unlock()
+	qa.cleanupLocked(now)
+}
+
+func (qa *QuicAssembler) cleanupLocked(now time.Time) {
+	for key, assembly := range qa.assemblies {
+		if now.Sub(assembly.createdAt) > qa.timeout {
+			delete(qa.assemblies, key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package sni
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"openwrt-diskio-api/backend/sni/snitest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTlsRecords(t *testing.T) {
+	record := snitest.ClientHello(t, "Video.Example.COM")
+
+	serverName, err := ParseTlsRecords(record)
+	assert.NoError(t, err)
+	assert.Equal(t, "video.example.com", serverName)
+
+	_, err = ParseTlsRecords(record[:20])
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	_, err = ParseTlsRecords([]byte("GET / HTTP/1.1\r\n"))
+	assert.ErrorIs(t, err, ErrNotClientHello)
+}
+
+func TestDeriveQuicClientInitialKeys(t *testing.T) {
+	// RFC 9001 附录 A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	keys, err := deriveQuicClientInitialKeys(QuicVersion1, dcid)
+	assert.NoError(t, err)
+	assert.Equal(t, "1f369613dd76d5467730efcbe3b1a22d", hex.EncodeToString(keys.key))
+	assert.Equal(t, "fa044b2f42a3fd3b46fb255c", hex.EncodeToString(keys.iv))
+	assert.Equal(t, "9f50449e04a0e810283a1e9933adedd2", hex.EncodeToString(keys.hp))
+
+	// RFC 9369 附录 A.1
+	keys, err = deriveQuicClientInitialKeys(QuicVersion2, dcid)
+	assert.NoError(t, err)
+	assert.Equal(t, "8b1a0bc121284290a29e0971b5cd045d", hex.EncodeToString(keys.key))
+	assert.Equal(t, "91f73e2351d8fa91660e909f", hex.EncodeToString(keys.iv))
+	assert.Equal(t, "45b95e15235d6f45a6b19cbcb0294ba9", hex.EncodeToString(keys.hp))
+}
+
+// 按 RFC 9001 构造一个客户端 Initial 包 , packet number 固定 2 字节
+func newTestQuicInitial(t *testing.T, dcid []byte, packetNumber uint16, frames []byte) []byte {
+	keys, err := deriveQuicClientInitialKeys(QuicVersion1, dcid)
+	assert.NoError(t, err)
+
+	// 补齐到至少能取 sample 的长度
+	for len(frames) < 32 {
+		frames = append(frames, quicFrameTypePadding)
+	}
+	length := 2 + len(frames) + 16
+	header := []byte{0xc1, 0x00, 0x00, 0x00, 0x01, byte(len(dcid))}
+	header = append(header, dcid...)
+	header = append(header, 0x00, 0x00) // scid 长度 0 , token 长度 0
+	header = append(header, 0x40|byte(length>>8), byte(length))
+	packetNumberOffset := len(header)
+	header = binary.BigEndian.AppendUint16(header, packetNumber)
+
+	nonce := append([]byte{}, keys.iv...)
+	nonce[len(nonce)-1] ^= byte(packetNumber)
+	nonce[len(nonce)-2] ^= byte(packetNumber >> 8)
+	block, _ := aes.NewCipher(keys.key)
+	aead, _ := cipher.NewGCM(block)
+	packet := aead.Seal(append([]byte{}, header...), nonce, frames, header)
+
+	hpBlock, _ := aes.NewCipher(keys.hp)
+	mask := make([]byte, aes.BlockSize)
+	hpBlock.Encrypt(mask, packet[packetNumberOffset+4:packetNumberOffset+4+quicSampleLength])
+	packet[0] ^= mask[0] & 0x0f
+	packet[packetNumberOffset] ^= mask[1]
+	packet[packetNumberOffset+1] ^= mask[2]
+	return packet
+}
+
+func newTestCryptoFrame(offset int, data []byte) []byte {
+	frame := []byte{quicFrameTypeCrypto, 0x80 | byte(offset>>24), byte(offset >> 16), byte(offset >> 8), byte(offset)}
+	frame = append(frame, 0x40|byte(len(data)>>8), byte(len(data)))
+	return append(frame, data...)
+}
+
+func TestQuicAssembler(t *testing.T) {
+	record := snitest.ClientHello(t, "rr1.googlevideo.com")
+	handshake := record[tlsRecordHeaderLength:]
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	half := len(handshake) / 2
+
+	// 乱序发送 , 第一个包里没有 offset 0
+	second := newTestQuicInitial(t, dcid, 1, newTestCryptoFrame(half, handshake[half:]))
+	first := newTestQuicInitial(t, dcid, 0, append([]byte{quicFrameTypePing}, newTestCryptoFrame(0, handshake[:half])...))
+
+	assembler := NewQuicAssembler(16, 8192, time.Second)
+	now := time.Now()
+	_, ok := assembler.Add(second, now)
+	assert.False(t, ok)
+	serverName, ok := assembler.Add(first, now)
+	assert.True(t, ok)
+	assert.Equal(t, "rr1.googlevideo.com", serverName)
+	assert.Empty(t, assembler.assemblies)
+
+	// 被篡改的包解密失败
+	broken := newTestQuicInitial(t, dcid, 0, newTestCryptoFrame(0, handshake))
+	broken[len(broken)-1] ^= 0xff
+	_, ok = assembler.Add(broken, now)
+	assert.False(t, ok)
+
+	// 超时的半成品会被清理
+	_, ok = assembler.Add(second, now)
+	assert.False(t, ok)
+	assembler.Cleanup(now.Add(2 * time.Second))
+	assert.Empty(t, assembler.assemblies)
+}
@@ -0,0 +1,34 @@
+// Package snitest 提供解析 sni 的测试用数据
+package snitest
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// ClientHello 用标准库生成一个真实的 ClientHello record
+func ClientHello(t testing.TB, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		conn := tls.Client(client, &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12})
+		_ = conn.Handshake()
+		_ = client.Close()
+	}()
+
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("Read record header failed: %s", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatalf("Read record body failed: %s", err)
+	}
+	return append(header, body...)
+}
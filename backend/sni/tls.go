@@ -0,0 +1,203 @@
+package sni
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	tlsRecordTypeHandshake      = 0x16
+	tlsRecordHeaderLength       = 5
+	tlsHandshakeTypeClientHello = 0x01
+	tlsHandshakeHeaderLength    = 4
+	tlsExtensionServerName      = 0x0000
+	tlsServerNameTypeHostName   = 0x00
+)
+
+var (
+	ErrNotClientHello = errors.New("not a tls client hello")
+	ErrTruncated      = errors.New("client hello truncated")
+	ErrNoServerName   = errors.New("client hello has no server name")
+)
+
+// ParseTlsRecords 从 tcp 负载中取出 ClientHello 的 SNI ,
+// 负载可以包含多个 handshake record , 也可以只是被截断的第一个分段
+func ParseTlsRecords(payload []byte) (string, error) {
+	var handshake []byte
+	for len(payload) >= tlsRecordHeaderLength {
+		if payload[0] != tlsRecordTypeHandshake {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(payload[3:5]))
+		payload = payload[tlsRecordHeaderLength:]
+		if length > len(payload) {
+			handshake = append(handshake, payload...)
+			break
+		}
+		handshake = append(handshake, payload[:length]...)
+		payload = payload[length:]
+	}
+	if len(handshake) == 0 {
+		return "", ErrNotClientHello
+	}
+	return ParseClientHello(handshake)
+}
+
+// ParseClientHello 解析 handshake 消息 (不含 record 头) 中的 server_name 扩展 ,
+// quic 的 CRYPTO 帧里放的就是这种格式
+//
+// 大的 ClientHello (比如带有后量子 key_share) 可能被截断 , 这种情况下能解析到 SNI 就返回 ,
+// 解析不到返回 ErrTruncated
+func ParseClientHello(handshake []byte) (string, error) {
+	if len(handshake) < tlsHandshakeHeaderLength || handshake[0] != tlsHandshakeTypeClientHello {
+		return "", ErrNotClientHello
+	}
+	body := handshake[tlsHandshakeHeaderLength:]
+	bodyLength := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+	if bodyLength < len(body) {
+		body = body[:bodyLength]
+	}
+
+	reader := byteReader{data: body}
+	// legacy_version(2) + random(32)
+	if !reader.skip(34) {
+		return "", ErrTruncated
+	}
+	// session_id / cipher_suites / compression_methods
+	if !reader.skipVector(1) || !reader.skipVector(2) || !reader.skipVector(1) {
+		return "", ErrTruncated
+	}
+	if _, ok := reader.uint16(); !ok {
+		return "", ErrNoServerName
+	}
+
+	for {
+		extensionType, ok := reader.uint16()
+		if !ok {
+			break
+		}
+		extensionLength, ok := reader.uint16()
+		if !ok {
+			break
+		}
+		if extensionType != tlsExtensionServerName {
+			if !reader.skip(int(extensionLength)) {
+				break
+			}
+			continue
+		}
+
+		data, ok := reader.bytes(int(extensionLength))
+		if !ok {
+			break
+		}
+		return parseServerNameExtension(data)
+	}
+	if len(body) < bodyLength {
+		return "", ErrTruncated
+	}
+	return "", ErrNoServerName
+}
+
+func parseServerNameExtension(data []byte) (string, error) {
+	reader := byteReader{data: data}
+	if _, ok := reader.uint16(); !ok {
+		return "", ErrNoServerName
+	}
+	for {
+		nameType, ok := reader.uint8()
+		if !ok {
+			return "", ErrNoServerName
+		}
+		nameLength, ok := reader.uint16()
+		if !ok {
+			return "", ErrNoServerName
+		}
+		name, ok := reader.bytes(int(nameLength))
+		if !ok {
+			return "", ErrNoServerName
+		}
+		if nameType == tlsServerNameTypeHostName && len(name) > 0 {
+			return strings.ToLower(string(name)), nil
+		}
+	}
+}
+
+type byteReader struct {
+	data []byte
+}
+
+func (r *byteReader) skip(length int) bool {
+	if length < 0 || length > len(r.data) {
+		return false
+	}
+	r.data = r.data[length:]
+	return true
+}
+
+func (r *byteReader) bytes(length int) ([]byte, bool) {
+	if length < 0 || length > len(r.data) {
+		return nil, false
+	}
+	result := r.data[:length]
+	r.data = r.data[length:]
+	return result, true
+}
+
+func (r *byteReader) uint8() (uint8, bool) {
+	if len(r.data) < 1 {
+		return 0, false
+	}
+	result := r.data[0]
+	r.data = r.data[1:]
+	return result, true
+}
+
+func (r *byteReader) uint16() (uint16, bool) {
+	if len(r.data) < 2 {
+		return 0, false
+	}
+	result := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return result, true
+}
+
+// 长度前缀为 prefixLength 字节的向量
+func (r *byteReader) skipVector(prefixLength int) bool {
+	var length int
+	switch prefixLength {
+	case 1:
+		value, ok := r.uint8()
+		if !ok {
+			return false
+		}
+		length = int(value)
+	case 2:
+		value, ok := r.uint16()
+		if !ok {
+			return false
+		}
+		length = int(value)
+	default:
+		return false
+	}
+	return r.skip(length)
+}
+
+// quic 变长整数 (RFC 9000 16 节)
+func (r *byteReader) varint() (uint64, bool) {
+	if len(r.data) < 1 {
+		return 0, false
+	}
+	length := 1 << (r.data[0] >> 6)
+	if len(r.data) < length {
+		return 0, false
+	}
+	result := uint64(r.data[0] & 0x3f)
+	for index := 1; index < length; index++ {
+		result = result<<8 | uint64(r.data[index])
+	}
+	r.data = r.data[length:]
+	return result, true
+}
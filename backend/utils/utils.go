@@ -6,11 +6,14 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
 	"net/netip"
 	"openwrt-diskio-api/backend/model"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vishvananda/netlink"
 )
@@ -66,15 +69,28 @@ func TrimSubnetMask(cidr string) string {
 	return strings.Split(cidr, "/")[0]
 }
 
-// if "interval" == 0 , return -1
-func CalculateRate(now float64, last float64, interval uint) (rate float64) {
-	if interval == 0 {
-		return -1
+// CounterDelta 计算计数器两次读数的增量 . 32 位内核上 /proc/net/dev 和 /proc/diskstats 的计数是 32 位的 ,
+// 读数变小时 , 如果按 32 位溢出算出的增量不超过一半的范围就当作溢出 , 否则当作设备重置 , 返回 false
+func CounterDelta(now uint64, last uint64) (uint64, bool) {
+	if now >= last {
+		return now - last, true
+	}
+	if last > math.MaxUint32 {
+		return 0, false
+	}
+	wrapped := math.MaxUint32 - last + now + 1
+	if wrapped > math.MaxUint32/2 {
+		return 0, false
 	}
-	delta := now - last
-	rate = delta / float64(interval)
+	return wrapped, true
+}
 
-	return rate
+// RatePerSecond 用实际经过的时间计算每秒的速率 , elapsed 不大于 0 时返回 0
+func RatePerSecond(delta uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(delta) / elapsed.Seconds()
 }
 
 // if err , return 0 , "slice" must be all number and > 0
@@ -222,3 +238,12 @@ func GzipBytes(input []byte) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+func GunzipBytes(input []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"math"
 	"openwrt-diskio-api/backend/model"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -90,28 +92,34 @@ func TestTrimSubnetMask(t *testing.T) {
 		})
 	}
 }
-func TestCalculateRate(t *testing.T) {
+func TestCounterDelta(t *testing.T) {
 	testCases := []struct {
 		testName  string
-		input1    float64
-		input2    float64
-		input3    uint
-		expected1 float64
+		now       uint64
+		last      uint64
+		expected1 uint64
+		expected2 bool
 	}{
-		{"positive rate +100%", float64(200), float64(100), uint(1), float64(100)},
-		{"negative rate -100%", float64(100), float64(200), uint(1), float64(-100)},
-		{"positive rate with interval +25%", float64(200), float64(100), uint(4), float64(25)},
-		{"negative rate with interval -25%", float64(100), float64(200), uint(4), float64(-25)},
-		{"zero interval", float64(200), float64(100), uint(0), float64(-1)},
+		{"increase", 300, 100, 200, true},
+		{"unchanged", 100, 100, 0, true},
+		{"32 bit wrap", 99, math.MaxUint32 - 100, 200, true},
+		{"device reset", 10, 1000000, 0, false},
+		{"64 bit counter decrease", 10, math.MaxUint32 + 100, 0, false},
 	}
 	for _, cases := range testCases {
 		t.Run(cases.testName, func(t *testing.T) {
-			rate := CalculateRate(cases.input1, cases.input2, cases.input3)
-			assert.Equal(t, cases.expected1, rate)
+			delta, ok := CounterDelta(cases.now, cases.last)
+			assert.Equal(t, cases.expected1, delta)
+			assert.Equal(t, cases.expected2, ok)
 		})
 	}
 }
 
+func TestRatePerSecond(t *testing.T) {
+	assert.Equal(t, float64(400), RatePerSecond(1000, 2500*time.Millisecond))
+	assert.Equal(t, float64(0), RatePerSecond(1000, 0))
+}
+
 func TestSumUint64(t *testing.T) {
 	testCases := []struct {
 		testName  string
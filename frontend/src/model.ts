@@ -74,6 +74,15 @@ export interface Connection {
   state: string;
   traffic: Metric;
   packets: number;
+  destination_domain?: string;
+  server_name?: string;
+  destination_geo?: GeoIpInfo;
+}
+
+export interface GeoIpInfo {
+  country_code?: string;
+  asn?: number;
+  as_organization?: string;
 }
 
 export interface ConnectionApiResponse {
@@ -120,6 +129,11 @@ export interface MetricUnit {
 
 export interface AggregationTrafficDetails {
   ip: string;
+  domain?: string;
+  mac?: string;
+  vendor?: string;
+  randomized_mac?: boolean;
+  geo?: GeoIpInfo;
   ip_type: IpAddressType;
   ip_family: IpFamilyType;
   incoming: MetricUnit;
@@ -131,6 +145,15 @@ export interface AggregationTrafficDetails {
   tcp: number;
   udp: number;
   other: number;
+  services?: AggregationServiceTraffic[];
+}
+
+export interface AggregationServiceTraffic {
+  name: string;
+  incoming: MetricUnit;
+  outgoing: MetricUnit;
+  total_incoming: MetricUnit;
+  total_outgoing: MetricUnit;
 }
 
 export interface AggregationTrafficMetric {
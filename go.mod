@@ -4,6 +4,7 @@ go 1.25.4
 
 require (
 	github.com/cilium/ebpf v0.20.0
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/spf13/afero v1.15.0
 	github.com/stretchr/testify v1.11.1
 	github.com/vishvananda/netlink v1.3.1
@@ -4,3 +4,6 @@ set -euo pipefail
 cd frontend
 pnpm vite build --outDir ./dist/  --emptyOutDir
 
+# 文本类的文件只保留压缩后的 xxx.gz , 嵌入到程序里的只有一份 , 后端对不接受 gzip 的客户端解压后返回
+find ./dist -type f \( -name '*.html' -o -name '*.js' -o -name '*.css' -o -name '*.svg' -o -name '*.json' -o -name '*.webmanifest' \) \
+  -size +1k -exec gzip -9 -f {} \;